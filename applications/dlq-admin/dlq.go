@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+)
+
+// SQSAPI DLQの確認・再投入で使用するSQSクライアントのメソッド
+type SQSAPI interface {
+	GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// decoder メッセージから確認用の属性を取り出す
+type decoder func(msg types.Message) map[string]string
+
+// Target 確認・再投入の対象のキュー
+type Target struct {
+	// Queue 再投入先の元のキュー名(環境名を除く)
+	Queue string
+	// DLQ デッドレターキュー名(環境名を除く)
+	DLQ    string
+	decode decoder
+}
+
+const (
+	// receiveWaitTime DLQのメッセージ受信のロングポーリングの待機時間
+	receiveWaitTime = 2 * time.Second
+	// maxEmptyReceives DLQが空になったとみなす、メッセージのない受信の連続回数
+	maxEmptyReceives = 3
+)
+
+// targets -targetで指定できる対象
+var targets = map[string]Target{
+	"send-mail":          {Queue: "send-mail", DLQ: "send-mail-dlq", decode: decodeMail},
+	"fan-out-consumer-1": {Queue: "fan-out-consumer-1", DLQ: "fan-out-consumer-1-dlq", decode: decodeFanOut},
+	"fan-out-consumer-2": {Queue: "fan-out-consumer-2", DLQ: "fan-out-consumer-2-dlq", decode: decodeFanOut},
+}
+
+// queueName 環境ごとのキュー名を返す
+func queueName(env, name string) string {
+	return fmt.Sprintf("my-modern-application-sample-%s-%s", env, name)
+}
+
+// decodeMail メール送信キューのメッセージから本文(メールアドレス)とメッセージ属性を取り出す
+func decodeMail(msg types.Message) map[string]string {
+	attrs := map[string]string{"email": aws.ToString(msg.Body)}
+	for _, name := range []string{"campaign_id", "user_name", "bucket_name", "file_name", "version_id"} {
+		if v, ok := msg.MessageAttributes[name]; ok {
+			attrs[name] = aws.ToString(v.StringValue)
+		}
+	}
+	return attrs
+}
+
+// decodeFanOut ファンアウトのキューのメッセージからエンベロープとデータの項目を取り出す。
+// 取り出せない場合はdecode_errorに理由を設定する
+func decodeFanOut(msg types.Message) map[string]string {
+	env, err := event.FromSQSMessage(events.SQSMessage{Body: aws.ToString(msg.Body)})
+	if err != nil {
+		return map[string]string{"decode_error": err.Error()}
+	}
+	attrs := map[string]string{
+		"event_id":      env.ID,
+		"event_type":    env.Type,
+		"event_version": strconv.Itoa(env.Version),
+		"source":        env.Source,
+	}
+	var data map[string]any
+	if err := json.Unmarshal(env.Data, &data); err == nil {
+		for k, v := range data {
+			if _, exists := attrs[k]; !exists {
+				attrs[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	return attrs
+}
+
+// Message DLQのメッセージ
+type Message struct {
+	MessageID string `json:"message_id"`
+	// SentAt 元のキューへの送信日時
+	SentAt time.Time `json:"sent_at"`
+	// Age SentAtからの経過時間
+	Age          string            `json:"age"`
+	ReceiveCount int               `json:"receive_count"`
+	Attributes   map[string]string `json:"attributes"`
+	Body         string            `json:"body"`
+
+	raw types.Message
+	age time.Duration
+}
+
+// Filter 確認・再投入するメッセージの条件
+type Filter struct {
+	// MinAge 経過時間がこれ以上のメッセージ(0の場合は制限なし)
+	MinAge time.Duration
+	// MaxAge 経過時間がこれ以下のメッセージ(0の場合は制限なし)
+	MaxAge time.Duration
+	// Attributes 属性の値が全て一致するメッセージ
+	Attributes map[string]string
+}
+
+// Match メッセージが条件に一致するかどうかを返す
+func (f Filter) Match(msg Message) bool {
+	if f.MinAge > 0 && msg.age < f.MinAge {
+		return false
+	}
+	if f.MaxAge > 0 && msg.age > f.MaxAge {
+		return false
+	}
+	for k, v := range f.Attributes {
+		if msg.Attributes[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Admin DLQのメッセージの確認と元のキューへの再投入
+type Admin struct {
+	client   SQSAPI
+	target   Target
+	queueURL string
+	dlqURL   string
+	// visibilityTimeout 確認中のメッセージを他の受信者から隠す時間
+	visibilityTimeout time.Duration
+	now               func() time.Time
+	// received 受信中(不可視)のメッセージ
+	received []Message
+}
+
+// NewAdmin 対象のキューのURLを取得してAdminを生成する
+func NewAdmin(ctx context.Context, client SQSAPI, env string, target Target, visibilityTimeout time.Duration) (*Admin, error) {
+	queueURL, err := getQueueURL(ctx, client, queueName(env, target.Queue))
+	if err != nil {
+		return nil, err
+	}
+	dlqURL, err := getQueueURL(ctx, client, queueName(env, target.DLQ))
+	if err != nil {
+		return nil, err
+	}
+	return &Admin{
+		client:            client,
+		target:            target,
+		queueURL:          queueURL,
+		dlqURL:            dlqURL,
+		visibilityTimeout: visibilityTimeout,
+		now:               time.Now,
+	}, nil
+}
+
+func getQueueURL(ctx context.Context, client SQSAPI, name string) (string, error) {
+	out, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+	if err != nil {
+		return "", fmt.Errorf("キューのURL取得エラー: queue=%s: %w", name, err)
+	}
+	return aws.ToString(out.QueueUrl), nil
+}
+
+// List DLQのメッセージを空になるまで受信し、条件に一致するメッセージを返す。
+// 受信したメッセージは確認が終わるまで不可視になるため、最後にReleaseを呼び出す
+func (a *Admin) List(ctx context.Context, filter Filter) ([]Message, error) {
+	// 受信済みのメッセージのa.received・matchedでの位置
+	receivedAt := make(map[string]int)
+	matchedAt := make(map[string]int)
+	var matched []Message
+	empty := 0
+	for {
+		// ロングポーリングでも一部のサーバーのメッセージしか返らないことがあるため、
+		// 空の受信がmaxEmptyReceives回続いた時点でDLQが空になったとみなす
+		out, err := a.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(a.dlqURL),
+			MaxNumberOfMessages:         10,
+			VisibilityTimeout:           int32(a.visibilityTimeout / time.Second),
+			WaitTimeSeconds:             int32(receiveWaitTime / time.Second),
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll},
+			MessageAttributeNames:       []string{"All"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("DLQのメッセージ受信エラー: %w", err)
+		}
+		if len(out.Messages) == 0 {
+			empty++
+			if empty >= maxEmptyReceives {
+				return matched, nil
+			}
+			continue
+		}
+		empty = 0
+
+		for _, raw := range out.Messages {
+			id := aws.ToString(raw.MessageId)
+			if i, ok := receivedAt[id]; ok {
+				// 確認中に可視性タイムアウトを過ぎて再受信した場合、古い受信ハンドルでは削除・可視性の変更ができないため置き換える
+				a.received[i].raw.ReceiptHandle = raw.ReceiptHandle
+				if j, ok := matchedAt[id]; ok {
+					matched[j].raw.ReceiptHandle = raw.ReceiptHandle
+				}
+				continue
+			}
+
+			msg := a.newMessage(raw)
+			receivedAt[id] = len(a.received)
+			a.received = append(a.received, msg)
+			if filter.Match(msg) {
+				matchedAt[id] = len(matched)
+				matched = append(matched, msg)
+			}
+		}
+	}
+}
+
+// newMessage 受信したメッセージを確認用のメッセージにする
+func (a *Admin) newMessage(raw types.Message) Message {
+	msg := Message{
+		MessageID:  aws.ToString(raw.MessageId),
+		Body:       aws.ToString(raw.Body),
+		Attributes: a.target.decode(raw),
+		raw:        raw,
+	}
+	if ms, err := strconv.ParseInt(raw.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+		msg.SentAt = time.UnixMilli(ms).UTC()
+		msg.age = a.now().Sub(msg.SentAt)
+		msg.Age = msg.age.Truncate(time.Second).String()
+	}
+	if n, err := strconv.Atoi(raw.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)]); err == nil {
+		msg.ReceiveCount = n
+	}
+	return msg
+}
+
+// Redrive メッセージを本文・メッセージ属性を変えずに元のキューへ送信し、DLQから削除する。
+// dryRunの場合は送信・削除せずにログに出力する。再投入した件数を返す
+func (a *Admin) Redrive(ctx context.Context, msgs []Message, dryRun bool) (int, error) {
+	redriven := 0
+	for _, msg := range msgs {
+		if dryRun {
+			log.Printf("[dry-run] 再投入対象: message_id=%s, attributes=%v", msg.MessageID, msg.Attributes)
+			continue
+		}
+
+		if _, err := a.client.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:          aws.String(a.queueURL),
+			MessageBody:       msg.raw.Body,
+			MessageAttributes: msg.raw.MessageAttributes,
+		}); err != nil {
+			return redriven, fmt.Errorf("元のキューへの送信エラー: message_id=%s: %w", msg.MessageID, err)
+		}
+		// 送信後に削除に失敗した場合は重複して再投入される可能性があるが、各コンシューマーは重複を許容する
+		if _, err := a.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(a.dlqURL),
+			ReceiptHandle: msg.raw.ReceiptHandle,
+		}); err != nil {
+			return redriven, fmt.Errorf("DLQからの削除エラー: message_id=%s: %w", msg.MessageID, err)
+		}
+		a.forget(msg.MessageID)
+		redriven++
+		log.Printf("再投入完了: message_id=%s", msg.MessageID)
+	}
+	return redriven, nil
+}
+
+// forget 受信中のメッセージから除く
+func (a *Admin) forget(messageID string) {
+	for i, msg := range a.received {
+		if msg.MessageID == messageID {
+			a.received = append(a.received[:i], a.received[i+1:]...)
+			return
+		}
+	}
+}
+
+// Release 受信中のメッセージの可視性タイムアウトを0にし、DLQで再び受信できるようにする
+func (a *Admin) Release(ctx context.Context) error {
+	for _, msg := range a.received {
+		if _, err := a.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(a.dlqURL),
+			ReceiptHandle:     msg.raw.ReceiptHandle,
+			VisibilityTimeout: 0,
+		}); err != nil {
+			return fmt.Errorf("可視性タイムアウトの変更エラー: message_id=%s: %w", msg.MessageID, err)
+		}
+	}
+	a.received = nil
+	return nil
+}
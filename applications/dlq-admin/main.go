@@ -0,0 +1,119 @@
+// メール送信キュー・ファンアウトのキューのデッドレターキュー(DLQ)のメッセージを確認し、元のキューへ再投入するコマンド
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// attributeFlags -filterで指定された属性の条件(属性名=値)
+type attributeFlags map[string]string
+
+func (f attributeFlags) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f attributeFlags) Set(v string) error {
+	name, value, found := strings.Cut(v, "=")
+	if !found || name == "" {
+		return fmt.Errorf("属性名=値の形式で指定してください: %q", v)
+	}
+	f[name] = value
+	return nil
+}
+
+// Output コマンドの出力
+type Output struct {
+	Target   string    `json:"target"`
+	Messages []Message `json:"messages"`
+	// Redriven 再投入した件数(-redriveを指定した場合)
+	Redriven int  `json:"redriven"`
+	DryRun   bool `json:"dry_run"`
+}
+
+// targetNames -targetで指定できる対象を名前順に返す
+func targetNames() []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func main() {
+	env := flag.String("env", "", "環境名(必須)")
+	targetName := flag.String("target", "", "対象のキュー("+strings.Join(targetNames(), "/")+")")
+	minAge := flag.Duration("min-age", 0, "経過時間がこれ以上のメッセージに絞り込む")
+	maxAge := flag.Duration("max-age", 0, "経過時間がこれ以下のメッセージに絞り込む")
+	redrive := flag.Bool("redrive", false, "条件に一致するメッセージを元のキューへ再投入する")
+	dryRun := flag.Bool("dry-run", false, "-redriveで再投入せずに対象を表示する")
+	visibilityTimeout := flag.Duration("visibility-timeout", 5*time.Minute, "確認中のメッセージを他の受信者から隠す時間")
+	filters := attributeFlags{}
+	flag.Var(filters, "filter", "属性で絞り込む(例: email=user@example.com, event_type=FileUploaded)。複数指定可")
+	flag.Parse()
+
+	if *env == "" {
+		log.Fatalf("-envは必須です")
+	}
+	target, ok := targets[*targetName]
+	if !ok {
+		log.Fatalf("-targetが不正です: %q (%s)", *targetName, strings.Join(targetNames(), "/"))
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	admin, err := NewAdmin(ctx, sqs.NewFromConfig(cfg), *env, target, *visibilityTimeout)
+	if err != nil {
+		log.Fatalf("キューの取得に失敗しました: %v", err)
+	}
+	output, runErr := run(ctx, admin, Filter{MinAge: *minAge, MaxAge: *maxAge, Attributes: filters}, *redrive, *dryRun)
+	output.Target = *targetName
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		log.Fatalf("結果の出力に失敗しました: %v", err)
+	}
+
+	if runErr != nil {
+		log.Fatalf("DLQの処理に失敗しました: %v", runErr)
+	}
+}
+
+// run 条件に一致するメッセージを一覧し、redriveの場合は元のキューへ再投入する。
+// 再投入しなかったメッセージは最後にDLQで再び受信できるようにする
+func run(ctx context.Context, admin *Admin, filter Filter, redrive, dryRun bool) (output Output, err error) {
+	output.DryRun = redrive && dryRun
+	defer func() {
+		if releaseErr := admin.Release(context.WithoutCancel(ctx)); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}()
+
+	output.Messages, err = admin.List(ctx, filter)
+	if err != nil {
+		return output, err
+	}
+	log.Printf("条件に一致するメッセージ: %d件", len(output.Messages))
+
+	if redrive {
+		output.Redriven, err = admin.Redrive(ctx, output.Messages, dryRun)
+	}
+	return output, err
+}
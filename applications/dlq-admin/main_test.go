@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/fanout"
+)
+
+// memoryMessage インメモリのキューのメッセージ
+type memoryMessage struct {
+	msg            types.Message
+	sentAt         time.Time
+	receiveCount   int
+	receiptHandle  string
+	invisibleUntil time.Time
+}
+
+// memorySQS テスト用のインメモリのSQS。キューのURLはキュー名と同じ
+type memorySQS struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	queues map[string][]*memoryMessage
+	// emptyReceives 受信ごとにメッセージがあっても空を返すかどうか(一部のサーバーしか参照しなかった場合)
+	emptyReceives []bool
+	// waitTimes 受信時のロングポーリングの待機時間
+	waitTimes []int32
+	// advances 受信ごとに受信後に進める時間
+	advances []time.Duration
+}
+
+func newMemorySQS(now time.Time, queueNames ...string) *memorySQS {
+	s := &memorySQS{now: now, queues: make(map[string][]*memoryMessage)}
+	for _, name := range queueNames {
+		s.queues[name] = nil
+	}
+	return s
+}
+
+func (s *memorySQS) GetQueueUrl(_ context.Context, params *sqs.GetQueueUrlInput, _ ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.queues[aws.ToString(params.QueueName)]; !ok {
+		return nil, fmt.Errorf("queue does not exist: %s", aws.ToString(params.QueueName))
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: params.QueueName}, nil
+}
+
+func (s *memorySQS) ReceiveMessage(_ context.Context, params *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waitTimes = append(s.waitTimes, params.WaitTimeSeconds)
+	if len(s.advances) > 0 {
+		advance := s.advances[0]
+		s.advances = s.advances[1:]
+		defer func() { s.now = s.now.Add(advance) }()
+	}
+	out := &sqs.ReceiveMessageOutput{}
+	if len(s.emptyReceives) > 0 {
+		empty := s.emptyReceives[0]
+		s.emptyReceives = s.emptyReceives[1:]
+		if empty {
+			return out, nil
+		}
+	}
+	for _, m := range s.queues[aws.ToString(params.QueueUrl)] {
+		if len(out.Messages) == int(params.MaxNumberOfMessages) {
+			break
+		}
+		if s.now.Before(m.invisibleUntil) {
+			continue
+		}
+		s.seq++
+		m.receiveCount++
+		m.receiptHandle = "receipt-" + strconv.Itoa(s.seq)
+		m.invisibleUntil = s.now.Add(time.Duration(params.VisibilityTimeout) * time.Second)
+
+		msg := m.msg
+		msg.ReceiptHandle = aws.String(m.receiptHandle)
+		msg.Attributes = map[string]string{
+			string(types.MessageSystemAttributeNameSentTimestamp):           strconv.FormatInt(m.sentAt.UnixMilli(), 10),
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): strconv.Itoa(m.receiveCount),
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+	return out, nil
+}
+
+func (s *memorySQS) SendMessage(_ context.Context, params *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	id := "msg-" + strconv.Itoa(s.seq)
+	s.push(aws.ToString(params.QueueUrl), types.Message{MessageId: aws.String(id), Body: params.MessageBody, MessageAttributes: params.MessageAttributes}, s.now)
+	return &sqs.SendMessageOutput{MessageId: aws.String(id)}, nil
+}
+
+func (s *memorySQS) DeleteMessage(_ context.Context, params *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	url := aws.ToString(params.QueueUrl)
+	for i, m := range s.queues[url] {
+		if m.receiptHandle == aws.ToString(params.ReceiptHandle) {
+			s.queues[url] = append(s.queues[url][:i], s.queues[url][i+1:]...)
+			return &sqs.DeleteMessageOutput{}, nil
+		}
+	}
+	return nil, fmt.Errorf("receipt handle is invalid: %s", aws.ToString(params.ReceiptHandle))
+}
+
+func (s *memorySQS) ChangeMessageVisibility(_ context.Context, params *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.queues[aws.ToString(params.QueueUrl)] {
+		if m.receiptHandle == aws.ToString(params.ReceiptHandle) {
+			m.invisibleUntil = s.now.Add(time.Duration(params.VisibilityTimeout) * time.Second)
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		}
+	}
+	return nil, fmt.Errorf("receipt handle is invalid: %s", aws.ToString(params.ReceiptHandle))
+}
+
+// push メッセージをキューに追加する
+func (s *memorySQS) push(url string, msg types.Message, sentAt time.Time) {
+	s.queues[url] = append(s.queues[url], &memoryMessage{msg: msg, sentAt: sentAt})
+}
+
+// visible 受信可能なメッセージの本文を返す
+func (s *memorySQS) visible(url string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bodies []string
+	for _, m := range s.queues[url] {
+		if !s.now.Before(m.invisibleUntil) {
+			bodies = append(bodies, aws.ToString(m.msg.Body))
+		}
+	}
+	sort.Strings(bodies)
+	return bodies
+}
+
+// newMailMessage send-messageと同じ形式のメール送信メッセージを生成する
+func newMailMessage(id, email, userName string) types.Message {
+	attr := func(v string) types.MessageAttributeValue {
+		return types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+	}
+	return types.Message{
+		MessageId: aws.String(id),
+		Body:      aws.String(email),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"user_name":   attr(userName),
+			"bucket_name": attr("contents"),
+			"file_name":   attr("mail-body.txt"),
+			"version_id":  attr("v1"),
+		},
+	}
+}
+
+const (
+	testMailQueue = "my-modern-application-sample-test-send-mail"
+	testMailDLQ   = "my-modern-application-sample-test-send-mail-dlq"
+)
+
+// newMailEnv 経過時間の異なる3件のメッセージがDLQにあるメール送信キューを用意する
+func newMailEnv(t *testing.T) (*memorySQS, *Admin) {
+	t.Helper()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := newMemorySQS(now, testMailQueue, testMailDLQ)
+	client.push(testMailDLQ, newMailMessage("dlq-1", "a@example.com", "a"), now.Add(-3*time.Hour))
+	client.push(testMailDLQ, newMailMessage("dlq-2", "b@example.com", "b"), now.Add(-2*time.Hour))
+	client.push(testMailDLQ, newMailMessage("dlq-3", "c@example.com", "c"), now.Add(-10*time.Minute))
+
+	admin, err := NewAdmin(context.Background(), client, "test", targets["send-mail"], time.Minute)
+	if err != nil {
+		t.Fatalf("NewAdmin() returned an error: %v", err)
+	}
+	admin.now = func() time.Time { return now }
+	return client, admin
+}
+
+func messageIDs(msgs []Message) []string {
+	var ids []string
+	for _, msg := range msgs {
+		ids = append(ids, msg.MessageID)
+	}
+	return ids
+}
+
+func TestListMail(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "all", want: []string{"dlq-1", "dlq-2", "dlq-3"}},
+		{name: "min age", filter: Filter{MinAge: time.Hour}, want: []string{"dlq-1", "dlq-2"}},
+		{name: "max age", filter: Filter{MaxAge: 150 * time.Minute}, want: []string{"dlq-2", "dlq-3"}},
+		{name: "attribute", filter: Filter{Attributes: map[string]string{"email": "b@example.com"}}, want: []string{"dlq-2"}},
+		{name: "no match", filter: Filter{Attributes: map[string]string{"user_name": "z"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, admin := newMailEnv(t)
+			msgs, err := admin.List(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("List() returned an error: %v", err)
+			}
+			if got := messageIDs(msgs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List() = %v, want %v", got, tt.want)
+			}
+
+			// 確認後はDLQで再び受信できること
+			if err := admin.Release(context.Background()); err != nil {
+				t.Fatalf("Release() returned an error: %v", err)
+			}
+			if got := client.visible(testMailDLQ); len(got) != 3 {
+				t.Errorf("visible DLQ messages = %v, want 3", got)
+			}
+		})
+	}
+}
+
+func TestListMailContinuesAfterEmptyReceive(t *testing.T) {
+	client, admin := newMailEnv(t)
+	// メッセージが残っているのに空の受信が続いても、DLQが空になったとみなさずに受信を続けること
+	client.emptyReceives = []bool{true, true}
+
+	msgs, err := admin.List(context.Background(), Filter{})
+	if err != nil {
+		t.Fatalf("List() returned an error: %v", err)
+	}
+	if got, want := messageIDs(msgs), []string{"dlq-1", "dlq-2", "dlq-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+	for _, wait := range client.waitTimes {
+		if wait == 0 {
+			t.Errorf("ReceiveMessage() was called without long polling: %v", client.waitTimes)
+			break
+		}
+	}
+}
+
+func TestListMailDecodesAttributes(t *testing.T) {
+	_, admin := newMailEnv(t)
+	msgs, err := admin.List(context.Background(), Filter{Attributes: map[string]string{"email": "a@example.com"}})
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("List() = %v, %v, want 1 message", msgs, err)
+	}
+
+	want := map[string]string{"email": "a@example.com", "user_name": "a", "bucket_name": "contents", "file_name": "mail-body.txt", "version_id": "v1"}
+	if !reflect.DeepEqual(msgs[0].Attributes, want) {
+		t.Errorf("Attributes = %v, want %v", msgs[0].Attributes, want)
+	}
+	if msgs[0].Age != "3h0m0s" || msgs[0].ReceiveCount != 1 {
+		t.Errorf("Age = %s, ReceiveCount = %d, want 3h0m0s, 1", msgs[0].Age, msgs[0].ReceiveCount)
+	}
+}
+
+func TestRunRedrive(t *testing.T) {
+	client, admin := newMailEnv(t)
+
+	output, err := run(context.Background(), admin, Filter{MinAge: time.Hour}, true, false)
+	if err != nil {
+		t.Fatalf("run() returned an error: %v", err)
+	}
+	if output.Redriven != 2 {
+		t.Errorf("Redriven = %d, want %d", output.Redriven, 2)
+	}
+
+	// 条件に一致したメッセージだけが元のキューへ移り、それ以外はDLQで再び受信できること
+	if got, want := client.visible(testMailQueue), []string{"a@example.com", "b@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+	if got, want := client.visible(testMailDLQ), []string{"c@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DLQ = %v, want %v", got, want)
+	}
+
+	// メッセージ属性を変えずに再投入すること
+	for _, m := range client.queues[testMailQueue] {
+		if aws.ToString(m.msg.MessageAttributes["file_name"].StringValue) != "mail-body.txt" {
+			t.Errorf("MessageAttributes = %v, want file_name", m.msg.MessageAttributes)
+		}
+	}
+}
+
+func TestRunRedriveAfterVisibilityTimeout(t *testing.T) {
+	client, admin := newMailEnv(t)
+	// 確認中に可視性タイムアウトを過ぎ、同じメッセージが新しい受信ハンドルで再受信される
+	client.advances = []time.Duration{0, time.Minute}
+
+	output, err := run(context.Background(), admin, Filter{}, true, false)
+	if err != nil {
+		t.Fatalf("run() returned an error: %v", err)
+	}
+	if output.Redriven != 3 {
+		t.Errorf("Redriven = %d, want %d", output.Redriven, 3)
+	}
+	if got := client.visible(testMailDLQ); len(got) != 0 {
+		t.Errorf("DLQ = %v, want empty", got)
+	}
+}
+
+func TestRunRedriveDryRun(t *testing.T) {
+	client, admin := newMailEnv(t)
+
+	output, err := run(context.Background(), admin, Filter{}, true, true)
+	if err != nil {
+		t.Fatalf("run() returned an error: %v", err)
+	}
+	if !output.DryRun || output.Redriven != 0 || len(output.Messages) != 3 {
+		t.Errorf("output = %+v, want dry run with 3 messages", output)
+	}
+	if got := client.visible(testMailQueue); len(got) != 0 {
+		t.Errorf("queue = %v, want empty", got)
+	}
+	if got := client.visible(testMailDLQ); len(got) != 3 {
+		t.Errorf("DLQ = %v, want 3 messages", got)
+	}
+}
+
+func TestListFanOut(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	queue, dlq := "my-modern-application-sample-test-fan-out-consumer-1", "my-modern-application-sample-test-fan-out-consumer-1-dlq"
+	client := newMemorySQS(now, queue, dlq)
+
+	env, err := event.New(fanout.EventFileUploaded, fanout.DataVersion, "test", fanout.FileUploaded{BucketName: "contents", FileName: "a.txt"})
+	if err != nil {
+		t.Fatalf("New() returned an error: %v", err)
+	}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Marshal() returned an error: %v", err)
+	}
+	notification, err := json.Marshal(events.SNSEntity{Type: "Notification", Message: string(raw)})
+	if err != nil {
+		t.Fatalf("Marshal() returned an error: %v", err)
+	}
+	client.push(dlq, types.Message{MessageId: aws.String("dlq-1"), Body: aws.String(string(notification))}, now)
+	client.push(dlq, types.Message{MessageId: aws.String("dlq-2"), Body: aws.String("not json")}, now)
+
+	admin, err := NewAdmin(context.Background(), client, "test", targets["fan-out-consumer-1"], time.Minute)
+	if err != nil {
+		t.Fatalf("NewAdmin() returned an error: %v", err)
+	}
+	msgs, err := admin.List(context.Background(), Filter{})
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("List() = %v, %v, want 2 messages", msgs, err)
+	}
+
+	want := map[string]string{
+		"event_id": env.ID, "event_type": fanout.EventFileUploaded, "event_version": "1", "source": "test",
+		"bucket_name": "contents", "file_name": "a.txt",
+	}
+	if !reflect.DeepEqual(msgs[0].Attributes, want) {
+		t.Errorf("Attributes = %v, want %v", msgs[0].Attributes, want)
+	}
+	// 取り出せないメッセージは理由を属性に設定すること
+	if msgs[1].Attributes["decode_error"] == "" {
+		t.Errorf("Attributes = %v, want decode_error", msgs[1].Attributes)
+	}
+}
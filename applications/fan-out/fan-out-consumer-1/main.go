@@ -2,14 +2,62 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"os"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/fanout"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
 )
 
-func handler(ctx context.Context) (string, error) {
-	return "fan-out-consumer-1", nil
+// consumerName コンシューマー名(処理済みのイベントIDのテーブル名にも使用)
+const consumerName = "fan-out-consumer-1"
+
+// イベント種別ごとのハンドラーへの振り分け
+var consumer = fanout.NewConsumer(consumerName, map[string]fanout.Handler{
+	fanout.EventUserRegistered: fanout.On(handleUserRegistered),
+	fanout.EventFileUploaded:   fanout.On(handleFileUploaded),
+})
+
+// handleUserRegistered ユーザー登録イベントを処理する
+func handleUserRegistered(_ context.Context, env event.Envelope, data fanout.UserRegistered) error {
+	log.Printf("ユーザー登録イベントを受信しました: id=%s, user_id=%d, user_name=%s", env.ID, data.UserID, data.UserName)
+	return nil
+}
+
+// handleFileUploaded ファイルのアップロードイベントを処理する
+func handleFileUploaded(_ context.Context, env event.Envelope, data fanout.FileUploaded) error {
+	log.Printf("ファイルのアップロードイベントを受信しました: id=%s, bucket_name=%s, file_name=%s", env.ID, data.BucketName, data.FileName)
+	return nil
+}
+
+// handler SQSキューからファンアウトされたイベントを受信し、イベント種別ごとに処理する。失敗したメッセージだけを再配信させる
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	return consumer.Handle(ctx, sqsEvent), nil
 }
 
 func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	// 処理済みのイベントIDはコンシューマーごとのテーブルに記録する
+	dedupTable := fmt.Sprintf("my-modern-application-sample-%s-%s-processed-events", env, consumerName)
+	consumer.Dedup = idempotency.NewGuard(idempotency.NewDynamoDBStore(dynamodb.NewFromConfig(cfg), dedupTable), fanout.DedupTTL)
+
 	lambda.Start(handler)
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/fanout"
+)
+
+// source エンベロープに設定する発行元
+const source = "fan-out-publisher"
+
+// ファンアウト用のSNSトピックへのイベントの発行
+var publisher *fanout.Publisher
+
+// handler 直接呼び出しで受け取ったイベントをスキーマで検証し、ファンアウト用のSNSトピックに発行する
+func handler(ctx context.Context, input fanout.PublishInput) (*event.Envelope, error) {
+	env, err := publisher.Publish(ctx, input)
+	if err != nil {
+		log.Printf("イベントの発行中にエラーが発生しました: type=%s, error=%v", input.Type, err)
+		return nil, err
+	}
+	return &env, nil
+}
+
+func main() {
+	// 環境変数の読み込み
+	topicArn := os.Getenv("FAN_OUT_TOPIC_ARN")
+	if topicArn == "" {
+		log.Fatalf("Environment variable FAN_OUT_TOPIC_ARN is required")
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	publisher = fanout.NewPublisher(event.NewSNSPublisher(sns.NewFromConfig(cfg), topicArn), source)
+
+	lambda.Start(handler)
+}
@@ -18,7 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
-	sestypes "github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/mail"
 )
 
 // DynamoDBクライアント
@@ -27,8 +27,8 @@ var dynamodbClient *dynamodb.Client
 // S3クライアント
 var s3Client *s3.Client
 
-// SESクライアント
-var sesClient *ses.Client
+// メール送信
+var mailSender *mail.Sender
 
 // 環境変数
 var (
@@ -84,31 +84,13 @@ func nextSeq(ctx context.Context, tableName string) (int64, error) {
 
 // メール送信関数
 func sendmail(ctx context.Context, to, subject, body string) error {
-	input := &ses.SendEmailInput{
-		Source: aws.String(mailFrom),
-		ReplyToAddresses: []string{
-			mailFrom,
-		},
-		Destination: &sestypes.Destination{
-			ToAddresses: []string{
-				to,
-			},
-		},
-		Message: &sestypes.Message{
-			Subject: &sestypes.Content{
-				Data:    aws.String(subject),
-				Charset: aws.String("UTF-8"),
-			},
-			Body: &sestypes.Body{
-				Text: &sestypes.Content{
-					Data:    aws.String(body),
-					Charset: aws.String("UTF-8"),
-				},
-			},
-		},
-	}
-
-	_, err := sesClient.SendEmail(ctx, input)
+	_, err := mailSender.Send(ctx, &mail.Email{
+		From:    mailFrom,
+		To:      []string{to},
+		ReplyTo: []string{mailFrom},
+		Subject: subject,
+		Text:    body,
+	}, nil)
 	if err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
@@ -292,7 +274,7 @@ func main() {
 	s3Client = s3.NewFromConfig(cfg)
 
 	// SESクライアントを初期化
-	sesClient = ses.NewFromConfig(cfg)
+	mailSender = mail.NewSender(ses.NewFromConfig(cfg))
 
 	lambda.Start(handler)
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/choreography"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/sqsbatch"
+)
+
+// イベントを受けてステップを実行するサービス
+var participant *choreography.Participant
+
+// handler 注文イベントで在庫を引き当て、ポイント付与完了イベントで確定し、決済失敗・取消イベントで解放する。失敗したメッセージだけを再配信させる
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	return sqsbatch.Process(ctx, sqsEvent, handleMessage), nil
+}
+
+// handleMessage SQSメッセージからイベントを取り出して処理する
+func handleMessage(ctx context.Context, record events.SQSMessage) error {
+	env, err := event.FromSQSMessage(record)
+	if err != nil {
+		log.Printf("イベントの変換に失敗しました: message_id=%s, error=%v", record.MessageId, err)
+		return err
+	}
+	if err := participant.Handle(ctx, env, event.ReceiveCount(record)); err != nil {
+		log.Printf("イベントの処理中にエラーが発生しました: type=%s, id=%s, error=%v", env.Type, env.ID, err)
+		return err
+	}
+	return nil
+}
+
+func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	topicArn := os.Getenv("SAGA_EVENTS_TOPIC_ARN")
+	if topicArn == "" {
+		log.Fatalf("Environment variable SAGA_EVENTS_TOPIC_ARN is required")
+	}
+
+	// 引当の有効期限(未設定の場合は既定値)
+	ttl := inventory.DefaultReservationTTL
+	if v := os.Getenv("RESERVATION_TTL"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			log.Fatalf("Environment variable RESERVATION_TTL is invalid: %q", v)
+		}
+		ttl = parsed
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	sagaStateTable := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	store := inventory.NewDynamoDBStore(
+		dynamoClient,
+		fmt.Sprintf("my-modern-application-sample-%s-inventory", env),
+		fmt.Sprintf("my-modern-application-sample-%s-inventory-reservations", env),
+	)
+	handlers := &steps.Handlers{
+		Inventory: inventory.NewService(store, ttl),
+		Recorder:  saga.NewRecorder(saga.NewDynamoDBStore(dynamoClient, sagaStateTable)),
+	}
+	publisher := event.NewSNSPublisher(sns.NewFromConfig(cfg), topicArn)
+	participant = choreography.NewInventoryParticipant(handlers, publisher)
+
+	lambda.Start(handler)
+}
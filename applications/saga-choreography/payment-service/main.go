@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/choreography"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/sqsbatch"
+)
+
+// イベントを受けてステップを実行するサービス
+var participant *choreography.Participant
+
+// handler 注文受付イベントで決済し、購入履歴の作成失敗・購入履歴削除イベントで決済を取り消す。失敗したメッセージだけを再配信させる
+func handler(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	return sqsbatch.Process(ctx, sqsEvent, handleMessage), nil
+}
+
+// handleMessage SQSメッセージからイベントを取り出して処理する
+func handleMessage(ctx context.Context, record events.SQSMessage) error {
+	env, err := event.FromSQSMessage(record)
+	if err != nil {
+		log.Printf("イベントの変換に失敗しました: message_id=%s, error=%v", record.MessageId, err)
+		return err
+	}
+	if err := participant.Handle(ctx, env, event.ReceiveCount(record)); err != nil {
+		log.Printf("イベントの処理中にエラーが発生しました: type=%s, id=%s, error=%v", env.Type, env.ID, err)
+		return err
+	}
+	return nil
+}
+
+func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	topicArn := os.Getenv("SAGA_EVENTS_TOPIC_ARN")
+	if topicArn == "" {
+		log.Fatalf("Environment variable SAGA_EVENTS_TOPIC_ARN is required")
+	}
+
+	// 決済ゲートウェイの動作モード(実ゲートウェイ接続までは疑似ゲートウェイを使用)
+	mode, err := payment.ParseFakeMode(os.Getenv("PAYMENT_GATEWAY_MODE"))
+	if err != nil {
+		log.Fatalf("Environment variable PAYMENT_GATEWAY_MODE is invalid: %v", err)
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	sagaStateTable := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	idempotencyTable := fmt.Sprintf("my-modern-application-sample-%s-idempotency", env)
+	paymentTable := fmt.Sprintf("my-modern-application-sample-%s-payments", env)
+	handlers := &steps.Handlers{
+		Payment:     payment.NewService(payment.NewFakeGateway(mode), payment.NewDynamoDBStore(dynamoClient, paymentTable)),
+		Recorder:    saga.NewRecorder(saga.NewDynamoDBStore(dynamoClient, sagaStateTable)),
+		Idempotency: idempotency.NewGuard(idempotency.NewDynamoDBStore(dynamoClient, idempotencyTable), idempotency.DefaultTTL),
+	}
+	publisher := event.NewSNSPublisher(sns.NewFromConfig(cfg), topicArn)
+	participant = choreography.NewPaymentParticipant(handlers, publisher)
+
+	lambda.Start(handler)
+}
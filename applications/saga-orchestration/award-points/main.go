@@ -2,14 +2,70 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
 )
 
-func handler(ctx context.Context) (string, error) {
-	return "award-points", nil
+// Sagaステップのハンドラー
+var handlers *steps.Handlers
+
+// handler 購入金額に応じたポイントを付与する
+func handler(ctx context.Context, input steps.AwardPointsInput) (*points.Entry, error) {
+	entry, err := handlers.AwardPoints(ctx, input)
+	if err != nil {
+		log.Printf("ポイント付与中にエラーが発生しました: order_id=%s, error=%v", input.OrderID, err)
+		return nil, err
+	}
+	return entry, nil
 }
 
 func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// ポイント付与率(未設定の場合は既定値)
+	rate := points.DefaultRate
+	if v := os.Getenv("POINTS_RATE"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed < 0 {
+			log.Fatalf("Environment variable POINTS_RATE is invalid: %q", v)
+		}
+		rate = parsed
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	sagaStateTable := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	idempotencyTable := fmt.Sprintf("my-modern-application-sample-%s-idempotency", env)
+	store := points.NewDynamoDBStore(
+		dynamoClient,
+		fmt.Sprintf("my-modern-application-sample-%s-points-ledger", env),
+		fmt.Sprintf("my-modern-application-sample-%s-points-balances", env),
+	)
+	handlers = &steps.Handlers{
+		Points:      points.NewService(store, rate),
+		Recorder:    saga.NewRecorder(saga.NewDynamoDBStore(dynamoClient, sagaStateTable)),
+		Idempotency: idempotency.NewGuard(idempotency.NewDynamoDBStore(dynamoClient, idempotencyTable), idempotency.DefaultTTL),
+	}
+
 	lambda.Start(handler)
 }
@@ -2,14 +2,58 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
 )
 
-func handler(ctx context.Context) (string, error) {
-	return "cancel-payment", nil
+// Sagaステップのハンドラー
+var handlers *steps.Handlers
+
+// handler 補償処理として決済を返金(取消)する
+func handler(ctx context.Context, input steps.CancelPaymentInput) (*payment.CancelPaymentOutput, error) {
+	out, err := handlers.CancelPayment(ctx, input)
+	if err != nil {
+		log.Printf("決済キャンセル中にエラーが発生しました: order_id=%s, error=%v", input.OrderID, err)
+		return nil, err
+	}
+	return out, nil
 }
 
 func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// 決済ゲートウェイの動作モード(実ゲートウェイ接続までは疑似ゲートウェイを使用)
+	mode, err := payment.ParseFakeMode(os.Getenv("PAYMENT_GATEWAY_MODE"))
+	if err != nil {
+		log.Fatalf("Environment variable PAYMENT_GATEWAY_MODE is invalid: %v", err)
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	sagaStateTable := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	tableName := fmt.Sprintf("my-modern-application-sample-%s-payments", env)
+	handlers = &steps.Handlers{
+		Payment:  payment.NewService(payment.NewFakeGateway(mode), payment.NewDynamoDBStore(dynamoClient, tableName)),
+		Recorder: saga.NewRecorder(saga.NewDynamoDBStore(dynamoClient, sagaStateTable)),
+	}
+
 	lambda.Start(handler)
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+)
+
+// Sagaステップのハンドラー
+var handlers *steps.Handlers
+
+// handler Sagaの完了時に在庫の引当を確定する
+func handler(ctx context.Context, input steps.ConfirmInventoryInput) (*inventory.Reservation, error) {
+	out, err := handlers.ConfirmInventory(ctx, input)
+	if err != nil {
+		log.Printf("在庫引当確定中にエラーが発生しました: order_id=%s, error=%v", input.OrderID, err)
+		return nil, err
+	}
+	return out, nil
+}
+
+func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	sagaStateTable := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	store := inventory.NewDynamoDBStore(
+		dynamoClient,
+		fmt.Sprintf("my-modern-application-sample-%s-inventory", env),
+		fmt.Sprintf("my-modern-application-sample-%s-inventory-reservations", env),
+	)
+	handlers = &steps.Handlers{
+		Inventory: inventory.NewService(store, inventory.DefaultReservationTTL),
+		Recorder:  saga.NewRecorder(saga.NewDynamoDBStore(dynamoClient, sagaStateTable)),
+	}
+
+	lambda.Start(handler)
+}
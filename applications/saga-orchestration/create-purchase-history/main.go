@@ -2,14 +2,55 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
 )
 
-func handler(ctx context.Context) (string, error) {
-	return "create-purchase-history", nil
+// Sagaステップのハンドラー
+var handlers *steps.Handlers
+
+// handler 購入履歴を作成し、後続のステップへ返す
+func handler(ctx context.Context, input steps.CreatePurchaseHistoryInput) (*purchase.Purchase, error) {
+	p, err := handlers.CreatePurchaseHistory(ctx, input)
+	if err != nil {
+		log.Printf("購入履歴作成中にエラーが発生しました: order_id=%s, error=%v", input.OrderID, err)
+		return nil, err
+	}
+	return p, nil
 }
 
 func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	sagaStateTable := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	idempotencyTable := fmt.Sprintf("my-modern-application-sample-%s-idempotency", env)
+	tableName := fmt.Sprintf("my-modern-application-sample-%s-purchase-history", env)
+	handlers = &steps.Handlers{
+		Purchase:    purchase.NewService(purchase.NewDynamoDBStore(dynamoClient, tableName)),
+		Recorder:    saga.NewRecorder(saga.NewDynamoDBStore(dynamoClient, sagaStateTable)),
+		Idempotency: idempotency.NewGuard(idempotency.NewDynamoDBStore(dynamoClient, idempotencyTable), idempotency.DefaultTTL),
+	}
+
 	lambda.Start(handler)
 }
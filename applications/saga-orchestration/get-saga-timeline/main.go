@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+)
+
+// Sagaの実行状態のストア
+var sagaStateStore saga.Store
+
+// handler 注文のSaga実行履歴(停止中のステップ・実行済みの補償処理)を返す
+func handler(ctx context.Context, input saga.GetTimelineInput) (*saga.Timeline, error) {
+	if input.OrderID == "" {
+		return nil, fmt.Errorf("order_id is required")
+	}
+
+	timeline, err := saga.GetTimeline(ctx, sagaStateStore, input.OrderID)
+	if err != nil {
+		log.Printf("Saga実行履歴の取得中にエラーが発生しました: order_id=%s, error=%v", input.OrderID, err)
+		return nil, err
+	}
+	return timeline, nil
+}
+
+func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	tableName := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	sagaStateStore = saga.NewDynamoDBStore(dynamodb.NewFromConfig(cfg), tableName)
+
+	lambda.Start(handler)
+}
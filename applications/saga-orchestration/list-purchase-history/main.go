@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+)
+
+// 購入履歴の操作
+var purchaseService *purchase.Service
+
+// handler ユーザーの購入履歴を新しい順に返す(Sagaの結果確認用)
+func handler(ctx context.Context, input purchase.ListPurchaseHistoryInput) ([]purchase.Purchase, error) {
+	purchases, err := purchaseService.ListByUser(ctx, input)
+	if err != nil {
+		log.Printf("購入履歴取得中にエラーが発生しました: user_id=%s, error=%v", input.UserID, err)
+		return nil, err
+	}
+	return purchases, nil
+}
+
+func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	tableName := fmt.Sprintf("my-modern-application-sample-%s-purchase-history", env)
+	purchaseService = purchase.NewService(purchase.NewDynamoDBStore(dynamodb.NewFromConfig(cfg), tableName))
+
+	lambda.Start(handler)
+}
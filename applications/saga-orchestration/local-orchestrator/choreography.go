@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/choreography"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+)
+
+// Sagaの実装パターン
+const (
+	patternOrchestration = "orchestration"
+	patternChoreography  = "choreography"
+)
+
+// maxReceiveCount コレオグラフィ型で使用するSQSキューの最大受信回数(リドライブポリシー)
+const maxReceiveCount = 5
+
+// RunChoreography オーケストレーション型と同じハンドラーを使用し、イベント連携でSagaを実行する。発行されたイベントを発行順に返す
+func RunChoreography(ctx context.Context, h *steps.Handlers, sagaID string, input json.RawMessage) ([]event.Envelope, error) {
+	var order choreography.Order
+	if err := json.Unmarshal(input, &order); err != nil {
+		return nil, fmt.Errorf("入力の変換エラー: %w", err)
+	}
+
+	bus := choreography.NewMemoryBus(maxReceiveCount)
+	bus.Subscribe(choreography.NewInventoryParticipant(h, bus))
+	bus.Subscribe(choreography.NewPaymentParticipant(h, bus))
+	bus.Subscribe(choreography.NewPurchaseHistoryParticipant(h, bus))
+	bus.Subscribe(choreography.NewPointsParticipant(h, bus))
+
+	if err := choreography.Start(ctx, bus, sagaID, order); err != nil {
+		return nil, err
+	}
+	if err := bus.Run(ctx); err != nil {
+		return bus.Published(), err
+	}
+	if dead := bus.DeadLetters(); len(dead) > 0 {
+		// 補償処理が最大受信回数まで失敗した場合は手動対応が必要
+		return bus.Published(), fmt.Errorf("処理できなかったイベントがあります: type=%s, id=%s", dead[0].Type, dead[0].ID)
+	}
+	return bus.Published(), nil
+}
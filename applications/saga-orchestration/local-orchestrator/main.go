@@ -0,0 +1,173 @@
+// Step Functionsのステートマシンと同じ順序・リトライ・補償処理で、Sagaの各ステップをAWSなしでローカル実行するコマンド。
+// -pattern choreographyを指定した場合は、イベント連携(コレオグラフィ型)で同じステップを実行する
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+)
+
+// sampleInput 入力ファイルを指定しない場合の実行入力
+const sampleInput = `{
+  "order_id": "order-0001",
+  "user_id": "user-0001",
+  "items": [{"sku": "sku-0001", "name": "sample", "quantity": 2, "unit_price": 500}],
+  "amount": 1000,
+  "currency": "JPY",
+  "payment_method_token": "tok_visa"
+}`
+
+// faultFlags -faultで指定された障害(ステップ名=回数、回数省略時は毎回)
+type faultFlags map[string]int
+
+func (f faultFlags) String() string {
+	return fmt.Sprint(map[string]int(f))
+}
+
+func (f faultFlags) Set(v string) error {
+	step, times, found := strings.Cut(v, "=")
+	if !found {
+		f[step] = 0
+		return nil
+	}
+	n, err := strconv.Atoi(times)
+	if err != nil || n < 0 {
+		return fmt.Errorf("回数が不正です: %q", v)
+	}
+	f[step] = n
+	return nil
+}
+
+// Output コマンドの出力
+type Output struct {
+	// Result オーケストレーション型の実行結果
+	Result *Result `json:"result,omitempty"`
+	// Events コレオグラフィ型で発行されたイベント
+	Events   []event.Envelope `json:"events,omitempty"`
+	Timeline *saga.Timeline   `json:"timeline"`
+	Balance  int64            `json:"points_balance"`
+	// Stock 実行後のSKUごとの引当可能な在庫数
+	Stock map[string]int64 `json:"stock"`
+}
+
+func main() {
+	pattern := flag.String("pattern", patternOrchestration, "Sagaの実装パターン(orchestration/choreography)")
+	inputPath := flag.String("input", "", "実行入力のJSONファイル(省略時はサンプル入力)")
+	gatewayMode := flag.String("gateway-mode", "succeed", "疑似決済ゲートウェイの動作モード(succeed/decline/timeout)")
+	pointsRate := flag.Float64("points-rate", points.DefaultRate, "ポイント付与率")
+	retryInterval := flag.Duration("retry-interval", 0, "リトライ間隔(0の場合は待機しない)")
+	stock := flag.Int64("stock", 100, "入力の明細の各SKUに用意する在庫数")
+	reservationTTL := flag.Duration("reservation-ttl", inventory.DefaultReservationTTL, "在庫引当の有効期限")
+	faults := faultFlags{}
+	flag.Var(faults, "fault", "障害を注入するステップ(例: award-points, create-purchase-history=2)。複数指定可")
+	flag.Parse()
+
+	input := []byte(sampleInput)
+	if *inputPath != "" {
+		data, err := os.ReadFile(*inputPath)
+		if err != nil {
+			log.Fatalf("入力ファイルの読み込みに失敗しました: %v", err)
+		}
+		input = data
+	}
+
+	mode, err := payment.ParseFakeMode(*gatewayMode)
+	if err != nil {
+		log.Fatalf("-gateway-modeが不正です: %v", err)
+	}
+
+	// 入力の注文(在庫の用意と実行結果の取得に使用)
+	var order inventory.ReserveInventoryInput
+	if err := json.Unmarshal(input, &order); err != nil {
+		log.Fatalf("入力の変換に失敗しました: %v", err)
+	}
+
+	// 全てのストアをインメモリで用意
+	inventoryStore := inventory.NewMemoryStore()
+	for _, item := range order.Items {
+		inventoryStore.SetStock(item.SKU, *stock)
+	}
+	inventoryService := inventory.NewService(inventoryStore, *reservationTTL)
+	sagaStore := saga.NewMemoryStore()
+	pointsService := points.NewService(points.NewMemoryStore(), *pointsRate)
+	handlers := &steps.Handlers{
+		Inventory:   inventoryService,
+		Payment:     payment.NewService(payment.NewFakeGateway(mode), payment.NewMemoryStore()),
+		Purchase:    purchase.NewService(purchase.NewMemoryStore()),
+		Points:      pointsService,
+		Recorder:    saga.NewRecorder(sagaStore),
+		Idempotency: idempotency.NewGuard(idempotency.NewMemoryStore(), idempotency.DefaultTTL),
+	}
+
+	ctx := context.Background()
+	executionID := fmt.Sprintf("local-%d", time.Now().UnixNano())
+	var output Output
+	var runErr error
+	switch *pattern {
+	case patternOrchestration:
+		orchestrator := NewOrchestrator(handlers)
+		orchestrator.sleep = func(ctx context.Context, _ time.Duration) error {
+			return sleepContext(ctx, *retryInterval)
+		}
+		for step, times := range faults {
+			orchestrator.InjectFault(step, Fault{Err: errors.New("injected fault"), Times: times})
+		}
+
+		output.Result, runErr = orchestrator.Run(ctx, executionID, input)
+		if output.Result == nil {
+			log.Fatalf("Sagaの実行に失敗しました: %v", runErr)
+		}
+	case patternChoreography:
+		if len(faults) > 0 {
+			log.Fatalf("-faultは-pattern %sでのみ指定できます", patternOrchestration)
+		}
+		output.Events, runErr = RunChoreography(ctx, handlers, executionID, input)
+		if output.Events == nil {
+			log.Fatalf("Sagaの実行に失敗しました: %v", runErr)
+		}
+	default:
+		log.Fatalf("-patternが不正です: %q", *pattern)
+	}
+
+	output.Timeline, err = saga.GetTimeline(ctx, sagaStore, order.OrderID)
+	if err != nil {
+		log.Fatalf("Saga実行履歴の取得に失敗しました: %v", err)
+	}
+	output.Balance, err = pointsService.Balance(ctx, order.UserID)
+	if err != nil {
+		log.Fatalf("ポイント残高の取得に失敗しました: %v", err)
+	}
+	output.Stock = make(map[string]int64)
+	for _, item := range order.Items {
+		if output.Stock[item.SKU], err = inventoryService.Available(ctx, item.SKU); err != nil {
+			log.Fatalf("在庫数の取得に失敗しました: %v", err)
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		log.Fatalf("結果の出力に失敗しました: %v", err)
+	}
+
+	if runErr != nil {
+		log.Fatalf("Sagaの実行に失敗しました: %v", runErr)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+)
+
+type testEnv struct {
+	orchestrator *Orchestrator
+	handlers     *steps.Handlers
+	inventory    *inventory.Service
+	gateway      *payment.FakeGateway
+	payments     *payment.MemoryStore
+	purchases    *purchase.MemoryStore
+	points       *points.Service
+	sagaStore    *saga.MemoryStore
+}
+
+func newTestEnv(mode payment.FakeMode) *testEnv {
+	stock := inventory.NewMemoryStore()
+	stock.SetStock("sku-0001", 10)
+	env := &testEnv{
+		inventory: inventory.NewService(stock, inventory.DefaultReservationTTL),
+		gateway:   payment.NewFakeGateway(mode),
+		payments:  payment.NewMemoryStore(),
+		purchases: purchase.NewMemoryStore(),
+		points:    points.NewService(points.NewMemoryStore(), points.DefaultRate),
+		sagaStore: saga.NewMemoryStore(),
+	}
+	env.handlers = &steps.Handlers{
+		Inventory:   env.inventory,
+		Payment:     payment.NewService(env.gateway, env.payments),
+		Purchase:    purchase.NewService(env.purchases),
+		Points:      env.points,
+		Recorder:    saga.NewRecorder(env.sagaStore),
+		Idempotency: idempotency.NewGuard(idempotency.NewMemoryStore(), idempotency.DefaultTTL),
+	}
+	env.orchestrator = NewOrchestrator(env.handlers)
+	env.orchestrator.sleep = func(context.Context, time.Duration) error { return nil }
+	return env
+}
+
+// invokedSteps 呼び出されたステップ名を呼び出し順に返す(リトライは1つにまとめる)
+func invokedSteps(result *Result) []string {
+	var names []string
+	for _, invocation := range result.Invocations {
+		if len(names) == 0 || names[len(names)-1] != invocation.Step {
+			names = append(names, invocation.Step)
+		}
+	}
+	return names
+}
+
+func TestRunSucceeded(t *testing.T) {
+	env := newTestEnv(payment.FakeModeSucceed)
+
+	result, err := env.orchestrator.Run(context.Background(), "exec-1", json.RawMessage(sampleInput))
+	if err != nil {
+		t.Fatalf("Run() returned an error: %v", err)
+	}
+	if result.Status != StatusSucceeded {
+		t.Errorf("Status = %s, want %s", result.Status, StatusSucceeded)
+	}
+
+	want := []string{
+		saga.StepReserveInventory, saga.StepProcessPayment, saga.StepCreatePurchaseHistory,
+		saga.StepAwardPoints, saga.StepConfirmInventory,
+	}
+	if got := invokedSteps(result); !reflect.DeepEqual(got, want) {
+		t.Errorf("steps = %v, want %v", got, want)
+	}
+
+	balance, err := env.points.Balance(context.Background(), "user-0001")
+	if err != nil {
+		t.Fatalf("Balance() returned an error: %v", err)
+	}
+	if balance != 10 {
+		t.Errorf("Balance() = %d, want %d", balance, 10)
+	}
+	if available := env.available(t); available != 8 {
+		t.Errorf("Available() = %d, want %d", available, 8)
+	}
+}
+
+func TestRunCompensatesInReverseOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		faultStep string
+		want      []string
+	}{
+		{
+			name:      "process-payment fails",
+			faultStep: saga.StepProcessPayment,
+			want: []string{
+				saga.StepReserveInventory, saga.StepProcessPayment,
+				saga.StepCancelPayment, saga.StepReleaseInventory,
+			},
+		},
+		{
+			name:      "create-purchase-history fails",
+			faultStep: saga.StepCreatePurchaseHistory,
+			want: []string{
+				saga.StepReserveInventory, saga.StepProcessPayment, saga.StepCreatePurchaseHistory,
+				saga.StepDeletePurchaseHistory, saga.StepCancelPayment, saga.StepReleaseInventory,
+			},
+		},
+		{
+			name:      "award-points fails",
+			faultStep: saga.StepAwardPoints,
+			want: []string{
+				saga.StepReserveInventory, saga.StepProcessPayment, saga.StepCreatePurchaseHistory,
+				saga.StepAwardPoints, saga.StepReversePoints, saga.StepDeletePurchaseHistory,
+				saga.StepCancelPayment, saga.StepReleaseInventory,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(payment.FakeModeSucceed)
+			env.orchestrator.InjectFault(tt.faultStep, Fault{Err: errors.New("injected")})
+
+			result, err := env.orchestrator.Run(context.Background(), "exec-1", json.RawMessage(sampleInput))
+			if err != nil {
+				t.Fatalf("Run() returned an error: %v", err)
+			}
+			if result.Status != StatusFailed {
+				t.Errorf("Status = %s, want %s", result.Status, StatusFailed)
+			}
+			if got := invokedSteps(result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("steps = %v, want %v", got, tt.want)
+			}
+
+			// 補償処理後は決済が取り消され、購入履歴が残っていないこと
+			p, err := env.payments.Get(context.Background(), payment.PaymentIDFromOrderID("order-0001"))
+			if err == nil && p.Status != payment.StatusCancelled {
+				t.Errorf("payment status = %s, want %s", p.Status, payment.StatusCancelled)
+			}
+			h, err := env.purchases.Get(context.Background(), "order-0001")
+			if err == nil && h.Status != purchase.StatusDeleted {
+				t.Errorf("purchase status = %s, want %s", h.Status, purchase.StatusDeleted)
+			}
+			// 引き当てた在庫が戻っていること
+			if available := env.available(t); available != 10 {
+				t.Errorf("Available() = %d, want %d", available, 10)
+			}
+		})
+	}
+}
+
+func TestRunRetriesTransientFault(t *testing.T) {
+	env := newTestEnv(payment.FakeModeSucceed)
+	env.orchestrator.InjectFault(saga.StepCreatePurchaseHistory, Fault{Err: errors.New("injected"), Times: 2})
+
+	result, err := env.orchestrator.Run(context.Background(), "exec-1", json.RawMessage(sampleInput))
+	if err != nil {
+		t.Fatalf("Run() returned an error: %v", err)
+	}
+	if result.Status != StatusSucceeded {
+		t.Errorf("Status = %s, want %s", result.Status, StatusSucceeded)
+	}
+
+	var attempts int
+	for _, invocation := range result.Invocations {
+		if invocation.Step == saga.StepCreatePurchaseHistory {
+			attempts++
+		}
+	}
+	if attempts != 3 {
+		t.Errorf("create-purchase-history attempts = %d, want %d", attempts, 3)
+	}
+}
+
+func TestRunDeclinedPaymentIsNotRetried(t *testing.T) {
+	env := newTestEnv(payment.FakeModeDecline)
+
+	result, err := env.orchestrator.Run(context.Background(), "exec-1", json.RawMessage(sampleInput))
+	if err != nil {
+		t.Fatalf("Run() returned an error: %v", err)
+	}
+
+	want := []Invocation{
+		{Step: saga.StepReserveInventory, Attempt: 1},
+		{Step: saga.StepProcessPayment, Attempt: 1},
+		{Step: saga.StepCancelPayment, Attempt: 1},
+		{Step: saga.StepReleaseInventory, Attempt: 1},
+	}
+	if len(result.Invocations) != len(want) {
+		t.Fatalf("Invocations = %+v, want %+v", result.Invocations, want)
+	}
+	for i, w := range want {
+		if result.Invocations[i].Step != w.Step || result.Invocations[i].Attempt != w.Attempt {
+			t.Errorf("Invocations[%d] = %+v, want %+v", i, result.Invocations[i], w)
+		}
+	}
+
+	// Catchで状態に格納されるエラー名はLambdaのerrorTypeと同じであること
+	var errorInfo struct{ Error string }
+	if err := json.Unmarshal(result.State["error"], &errorInfo); err != nil {
+		t.Fatalf("state.error is invalid: %v", err)
+	}
+	if errorInfo.Error != "BusinessRejectionError" {
+		t.Errorf("state.error.Error = %q, want %q", errorInfo.Error, "BusinessRejectionError")
+	}
+
+	timeline, err := saga.GetTimeline(context.Background(), env.sagaStore, "order-0001")
+	if err != nil {
+		t.Fatalf("GetTimeline() returned an error: %v", err)
+	}
+	wantCompensated := []string{saga.StepCancelPayment, saga.StepReleaseInventory}
+	if !reflect.DeepEqual(timeline.Compensated, wantCompensated) {
+		t.Errorf("Compensated = %v, want %v", timeline.Compensated, wantCompensated)
+	}
+}
+
+// outcome Saga実行後の各サービスの状態
+type outcome struct {
+	PaymentStatus  payment.Status
+	PurchaseStatus purchase.Status
+	Balance        int64
+	Available      int64
+}
+
+// available サンプル入力のSKUの引当可能な在庫数を返す
+func (env *testEnv) available(t *testing.T) int64 {
+	t.Helper()
+	available, err := env.inventory.Available(context.Background(), "sku-0001")
+	if err != nil {
+		t.Fatalf("Available() returned an error: %v", err)
+	}
+	return available
+}
+
+func (env *testEnv) outcome(t *testing.T) outcome {
+	t.Helper()
+	ctx := context.Background()
+	var o outcome
+	if p, err := env.payments.Get(ctx, payment.PaymentIDFromOrderID("order-0001")); err == nil {
+		o.PaymentStatus = p.Status
+	}
+	if h, err := env.purchases.Get(ctx, "order-0001"); err == nil {
+		o.PurchaseStatus = h.Status
+	}
+	balance, err := env.points.Balance(ctx, "user-0001")
+	if err != nil {
+		t.Fatalf("Balance() returned an error: %v", err)
+	}
+	o.Balance = balance
+	o.Available = env.available(t)
+	return o
+}
+
+func TestPatternsReachSameOutcome(t *testing.T) {
+	for _, mode := range []payment.FakeMode{payment.FakeModeSucceed, payment.FakeModeDecline} {
+		t.Run(string(mode), func(t *testing.T) {
+			orchestration := newTestEnv(mode)
+			if _, err := orchestration.orchestrator.Run(context.Background(), "exec-1", json.RawMessage(sampleInput)); err != nil {
+				t.Fatalf("Run() returned an error: %v", err)
+			}
+
+			choreography := newTestEnv(mode)
+			if _, err := RunChoreography(context.Background(), choreography.handlers, "exec-1", json.RawMessage(sampleInput)); err != nil {
+				t.Fatalf("RunChoreography() returned an error: %v", err)
+			}
+
+			if got, want := choreography.outcome(t), orchestration.outcome(t); got != want {
+				t.Errorf("choreography outcome = %+v, orchestration outcome = %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+)
+
+// 実行結果のステータス
+const (
+	StatusSucceeded = "SUCCEEDED"
+	StatusFailed    = "FAILED"
+)
+
+// Retry ステートマシンのRetryに相当するリトライ設定
+type Retry struct {
+	MaxAttempts int
+	Interval    time.Duration
+	BackoffRate float64
+}
+
+// Task ステートマシンのTaskステートに相当するステップ
+type Task struct {
+	Name string
+	// ResultPath ステップの出力を格納する状態のキー
+	ResultPath string
+	Retry      Retry
+	// Compensation このステップが失敗した場合・後続のステップが失敗した場合に実行する補償処理
+	Compensation string
+	invoke       func(ctx context.Context, state json.RawMessage) (any, error)
+}
+
+// newTask ハンドラーから、状態(JSON)を入力型に変換して呼び出すステップを生成する
+func newTask[I any, O any](name, resultPath string, handler func(context.Context, I) (O, error)) Task {
+	return Task{
+		Name:       name,
+		ResultPath: resultPath,
+		invoke: func(ctx context.Context, state json.RawMessage) (any, error) {
+			var in I
+			if err := json.Unmarshal(state, &in); err != nil {
+				return nil, fmt.Errorf("入力の変換エラー: %w", err)
+			}
+			return handler(ctx, in)
+		},
+	}
+}
+
+// Fault ステップに注入する障害
+type Fault struct {
+	Err error
+	// Times 障害を発生させる回数。0の場合は毎回
+	Times int
+}
+
+// Invocation ステップの呼び出し記録
+type Invocation struct {
+	Step    string `json:"step"`
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Result Sagaの実行結果
+type Result struct {
+	ExecutionID string                     `json:"execution_id"`
+	Status      string                     `json:"status"`
+	Error       string                     `json:"error,omitempty"`
+	State       map[string]json.RawMessage `json:"state"`
+	Invocations []Invocation               `json:"invocations"`
+}
+
+// Orchestrator Step Functionsのステートマシンと同じ順序・リトライ・補償処理でSagaをローカル実行する
+type Orchestrator struct {
+	tasks         []Task
+	compensations map[string]Task
+	faults        map[string]*Fault
+	sleep         func(ctx context.Context, d time.Duration) error
+}
+
+// NewOrchestrator ステートマシンと同じ定義のOrchestratorを生成する
+func NewOrchestrator(h *steps.Handlers) *Orchestrator {
+	forwardRetry := Retry{MaxAttempts: 3, Interval: time.Second, BackoffRate: 2}
+	compensationRetry := Retry{MaxAttempts: 5, Interval: time.Second, BackoffRate: 2}
+
+	reserveInventory := newTask(saga.StepReserveInventory, "reservation", h.ReserveInventory)
+	reserveInventory.Retry = forwardRetry
+	reserveInventory.Compensation = saga.StepReleaseInventory
+
+	processPayment := newTask(saga.StepProcessPayment, "payment", h.ProcessPayment)
+	processPayment.Retry = forwardRetry
+	processPayment.Compensation = saga.StepCancelPayment
+
+	createPurchaseHistory := newTask(saga.StepCreatePurchaseHistory, "purchase", h.CreatePurchaseHistory)
+	createPurchaseHistory.Retry = forwardRetry
+	createPurchaseHistory.Compensation = saga.StepDeletePurchaseHistory
+
+	awardPoints := newTask(saga.StepAwardPoints, "points", h.AwardPoints)
+	awardPoints.Retry = forwardRetry
+	awardPoints.Compensation = saga.StepReversePoints
+
+	// 引当の確定が失敗した場合は、確定前の全てのステップを補償する
+	confirmInventory := newTask(saga.StepConfirmInventory, "reservation", h.ConfirmInventory)
+	confirmInventory.Retry = forwardRetry
+
+	releaseInventory := newTask(saga.StepReleaseInventory, "release_inventory", h.ReleaseInventory)
+	releaseInventory.Retry = compensationRetry
+
+	cancelPayment := newTask(saga.StepCancelPayment, "cancel_payment", h.CancelPayment)
+	cancelPayment.Retry = compensationRetry
+
+	deletePurchaseHistory := newTask(saga.StepDeletePurchaseHistory, "delete_purchase_history", h.DeletePurchaseHistory)
+	deletePurchaseHistory.Retry = compensationRetry
+
+	reversePoints := newTask(saga.StepReversePoints, "reverse_points", h.ReversePoints)
+	reversePoints.Retry = compensationRetry
+
+	return &Orchestrator{
+		tasks: []Task{reserveInventory, processPayment, createPurchaseHistory, awardPoints, confirmInventory},
+		compensations: map[string]Task{
+			releaseInventory.Name:      releaseInventory,
+			cancelPayment.Name:         cancelPayment,
+			deletePurchaseHistory.Name: deletePurchaseHistory,
+			reversePoints.Name:         reversePoints,
+		},
+		faults: make(map[string]*Fault),
+		sleep:  sleepContext,
+	}
+}
+
+// InjectFault ステップに障害を注入する
+func (o *Orchestrator) InjectFault(step string, fault Fault) {
+	o.faults[step] = &fault
+}
+
+// Run Sagaを実行する。ステップが失敗した場合は完了済みのステップの補償処理を逆順に実行する
+func (o *Orchestrator) Run(ctx context.Context, executionID string, input json.RawMessage) (*Result, error) {
+	state := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(input, &state); err != nil {
+		return nil, fmt.Errorf("入力の変換エラー: %w", err)
+	}
+	result := &Result{ExecutionID: executionID, State: state, Invocations: []Invocation{}}
+
+	// 補償処理が必要なステップ(失敗したステップを含む)
+	var compensations []string
+	var stepErr error
+	for _, task := range o.tasks {
+		if task.Compensation != "" {
+			compensations = append(compensations, task.Compensation)
+		}
+		if stepErr = o.execute(ctx, result, task); stepErr != nil {
+			break
+		}
+	}
+
+	if stepErr == nil {
+		result.Status = StatusSucceeded
+		return result, nil
+	}
+
+	// Catch: エラー情報を状態に格納して補償処理を逆順に実行する
+	result.Status = StatusFailed
+	result.Error = stepErr.Error()
+	errorInfo, err := json.Marshal(map[string]string{"Error": errorName(stepErr), "Cause": stepErr.Error()})
+	if err != nil {
+		return nil, err
+	}
+	state["error"] = errorInfo
+
+	for i := len(compensations) - 1; i >= 0; i-- {
+		task := o.compensations[compensations[i]]
+		if err := o.execute(ctx, result, task); err != nil {
+			// 補償処理が失敗した場合は手動対応が必要なため、実行を中断する
+			return result, fmt.Errorf("補償処理 %s が失敗しました: %w", task.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// execute リトライ設定に従ってステップを実行し、出力を状態に格納する
+func (o *Orchestrator) execute(ctx context.Context, result *Result, task Task) error {
+	interval := task.Retry.Interval
+	var lastErr error
+	for attempt := 1; attempt <= max(task.Retry.MaxAttempts, 1); attempt++ {
+		if attempt > 1 {
+			if err := o.sleep(ctx, interval); err != nil {
+				return err
+			}
+			interval = time.Duration(float64(interval) * task.Retry.BackoffRate)
+		}
+
+		output, err := o.invoke(ctx, result, task, attempt)
+		invocation := Invocation{Step: task.Name, Attempt: attempt}
+		if err != nil {
+			invocation.Error = err.Error()
+		}
+		result.Invocations = append(result.Invocations, invocation)
+
+		if err == nil {
+			raw, err := json.Marshal(output)
+			if err != nil {
+				return fmt.Errorf("出力の変換エラー: %w", err)
+			}
+			result.State[task.ResultPath] = raw
+			return nil
+		}
+
+		lastErr = err
+		if !saga.Retryable(err) {
+			break
+		}
+		log.Printf("ステップをリトライします: step=%s, attempt=%d, error=%v", task.Name, attempt, err)
+	}
+	return lastErr
+}
+
+// invoke Parametersに相当するSaga実行コンテキストを状態に設定し、ステップを呼び出す
+func (o *Orchestrator) invoke(ctx context.Context, result *Result, task Task, attempt int) (any, error) {
+	if fault, ok := o.faults[task.Name]; ok {
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				delete(o.faults, task.Name)
+			}
+		}
+		return nil, fault.Err
+	}
+
+	sc, err := json.Marshal(saga.Context{ExecutionID: result.ExecutionID, Attempt: attempt})
+	if err != nil {
+		return nil, err
+	}
+	result.State["saga"] = sc
+
+	state, err := json.Marshal(result.State)
+	if err != nil {
+		return nil, err
+	}
+	return task.invoke(ctx, state)
+}
+
+// errorName Catchで状態に格納するエラー名。Sagaのエラー型に分類されていないエラーはLambdaの実行基盤の障害とみなす
+func errorName(err error) string {
+	if errors.As(err, new(*saga.TransientError)) ||
+		errors.As(err, new(*saga.BusinessRejectionError)) ||
+		errors.As(err, new(*saga.PermanentError)) {
+		return saga.ErrorName(err)
+	}
+	return "States.TaskFailed"
+}
+
+// sleepContext コンテキストがキャンセルされるまでの間、指定時間待機する
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
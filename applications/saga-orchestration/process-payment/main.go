@@ -2,14 +2,61 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
 )
 
-func handler(ctx context.Context) (string, error) {
-	return "process-payment", nil
+// Sagaステップのハンドラー
+var handlers *steps.Handlers
+
+// handler 決済を実行し、決済レコードを後続のステップへ返す
+func handler(ctx context.Context, input steps.ProcessPaymentInput) (*payment.Payment, error) {
+	p, err := handlers.ProcessPayment(ctx, input)
+	if err != nil {
+		log.Printf("決済処理中にエラーが発生しました: order_id=%s, error=%v", input.OrderID, err)
+		return nil, err
+	}
+	return p, nil
 }
 
 func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// 決済ゲートウェイの動作モード(実ゲートウェイ接続までは疑似ゲートウェイを使用)
+	mode, err := payment.ParseFakeMode(os.Getenv("PAYMENT_GATEWAY_MODE"))
+	if err != nil {
+		log.Fatalf("Environment variable PAYMENT_GATEWAY_MODE is invalid: %v", err)
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	sagaStateTable := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	idempotencyTable := fmt.Sprintf("my-modern-application-sample-%s-idempotency", env)
+	tableName := fmt.Sprintf("my-modern-application-sample-%s-payments", env)
+	handlers = &steps.Handlers{
+		Payment:     payment.NewService(payment.NewFakeGateway(mode), payment.NewDynamoDBStore(dynamoClient, tableName)),
+		Recorder:    saga.NewRecorder(saga.NewDynamoDBStore(dynamoClient, sagaStateTable)),
+		Idempotency: idempotency.NewGuard(idempotency.NewDynamoDBStore(dynamoClient, idempotencyTable), idempotency.DefaultTTL),
+	}
+
 	lambda.Start(handler)
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+)
+
+// 在庫引当の操作
+var service *inventory.Service
+
+// handler EventBridgeのスケジュールで定期実行し、有効期限を過ぎた未確定の在庫引当を解放する。
+// Sagaが途中で止まった場合(Step Functionsの実行失敗やイベントの滞留)でも在庫が戻るようにする
+func handler(ctx context.Context) error {
+	released, err := service.ReleaseExpired(ctx)
+	if err != nil {
+		log.Printf("期限切れの在庫引当の解放中にエラーが発生しました: released=%d, error=%v", released, err)
+		return err
+	}
+	log.Printf("期限切れの在庫引当の解放完了: released=%d", released)
+	return nil
+}
+
+func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	store := inventory.NewDynamoDBStore(
+		dynamodb.NewFromConfig(cfg),
+		fmt.Sprintf("my-modern-application-sample-%s-inventory", env),
+		fmt.Sprintf("my-modern-application-sample-%s-inventory-reservations", env),
+	)
+	service = inventory.NewService(store, inventory.DefaultReservationTTL)
+
+	lambda.Start(handler)
+}
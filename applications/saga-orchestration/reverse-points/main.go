@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+)
+
+// Sagaステップのハンドラー
+var handlers *steps.Handlers
+
+// handler 補償処理として注文で付与したポイントを取り消す
+func handler(ctx context.Context, input steps.ReversePointsInput) (*points.ReversePointsOutput, error) {
+	out, err := handlers.ReversePoints(ctx, input)
+	if err != nil {
+		log.Printf("ポイント取消中にエラーが発生しました: order_id=%s, error=%v", input.OrderID, err)
+		return nil, err
+	}
+	return out, nil
+}
+
+func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// AWS設定の初期化
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	sagaStateTable := fmt.Sprintf("my-modern-application-sample-%s-saga-state", env)
+	store := points.NewDynamoDBStore(
+		dynamoClient,
+		fmt.Sprintf("my-modern-application-sample-%s-points-ledger", env),
+		fmt.Sprintf("my-modern-application-sample-%s-points-balances", env),
+	)
+	// 取消は付与時のポイント数を使用するため付与率は使わない
+	handlers = &steps.Handlers{
+		Points:   points.NewService(store, points.DefaultRate),
+		Recorder: saga.NewRecorder(saga.NewDynamoDBStore(dynamoClient, sagaStateTable)),
+	}
+
+	lambda.Start(handler)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/mail"
+)
+
+// attachmentCache 呼び出し内のメッセージで共有する添付ファイル。
+// 同じ配信の宛先ごとにS3から取得し直さないよう、バケット名とキーごとに保持する
+type attachmentCache struct {
+	client      mail.S3API
+	attachments map[string]mail.Attachment
+}
+
+// newAttachmentCache 空のattachmentCacheを生成する
+func newAttachmentCache(client mail.S3API) *attachmentCache {
+	return &attachmentCache{client: client, attachments: make(map[string]mail.Attachment)}
+}
+
+// load 添付ファイルを取得する。取得済みの添付ファイルはS3から取得し直さない
+func (c *attachmentCache) load(ctx context.Context, bucketName string, keys []string) ([]mail.Attachment, error) {
+	attachments := make([]mail.Attachment, 0, len(keys))
+	for _, key := range keys {
+		cacheKey := bucketName + "/" + key
+		attachment, ok := c.attachments[cacheKey]
+		if !ok {
+			var err error
+			if attachment, err = mail.LoadAttachment(ctx, c.client, bucketName, key); err != nil {
+				return nil, err
+			}
+			c.attachments[cacheKey] = attachment
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
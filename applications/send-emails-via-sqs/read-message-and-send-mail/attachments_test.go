@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// countingS3 GetObjectの呼び出し回数を数えるS3クライアント
+type countingS3 struct {
+	calls map[string]int
+}
+
+func (f *countingS3) GetObject(_ context.Context, params *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	key := aws.ToString(params.Key)
+	f.calls[key]++
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("data of " + key))}, nil
+}
+
+func TestAttachmentCacheLoad(t *testing.T) {
+	client := &countingS3{calls: make(map[string]int)}
+	cache := newAttachmentCache(client)
+	keys := []string{"attachments/guide.pdf", "attachments/map.png"}
+
+	// 同じ配信の宛先ごとにS3から取得し直さないこと
+	for range 3 {
+		attachments, err := cache.load(context.Background(), "contents", keys)
+		if err != nil {
+			t.Fatalf("load() returned an error: %v", err)
+		}
+		if len(attachments) != 2 || attachments[0].Filename != "guide.pdf" || string(attachments[1].Data) != "data of attachments/map.png" {
+			t.Errorf("load() = %+v", attachments)
+		}
+	}
+	for _, key := range keys {
+		if client.calls[key] != 1 {
+			t.Errorf("GetObject(%s) called %d times, want 1", key, client.calls[key])
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/campaign"
+)
+
+const (
+	// completeAttempts 送信後に配信状態を送信済みに更新する最大試行回数
+	completeAttempts = 5
+	// completeRetryBase 送信済みへの更新を再試行するまでの時間の初期値(試行ごとに2倍にする)
+	completeRetryBase = 200 * time.Millisecond
+)
+
+// completeWithRetry 送信後に配信状態を送信済みに更新し、メッセージIDを記録する。
+// 記録できないとバウンス・苦情の通知と照合できず、リースの期限後に重複したメッセージで再送信されるため、
+// 一時的なエラーの場合は間隔を空けて再試行する。リースを失った(ErrConflict)・遷移できない場合は再試行しない
+func completeWithRetry(ctx context.Context, complete func(context.Context) error, sleep func(context.Context, time.Duration) error) error {
+	interval := completeRetryBase
+	for attempt := 1; ; attempt++ {
+		err := complete(ctx)
+		if err == nil || errors.Is(err, campaign.ErrConflict) || errors.Is(err, campaign.ErrInvalidTransition) || attempt == completeAttempts {
+			return err
+		}
+		log.Printf("配信状態の送信済みへの更新を再試行します: attempt=%d, error=%v", attempt, err)
+		if err := sleep(ctx, interval); err != nil {
+			return err
+		}
+		interval *= 2
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/campaign"
+)
+
+func TestCompleteWithRetry(t *testing.T) {
+	errTransient := errors.New("connection reset")
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "succeed", wantCalls: 1},
+		{name: "transient errors", errs: []error{errTransient, errTransient}, wantCalls: 3},
+		{name: "lease lost", errs: []error{fmt.Errorf("配信状態の更新エラー: %w", campaign.ErrConflict)}, wantErr: campaign.ErrConflict, wantCalls: 1},
+		{name: "give up", errs: []error{errTransient, errTransient, errTransient, errTransient, errTransient}, wantErr: errTransient, wantCalls: completeAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var waits []time.Duration
+			err := completeWithRetry(context.Background(), func(context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			}, func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("completeWithRetry() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			// 再試行の間隔を2倍ずつ延ばすこと
+			for i, d := range waits {
+				if want := completeRetryBase << i; d != want {
+					t.Errorf("wait #%d = %s, want %s", i, d, want)
+				}
+			}
+		})
+	}
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -17,7 +21,11 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
-	sestypes "github.com/aws/aws-sdk-go-v2/service/ses/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/campaign"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/mail"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/sqsbatch"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/unsubscribe"
 )
 
 var (
@@ -26,75 +34,187 @@ var (
 	tableName    string
 	s3Client     *s3.Client
 	dynamoClient *dynamodb.Client
-	sesClient    *ses.Client
+	sender       *mail.Sender
+	campaigns    *campaign.Service
+	// unsubscribeBaseURL 配信停止のリンクのURL(署名したトークンをクエリに付与する)
+	unsubscribeBaseURL string
+	// unsubscribeSigner 配信停止のトークンの発行
+	unsubscribeSigner *unsubscribe.Signer
+	// limiter 送信レートの制限(呼び出し内の全てのメッセージで共有する)
+	limiter *tokenBucket
+	// sqsClient・queueURL スロットリング時に再配信までの時間を変更するメール送信キュー
+	sqsClient *sqs.Client
+	queueURL  string
 )
 
-// handler SQSイベントを処理してメール送信を行う
-func handler(ctx context.Context, event events.SQSEvent) error {
-	for _, record := range event.Records {
-		err := processMessage(ctx, record)
-		if err != nil {
-			log.Printf("メッセージ処理中にエラーが発生しました: %v", err)
-			return err
-		}
-	}
-	return nil
+const (
+	// unsubscribeTokenTTL 配信停止のトークンの有効期間
+	unsubscribeTokenTTL = 90 * 24 * time.Hour
+	// sendLeaseMargin 送信のリースの期限に加える余裕(タイムアウト時に処理中だったSESのリクエストの分)
+	sendLeaseMargin = time.Minute
+	// maxSendLease 呼び出しにタイムアウトがない場合の送信のリースの期間(Lambdaの最大実行時間)
+	maxSendLease = 15 * time.Minute
+)
+
+// handler SQSイベントを処理してメール送信を行う。
+// 失敗したメッセージだけを再配信させ、送信済みのメッセージが再配信されないようにする
+func handler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	// 添付ファイルは呼び出し内のメッセージで共有し、宛先ごとにS3から取得しない
+	attachments := newAttachmentCache(s3Client)
+	return sqsbatch.Process(ctx, event, func(ctx context.Context, record events.SQSMessage) error {
+		return processMessage(ctx, record, attachments)
+	}), nil
 }
 
 // processMessage 個別のSQSメッセージを処理する
-func processMessage(ctx context.Context, record events.SQSMessage) error {
+func processMessage(ctx context.Context, record events.SQSMessage, cache *attachmentCache) error {
 	email := record.Body
 
 	// メッセージ属性から必要な情報を取得
+	campaignID := record.MessageAttributes["campaign_id"].StringValue
 	bucketName := record.MessageAttributes["bucket_name"].StringValue
 	fileName := record.MessageAttributes["file_name"].StringValue
-	userName := record.MessageAttributes["user_name"].StringValue
+	// user_nameはユーザー名が登録されていない場合、version_idはバージョニングが無効な場合は設定されない
+	userName := aws.ToString(record.MessageAttributes["user_name"].StringValue)
+	versionID := aws.ToString(record.MessageAttributes["version_id"].StringValue)
 
-	if bucketName == nil || fileName == nil || userName == nil {
+	if campaignID == nil || bucketName == nil || fileName == nil {
 		return fmt.Errorf("必要なメッセージ属性が不足しています")
 	}
 
-	log.Printf("メール処理開始: email=%s, bucket=%s, file=%s, user=%s",
-		email, *bucketName, *fileName, *userName)
+	log.Printf("メール処理開始: email=%s, campaign=%s, bucket=%s, file=%s, version=%s, user=%s",
+		email, *campaignID, *bucketName, *fileName, versionID, userName)
 
-	// S3バケットからメール本文を取得
-	mailData, err := getMailDataFromS3(ctx, *bucketName, *fileName)
+	// キューへの登録後にバウンスなどでエラーになったメールアドレスには送信しない
+	status, err := getAddressStatus(ctx, email)
+	if err != nil {
+		return fmt.Errorf("DynamoDB取得エラー: %w", err)
+	}
+	if status.hasError {
+		log.Printf("エラーのあるメールアドレスのため送信をスキップ: %s", email)
+		return skip(ctx, *campaignID, email)
+	}
+
+	// S3バケットからsend-messageが検証したバージョンのメール本文を取得
+	mailData, err := getMailDataFromS3(ctx, *bucketName, *fileName, versionID)
 	if err != nil {
 		return fmt.Errorf("S3からメールデータ取得エラー: %w", err)
 	}
 
-	// メールデータを解析
-	subject, body, err := parseMailData(mailData)
+	// メールデータをテンプレートとして解析し、宛先ごとの値を差し込む
+	tmpl, err := mail.ParseTemplate(mailData)
 	if err != nil {
 		return fmt.Errorf("メールデータ解析エラー: %w", err)
 	}
 
-	// DynamoDBで送信状態を確認・更新
-	wasSent, err := updateSendStatus(ctx, email)
+	// キューへの登録後に配信リストの配信を停止したメールアドレスには送信しない
+	if slices.Contains(status.unsubscribedLists, tmpl.List()) {
+		log.Printf("配信停止したメールアドレスのため送信をスキップ: email=%s, list=%s", email, tmpl.List())
+		return skip(ctx, *campaignID, email)
+	}
+
+	unsubscribeLink, err := unsubscribeURL(email, tmpl.List())
+	if err != nil {
+		return fmt.Errorf("配信停止のリンクの生成エラー: %w", err)
+	}
+	msg, err := tmpl.Render(mail.TemplateData{
+		UserName:       userName,
+		Email:          email,
+		UnsubscribeURL: unsubscribeLink,
+	})
 	if err != nil {
-		return fmt.Errorf("DynamoDB更新エラー: %w", err)
+		return fmt.Errorf("メールデータ差し込みエラー: %w", err)
 	}
 
-	// 未送信の場合のみメール送信
-	if !wasSent {
-		err = sendEmail(ctx, email, subject, body)
-		if err != nil {
-			return fmt.Errorf("メール送信エラー: %w", err)
-		}
-		log.Printf("メール送信完了: %s", email)
-	} else {
+	// 添付ファイルは送信のリースを取得する前に取得し、取得できない場合は配信状態を変えずに再配信させる
+	attachments, err := cache.load(ctx, *bucketName, msg.Attachments)
+	if err != nil {
+		return fmt.Errorf("添付ファイル取得エラー: %w", err)
+	}
+
+	// SESの最大送信レートを超えないよう、送信できるまで待機する
+	if err := limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("送信レートの制限の待機エラー: %w", err)
+	}
+
+	// 配信ごとの配信状態を送信中に更新して送信のリースを取得できた場合のみメール送信
+	// (送信済み・除外済みの場合は送信しない。重複したメッセージを他の呼び出しが送信中の場合は、結果が分かるまで再配信させる)
+	delivery, err := campaigns.Claim(ctx, *campaignID, email, sendLease(ctx))
+	if errors.Is(err, campaign.ErrInvalidTransition) {
 		log.Printf("再送信スキップ: %s", email)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("配信状態の更新エラー: %w", err)
+	}
+
+	messageID, err := sendEmail(ctx, email, msg, attachments, unsubscribeLink)
+	if err != nil {
+		// 再配信で送信し直せるよう送信失敗にする(更新できなかった場合もリースの期限が切れれば送信し直せる)
+		if releaseErr := campaigns.Release(context.WithoutCancel(ctx), delivery, err.Error()); releaseErr != nil {
+			log.Printf("配信状態を送信失敗に更新できませんでした: email=%s, error=%v", email, releaseErr)
+		}
+		if isThrottled(err) {
+			// スロットリングは時間をおけば送信できるため、受信回数に応じて再配信までの時間を延ばす
+			backoff := throttleBackoff(record)
+			if visibilityErr := changeVisibility(context.WithoutCancel(ctx), record, backoff); visibilityErr != nil {
+				log.Printf("可視性タイムアウトを変更できませんでした: message_id=%s, error=%v", record.MessageId, visibilityErr)
+			}
+			return fmt.Errorf("SESのスロットリングのため%s後に再送信: %w", backoff, err)
+		}
+		return fmt.Errorf("メール送信エラー: %w", err)
+	}
+	log.Printf("メール送信完了: %s", email)
+
+	// 送信済みにして、バウンス・苦情の通知から配信状態を特定するためにメッセージIDを記録する
+	err = completeWithRetry(context.WithoutCancel(ctx), func(ctx context.Context) error {
+		return campaigns.Complete(ctx, delivery, messageID)
+	}, sleepContext)
+	if err != nil {
+		// 送信は完了しているため再配信させない
+		log.Printf("配信状態を送信済みに更新できませんでした: email=%s, message_id=%s, error=%v", email, messageID, err)
+	}
+
+	return nil
+}
+
+// changeVisibility メッセージの可視性タイムアウトを変更し、再配信されるまでの時間を設定する
+func changeVisibility(ctx context.Context, record events.SQSMessage, timeout time.Duration) error {
+	_, err := sqsClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     aws.String(record.ReceiptHandle),
+		VisibilityTimeout: int32(timeout.Seconds()),
+	})
+	return err
+}
+
+// sendLease 送信のリースの期間。呼び出しがタイムアウトするまでは重複したメッセージを処理する他の呼び出しに送信させない
+func sendLease(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return maxSendLease
 	}
+	return time.Until(deadline) + sendLeaseMargin
+}
 
+// skip 送信しない宛先の配信状態を除外済みに更新する。送信済みなどで除外できない場合も送信はしない
+func skip(ctx context.Context, campaignID, email string) error {
+	_, err := campaigns.Transition(ctx, campaignID, email, campaign.StatusSkipped)
+	if err != nil && !errors.Is(err, campaign.ErrInvalidTransition) && !errors.Is(err, campaign.ErrConflict) {
+		return fmt.Errorf("配信状態の更新エラー: %w", err)
+	}
 	return nil
 }
 
-// getMailDataFromS3 S3からメールデータを取得する
-func getMailDataFromS3(ctx context.Context, bucketName, fileName string) (string, error) {
+// getMailDataFromS3 S3からメールデータを取得する。versionIDが空の場合は最新のオブジェクトを取得する
+func getMailDataFromS3(ctx context.Context, bucketName, fileName, versionID string) (string, error) {
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(fileName),
 	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
 
 	result, err := s3Client.GetObject(ctx, input)
 	if err != nil {
@@ -115,77 +235,84 @@ func getMailDataFromS3(ctx context.Context, bucketName, fileName string) (string
 	return string(data), nil
 }
 
-// parseMailData メールデータを解析してsubjectとbodyを取得する
-func parseMailData(mailData string) (string, string, error) {
-	lines := strings.Split(mailData, "\n")
-	if len(lines) < 3 {
-		return "", "", fmt.Errorf("メールデータの形式が不正です")
+// unsubscribeURL 宛先の配信リストの配信停止のリンクを生成する。UNSUBSCRIBE_URLが設定されていない場合は空
+func unsubscribeURL(email, list string) (string, error) {
+	if unsubscribeBaseURL == "" {
+		return "", nil
 	}
+	token, err := unsubscribeSigner.Sign(email, list, time.Now().Add(unsubscribeTokenTTL))
+	if err != nil {
+		return "", err
+	}
+	return unsubscribeBaseURL + "?token=" + url.QueryEscape(token), nil
+}
 
-	subject := lines[0]
-	body := strings.Join(lines[2:], "\n")
-
-	return subject, body, nil
+// addressStatus 送信時に確認するメールアドレスの状態
+type addressStatus struct {
+	// hasError バウンスなどでエラーになったか(has_errorが1か)
+	hasError bool
+	// unsubscribedLists 配信を停止した配信リスト
+	unsubscribedLists []string
 }
 
-// updateSendStatus DynamoDBで送信状態を確認・更新する
-func updateSendStatus(ctx context.Context, email string) (bool, error) {
-	input := &dynamodb.UpdateItemInput{
+// getAddressStatus DynamoDBでメールアドレスのエラーの有無と配信を停止した配信リストを取得する
+func getAddressStatus(ctx context.Context, email string) (addressStatus, error) {
+	input := &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"email": &types.AttributeValueMemberS{Value: email},
 		},
-		UpdateExpression: aws.String("set is_sent = :val"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":val": &types.AttributeValueMemberN{Value: "1"},
-		},
-		ReturnValues: types.ReturnValueUpdatedOld,
+		ProjectionExpression: aws.String("has_error, unsubscribed_lists"),
 	}
 
-	result, err := dynamoClient.UpdateItem(ctx, input)
+	result, err := dynamoClient.GetItem(ctx, input)
 	if err != nil {
-		return false, err
+		return addressStatus{}, err
 	}
 
-	// 前回の値を確認
-	if oldValue, exists := result.Attributes["is_sent"]; exists {
-		if numValue, ok := oldValue.(*types.AttributeValueMemberN); ok {
-			oldIsSent, err := strconv.Atoi(numValue.Value)
-			if err != nil {
-				return false, err
-			}
-			return oldIsSent == 1, nil
+	var status addressStatus
+	// 属性が存在しない場合はエラーなしとみなす
+	if numValue, ok := result.Item["has_error"].(*types.AttributeValueMemberN); ok {
+		hasError, err := strconv.Atoi(numValue.Value)
+		if err != nil {
+			return addressStatus{}, err
 		}
+		status.hasError = hasError == 1
+	}
+	if lists, ok := result.Item["unsubscribed_lists"].(*types.AttributeValueMemberSS); ok {
+		status.unsubscribedLists = lists.Value
 	}
 
-	// 属性が存在しない場合は未送信とみなす
-	return false, nil
+	return status, nil
 }
 
-// sendEmail SESを使用してメールを送信する
-func sendEmail(ctx context.Context, toEmail, subject, body string) error {
-	input := &ses.SendEmailInput{
-		Source:           aws.String(mailFrom),
-		ReplyToAddresses: []string{mailFrom},
-		Destination: &sestypes.Destination{
-			ToAddresses: []string{toEmail},
-		},
-		Message: &sestypes.Message{
-			Subject: &sestypes.Content{
-				Data:    aws.String(subject),
-				Charset: aws.String("UTF-8"),
-			},
-			Body: &sestypes.Body{
-				Text: &sestypes.Content{
-					Data:    aws.String(body),
-					Charset: aws.String("UTF-8"),
-				},
-			},
-		},
+// sendEmail メールを添付ファイルとともにMIME形式に組み立ててSESで送信し、SESのメッセージIDを返す。
+// 配信停止のリンクがある場合は、メールソフトから配信停止できるようList-Unsubscribeヘッダー(RFC 8058のワンクリック)を付ける
+func sendEmail(ctx context.Context, toEmail string, msg *mail.Message, attachments []mail.Attachment, unsubscribeLink string) (string, error) {
+	replyTo := msg.ReplyTo
+	if len(replyTo) == 0 {
+		replyTo = []string{mailFrom}
 	}
 
-	_, err := sesClient.SendEmail(ctx, input)
-	return err
+	var headers map[string]string
+	if unsubscribeLink != "" {
+		headers = map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeLink + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+
+	return sender.Send(ctx, &mail.Email{
+		From:        mailFrom,
+		FromName:    msg.FromName,
+		To:          []string{toEmail},
+		ReplyTo:     replyTo,
+		Subject:     msg.Subject,
+		Text:        msg.Text,
+		HTML:        msg.HTML,
+		Attachments: attachments,
+		Headers:     headers,
+	}, msg.Tags)
 }
 
 func main() {
@@ -200,7 +327,38 @@ func main() {
 		log.Fatalf("Environment variable MAIL_FROM is required")
 	}
 
+	unsubscribeBaseURL = os.Getenv("UNSUBSCRIBE_URL")
+	if unsubscribeBaseURL != "" {
+		// 配信停止のトークンの署名の鍵(unsubscribeと同じ値)
+		secret := os.Getenv("UNSUBSCRIBE_SECRET")
+		if secret == "" {
+			log.Fatalf("Environment variable UNSUBSCRIBE_SECRET is required when UNSUBSCRIBE_URL is set")
+		}
+		unsubscribeSigner = unsubscribe.NewSigner([]byte(secret))
+	}
+
+	// 送信レートの制限(SESの最大送信レートを同時実行数で割った値を設定する)
+	sendRate := float64(defaultSendRate)
+	if v := os.Getenv("SEND_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate <= 0 {
+			log.Fatalf("Environment variable SEND_RATE must be a positive number")
+		}
+		sendRate = rate
+	}
+	sendBurst := int(math.Max(1, math.Floor(sendRate)))
+	if v := os.Getenv("SEND_BURST"); v != "" {
+		burst, err := strconv.Atoi(v)
+		if err != nil || burst < 1 {
+			log.Fatalf("Environment variable SEND_BURST must be a positive integer")
+		}
+		sendBurst = burst
+	}
+	limiter = newTokenBucket(sendRate, sendBurst)
+
 	tableName = fmt.Sprintf("my-modern-application-sample-%s-mail-addresses", env)
+	campaignTable := fmt.Sprintf("my-modern-application-sample-%s-campaigns", env)
+	deliveryTable := fmt.Sprintf("my-modern-application-sample-%s-campaign-deliveries", env)
 
 	// AWS設定の初期化
 	ctx := context.Background()
@@ -212,7 +370,16 @@ func main() {
 	// AWSクライアントの初期化
 	s3Client = s3.NewFromConfig(cfg)
 	dynamoClient = dynamodb.NewFromConfig(cfg)
-	sesClient = ses.NewFromConfig(cfg)
+	sender = mail.NewSender(ses.NewFromConfig(cfg))
+	sqsClient = sqs.NewFromConfig(cfg)
+	queueResult, err := sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(fmt.Sprintf("my-modern-application-sample-%s-send-mail", env)),
+	})
+	if err != nil {
+		log.Fatalf("キューのURLの取得に失敗しました: %v", err)
+	}
+	queueURL = aws.ToString(queueResult.QueueUrl)
+	campaigns = campaign.NewService(campaign.NewDynamoDBStore(dynamoClient, campaignTable, deliveryTable))
 
 	lambda.Start(handler)
 }
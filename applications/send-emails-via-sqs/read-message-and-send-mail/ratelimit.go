@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+const (
+	// defaultSendRate SEND_RATEが設定されていない場合の1秒あたりの送信数(SESのサンドボックスの最大送信レート)
+	defaultSendRate = 1
+	// throttleBackoffBase SESのスロットリングで送信できなかったメッセージを再配信するまでの時間の初期値(受信回数ごとに2倍にする)
+	throttleBackoffBase = 30 * time.Second
+	// throttleBackoffMax SESのスロットリングで送信できなかったメッセージを再配信するまでの時間の上限
+	throttleBackoffMax = 15 * time.Minute
+)
+
+// tokenBucket トークンバケット方式の送信レートの制限。
+// 1秒あたりrate個のトークンを最大burst個まで補充し、送信ごとに1個消費する
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+// newTokenBucket 1秒あたりrate回・最大burst回連続で送信できるtokenBucketを生成する
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+// Wait トークンを1個消費できるまで待機する。待機中にコンテキストがキャンセルされた場合はエラーを返す
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := b.now()
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		if err := b.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext コンテキストがキャンセルされるまでの間、指定時間待機する
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isThrottled SESの最大送信レート・1日の送信数の上限によるスロットリングのエラーか
+// (SDKのリトライの上限に達した場合も含む)
+func isThrottled(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary
+}
+
+// throttleBackoff スロットリングで送信できなかったメッセージの可視性タイムアウト。
+// メッセージの受信回数ごとに2倍にして、throttleBackoffMaxを上限とする
+func throttleBackoff(record events.SQSMessage) time.Duration {
+	receiveCount, err := strconv.Atoi(record.Attributes["ApproximateReceiveCount"])
+	if err != nil || receiveCount < 1 {
+		receiveCount = 1
+	}
+	backoff := throttleBackoffBase
+	for i := 1; i < receiveCount && backoff < throttleBackoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, throttleBackoffMax)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+)
+
+// newTestTokenBucket 待機した時間だけ時刻を進めるtokenBucketを生成し、待機した時間の合計を返す関数とともに返す
+func newTestTokenBucket(rate float64, burst int) (*tokenBucket, func() time.Duration) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	b := newTokenBucket(rate, burst)
+	b.last = now
+	b.now = func() time.Time { return now }
+	b.sleep = func(_ context.Context, d time.Duration) error {
+		now = now.Add(d)
+		return nil
+	}
+	return b, func() time.Duration { return now.Sub(start) }
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	b, elapsed := newTestTokenBucket(2, 3)
+	ctx := context.Background()
+
+	// 最大burst回までは待機せずに送信できること
+	for range 3 {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatalf("Wait() returned an error: %v", err)
+		}
+	}
+	if got := elapsed(); got != 0 {
+		t.Errorf("elapsed after burst = %v, want 0", got)
+	}
+
+	// 以降は1秒あたりrate回に制限されること
+	for range 4 {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatalf("Wait() returned an error: %v", err)
+		}
+	}
+	if got := elapsed(); got != 2*time.Second {
+		t.Errorf("elapsed after 4 more sends = %v, want 2s", got)
+	}
+}
+
+func TestTokenBucketWaitCanceled(t *testing.T) {
+	b := newTokenBucket(1, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := b.Wait(ctx); err != nil {
+		t.Fatalf("Wait() returned an error: %v", err)
+	}
+	cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	throttling := &smithy.GenericAPIError{Code: "Throttling", Message: "Maximum sending rate exceeded."}
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"throttling":          {throttling, true},
+		"max attempts":        {fmt.Errorf("send: %w", &retry.MaxAttemptsError{Attempt: 3, Err: throttling}), true},
+		"message rejected":    {&smithy.GenericAPIError{Code: "MessageRejected", Message: "Email address is not verified."}, false},
+		"non-API error":       {errors.New("connection reset"), false},
+		"wrapped non-API err": {fmt.Errorf("send: %w", context.DeadlineExceeded), false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := isThrottled(tt.err); got != tt.want {
+				t.Errorf("isThrottled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":   throttleBackoffBase,
+		"1":  throttleBackoffBase,
+		"2":  2 * throttleBackoffBase,
+		"3":  4 * throttleBackoffBase,
+		"10": throttleBackoffMax,
+	}
+	for receiveCount, want := range tests {
+		record := events.SQSMessage{Attributes: map[string]string{"ApproximateReceiveCount": receiveCount}}
+		if got := throttleBackoff(record); got != want {
+			t.Errorf("throttleBackoff(ApproximateReceiveCount=%q) = %v, want %v", receiveCount, got, want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// defaultSoftBounceThreshold ソフトバウンスがこの回数続いたメールアドレスを送信対象から除外する(SOFT_BOUNCE_THRESHOLDで変更可能)
+const defaultSoftBounceThreshold = 3
+
+// timeLayout 日時の属性の形式。ソートキーとして文字列比較できるよう固定長にする
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// DynamoDBAPI receive-bounce-mailが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// addressStore メールアドレスの状態(mail-addressesテーブル)と通知の記録(イベントテーブル)の更新
+type addressStore struct {
+	client     DynamoDBAPI
+	mailTable  string
+	eventTable string
+	// softBounceThreshold 送信対象から除外するソフトバウンスの連続回数
+	softBounceThreshold int64
+	now                 func() time.Time
+}
+
+// newAddressStore addressStoreを生成する
+func newAddressStore(client DynamoDBAPI, mailTable, eventTable string, softBounceThreshold int64) *addressStore {
+	return &addressStore{
+		client:              client,
+		mailTable:           mailTable,
+		eventTable:          eventTable,
+		softBounceThreshold: softBounceThreshold,
+		now:                 time.Now,
+	}
+}
+
+// record 通知をイベントテーブルに記録し、同じトランザクションで種類に応じてメールアドレスの状態を更新する。
+//   - ハードバウンス・苦情: has_errorを1にして送信対象から除外する
+//   - ソフトバウンス: soft_bounce_countを加算し、しきい値に達した場合に除外する
+//   - 配信: soft_bounce_countを0に戻す(連続したソフトバウンスのみを数える)
+//
+// 記録済みの通知(SNSからの再送)の場合はメールアドレスの状態を再度更新しない。
+// 配信リストに登録されていないメールアドレスの場合は通知だけを記録し、状態の項目を作成しない。
+// 送信対象から除外した場合はtrueを返す
+func (s *addressStore) record(ctx context.Context, e Event) (bool, error) {
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: s.eventPut(e)},
+			{Update: s.addressUpdate(e)},
+		},
+	})
+	listed := !conditionFailed(err, 1) || conditionFailed(err, 0)
+	if !listed {
+		log.Printf("登録されていないメールアドレスのため通知だけを記録します: email=%s, type=%s, event_id=%s", e.Email, e.Type, e.EventID)
+		_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []types.TransactWriteItem{{Put: s.eventPut(e)}},
+		})
+	}
+	duplicate := conditionFailed(err, 0)
+	if duplicate {
+		log.Printf("記録済みの通知のためメールアドレスの状態を更新しません: email=%s, type=%s, event_id=%s", e.Email, e.Type, e.EventID)
+	} else if err != nil {
+		return false, fmt.Errorf("通知の記録エラー: %w", err)
+	}
+
+	switch {
+	case !listed:
+		return false, nil
+	case e.Soft():
+		// 加算後に除外できなかった場合も再送で除外できるよう、記録済みの通知でも確認する
+		return s.suppressSoftBounced(ctx, e)
+	case e.Type == notificationBounce, e.Type == notificationComplaint:
+		return !duplicate, nil
+	default:
+		return false, nil
+	}
+}
+
+// conditionFailed トランザクションのi番目の操作が条件の確認で失敗したかどうか
+func conditionFailed(err error, i int) bool {
+	var canceled *types.TransactionCanceledException
+	return errors.As(err, &canceled) && len(canceled.CancellationReasons) > i &&
+		aws.ToString(canceled.CancellationReasons[i].Code) == "ConditionalCheckFailed"
+}
+
+// eventKey イベントテーブルのソートキー(発生日時順に並べ、同じ通知は同じキーにする)
+func eventKey(e Event) string {
+	return e.OccurredAt.UTC().Format(timeLayout) + "#" + e.Type + "#" + e.EventID
+}
+
+// eventPut 通知をイベントテーブルに記録するPut。記録済みの場合は条件の確認で失敗させる
+func (s *addressStore) eventPut(e Event) *types.Put {
+	item := map[string]types.AttributeValue{
+		"email":       &types.AttributeValueMemberS{Value: e.Email},
+		"event_key":   &types.AttributeValueMemberS{Value: eventKey(e)},
+		"type":        &types.AttributeValueMemberS{Value: e.Type},
+		"message_id":  &types.AttributeValueMemberS{Value: e.MessageID},
+		"event_id":    &types.AttributeValueMemberS{Value: e.EventID},
+		"occurred_at": &types.AttributeValueMemberS{Value: e.OccurredAt.UTC().Format(timeLayout)},
+		"received_at": &types.AttributeValueMemberS{Value: s.now().UTC().Format(timeLayout)},
+	}
+	// 空文字の属性は保存しない
+	for name, value := range map[string]string{"sub_type": e.SubType, "status": e.Status, "diagnostic": e.Diagnostic} {
+		if value != "" {
+			item[name] = &types.AttributeValueMemberS{Value: value}
+		}
+	}
+	return &types.Put{
+		TableName:           aws.String(s.eventTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(email)"),
+	}
+}
+
+// addressUpdate 通知の種類に応じてメールアドレスの状態を更新するUpdate。
+// ソフトバウンスと配信では登録されていないメールアドレスの項目を作成しないよう、条件の確認で失敗させる
+func (s *addressStore) addressUpdate(e Event) *types.Update {
+	occurredAt := &types.AttributeValueMemberS{Value: e.OccurredAt.UTC().Format(timeLayout)}
+	values := map[string]types.AttributeValue{":occurred_at": occurredAt}
+	var expression string
+	var condition *string
+	switch {
+	case e.Soft():
+		condition = aws.String("attribute_exists(email)")
+		expression = "ADD soft_bounce_count :one SET last_bounced_at = :occurred_at, last_bounce_type = :sub_type, last_diagnostic = :diagnostic"
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+		values[":sub_type"] = &types.AttributeValueMemberS{Value: e.SubType}
+		values[":diagnostic"] = &types.AttributeValueMemberS{Value: e.Diagnostic}
+	case e.Type == notificationBounce:
+		expression = "SET has_error = :one, suppression_reason = :reason, suppressed_at = :occurred_at, " +
+			"last_bounced_at = :occurred_at, last_bounce_type = :sub_type, last_diagnostic = :diagnostic"
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+		values[":reason"] = &types.AttributeValueMemberS{Value: e.Type + ":" + e.SubType}
+		values[":sub_type"] = &types.AttributeValueMemberS{Value: e.SubType}
+		values[":diagnostic"] = &types.AttributeValueMemberS{Value: e.Diagnostic}
+	case e.Type == notificationComplaint:
+		expression = "SET has_error = :one, suppression_reason = :reason, suppressed_at = :occurred_at, last_complained_at = :occurred_at"
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+		values[":reason"] = &types.AttributeValueMemberS{Value: e.Type + ":" + e.SubType}
+	default:
+		condition = aws.String("attribute_exists(email)")
+		expression = "SET last_delivered_at = :occurred_at, soft_bounce_count = :zero"
+		values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+	}
+	return &types.Update{
+		TableName: aws.String(s.mailTable),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: e.Email},
+		},
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       condition,
+		ExpressionAttributeValues: values,
+	}
+}
+
+// suppressSoftBounced ソフトバウンスの連続回数がしきい値に達したメールアドレスを送信対象から除外する。
+// しきい値に達していない場合と除外済みの場合はfalseを返す
+func (s *addressStore) suppressSoftBounced(ctx context.Context, e Event) (bool, error) {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.mailTable),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: e.Email},
+		},
+		UpdateExpression:    aws.String("SET has_error = :one, suppression_reason = :reason, suppressed_at = :occurred_at"),
+		ConditionExpression: aws.String("soft_bounce_count >= :threshold AND (attribute_not_exists(has_error) OR has_error <> :one)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one":         &types.AttributeValueMemberN{Value: "1"},
+			":reason":      &types.AttributeValueMemberS{Value: e.Type + ":" + e.SubType},
+			":occurred_at": &types.AttributeValueMemberS{Value: e.OccurredAt.UTC().Format(timeLayout)},
+			":threshold":   &types.AttributeValueMemberN{Value: strconv.FormatInt(s.softBounceThreshold, 10)},
+		},
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ソフトバウンスによる除外エラー: %w", err)
+	}
+	return true, nil
+}
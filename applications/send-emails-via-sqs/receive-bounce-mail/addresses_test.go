@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeAddress fakeDynamoDBが保持するメールアドレスの状態
+type fakeAddress struct {
+	hasError        bool
+	softBounceCount int64
+	reason          string
+}
+
+// fakeDynamoDB addressStoreが使用する更新式だけを解釈して、mail-addressesテーブルとイベントテーブルを模したクライアント
+type fakeDynamoDB struct {
+	addresses map[string]*fakeAddress
+	events    map[string]map[string]types.AttributeValue
+	// suppressErr 次のソフトバウンスによる除外で返すエラー
+	suppressErr error
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{
+		addresses: make(map[string]*fakeAddress),
+		events:    make(map[string]map[string]types.AttributeValue),
+	}
+}
+
+func (f *fakeDynamoDB) address(key map[string]types.AttributeValue) *fakeAddress {
+	email := key["email"].(*types.AttributeValueMemberS).Value
+	if f.addresses[email] == nil {
+		f.addresses[email] = &fakeAddress{}
+	}
+	return f.addresses[email]
+}
+
+// register 配信リストにメールアドレスを登録する
+func (f *fakeDynamoDB) register(email string) {
+	f.addresses[email] = &fakeAddress{}
+}
+
+func (f *fakeDynamoDB) TransactWriteItems(_ context.Context, params *dynamodb.TransactWriteItemsInput, _ ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	put := params.TransactItems[0].Put
+	key := put.Item["email"].(*types.AttributeValueMemberS).Value + "|" + put.Item["event_key"].(*types.AttributeValueMemberS).Value
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	for i := range reasons {
+		reasons[i].Code = aws.String("None")
+	}
+	canceled := false
+	if _, ok := f.events[key]; ok {
+		reasons[0].Code, canceled = aws.String("ConditionalCheckFailed"), true
+	}
+	var update *types.Update
+	if len(params.TransactItems) > 1 {
+		update = params.TransactItems[1].Update
+		email := update.Key["email"].(*types.AttributeValueMemberS).Value
+		if update.ConditionExpression != nil && f.addresses[email] == nil {
+			reasons[1].Code, canceled = aws.String("ConditionalCheckFailed"), true
+		}
+	}
+	if canceled {
+		return nil, &types.TransactionCanceledException{CancellationReasons: reasons}
+	}
+	f.events[key] = put.Item
+	if update == nil {
+		return &dynamodb.TransactWriteItemsOutput{}, nil
+	}
+
+	a := f.address(update.Key)
+	expression := aws.ToString(update.UpdateExpression)
+	switch {
+	case strings.Contains(expression, "ADD soft_bounce_count :one"):
+		a.softBounceCount++
+	case strings.Contains(expression, "soft_bounce_count = :zero"):
+		a.softBounceCount = 0
+	}
+	if strings.Contains(expression, "has_error = :one") {
+		a.hasError = true
+		a.reason = update.ExpressionAttributeValues[":reason"].(*types.AttributeValueMemberS).Value
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (f *fakeDynamoDB) UpdateItem(_ context.Context, params *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	if err := f.suppressErr; err != nil {
+		f.suppressErr = nil
+		return nil, err
+	}
+	a := f.addresses[params.Key["email"].(*types.AttributeValueMemberS).Value]
+	if a == nil {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	threshold, _ := strconv.ParseInt(params.ExpressionAttributeValues[":threshold"].(*types.AttributeValueMemberN).Value, 10, 64)
+	if a.softBounceCount < threshold || a.hasError {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	a.hasError = true
+	a.reason = params.ExpressionAttributeValues[":reason"].(*types.AttributeValueMemberS).Value
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// newEvent 発生日時とイベントIDを指定した通知の記録を生成する
+func newEvent(typ, subType, eventID string, minute int) Event {
+	return Event{
+		Email:      "user@example.com",
+		Type:       typ,
+		SubType:    subType,
+		MessageID:  "m-" + eventID,
+		EventID:    eventID,
+		Diagnostic: "diagnostic",
+		OccurredAt: time.Date(2026, 1, 2, 3, minute, 0, 0, time.UTC),
+	}
+}
+
+func TestRecordSuppressesHardBounceAndComplaint(t *testing.T) {
+	for _, e := range []Event{
+		newEvent(notificationBounce, "Permanent/General", "f1", 0),
+		newEvent(notificationComplaint, "abuse", "f1", 0),
+	} {
+		t.Run(e.Type, func(t *testing.T) {
+			client := newFakeDynamoDB()
+			store := newAddressStore(client, "mail", "events", 3)
+
+			suppressed, err := store.record(context.Background(), e)
+			if err != nil {
+				t.Fatalf("record() returned an error: %v", err)
+			}
+			a := client.addresses[e.Email]
+			if !suppressed || !a.hasError || a.reason != e.Type+":"+e.SubType {
+				t.Errorf("suppressed = %v, address = %+v", suppressed, a)
+			}
+			if len(client.events) != 1 {
+				t.Errorf("len(events) = %d, want 1", len(client.events))
+			}
+
+			// SNSから再送された通知は重複して記録しない
+			suppressed, err = store.record(context.Background(), e)
+			if err != nil || suppressed || len(client.events) != 1 {
+				t.Errorf("record() of a duplicate = %v, %v, len(events) = %d", suppressed, err, len(client.events))
+			}
+		})
+	}
+}
+
+func TestRecordSoftBounceThreshold(t *testing.T) {
+	client := newFakeDynamoDB()
+	client.register("user@example.com")
+	store := newAddressStore(client, "mail", "events", 3)
+	ctx := context.Background()
+
+	// しきい値に達するまでは除外しない
+	for i, eventID := range []string{"f1", "f2"} {
+		suppressed, err := store.record(ctx, newEvent(notificationBounce, "Transient/MailboxFull", eventID, i))
+		if err != nil || suppressed {
+			t.Fatalf("record(%s) = %v, %v, want false, nil", eventID, suppressed, err)
+		}
+	}
+
+	// 配信されると連続回数を0に戻す
+	if _, err := store.record(ctx, newEvent(notificationDelivery, "", "d1", 2)); err != nil {
+		t.Fatalf("record(delivery) returned an error: %v", err)
+	}
+	if got := client.addresses["user@example.com"].softBounceCount; got != 0 {
+		t.Fatalf("softBounceCount after delivery = %d, want 0", got)
+	}
+
+	for i, eventID := range []string{"f3", "f4", "f5"} {
+		suppressed, err := store.record(ctx, newEvent(notificationBounce, "Transient/MailboxFull", eventID, 3+i))
+		if err != nil {
+			t.Fatalf("record(%s) returned an error: %v", eventID, err)
+		}
+		if want := eventID == "f5"; suppressed != want {
+			t.Errorf("record(%s) suppressed = %v, want %v", eventID, suppressed, want)
+		}
+	}
+	a := client.addresses["user@example.com"]
+	if !a.hasError || a.reason != "Bounce:Transient/MailboxFull" {
+		t.Errorf("address = %+v", a)
+	}
+	if len(client.events) != 6 {
+		t.Errorf("len(events) = %d, want 6", len(client.events))
+	}
+}
+
+func TestRecordSoftBounceRetriesSuppression(t *testing.T) {
+	client := newFakeDynamoDB()
+	store := newAddressStore(client, "mail", "events", 1)
+	ctx := context.Background()
+	e := newEvent(notificationBounce, "Undetermined", "f1", 0)
+	client.register(e.Email)
+
+	// 加算後の除外に失敗した場合はエラーを返す
+	client.suppressErr = errors.New("throttled")
+	if _, err := store.record(ctx, e); err == nil {
+		t.Fatal("record() returned no error")
+	}
+
+	// 再送された通知では加算せずに除外だけを行う
+	suppressed, err := store.record(ctx, e)
+	if err != nil || !suppressed {
+		t.Fatalf("record() of a retry = %v, %v, want true, nil", suppressed, err)
+	}
+	if got := client.addresses[e.Email].softBounceCount; got != 1 {
+		t.Errorf("softBounceCount = %d, want 1", got)
+	}
+}
+
+func TestRecordUnlistedAddress(t *testing.T) {
+	for _, e := range []Event{
+		newEvent(notificationBounce, "Transient/MailboxFull", "f1", 0),
+		newEvent(notificationDelivery, "", "d1", 0),
+	} {
+		t.Run(e.Type, func(t *testing.T) {
+			client := newFakeDynamoDB()
+			store := newAddressStore(client, "mail", "events", 1)
+
+			// 登録されていないメールアドレスは通知だけを記録し、状態の項目を作成しない
+			for i := 0; i < 2; i++ {
+				suppressed, err := store.record(context.Background(), e)
+				if err != nil || suppressed {
+					t.Fatalf("record() = %v, %v, want false, nil", suppressed, err)
+				}
+			}
+			if _, ok := client.addresses[e.Email]; ok {
+				t.Error("an address item is created for an unlisted address")
+			}
+			if len(client.events) != 1 {
+				t.Errorf("len(events) = %d, want 1", len(client.events))
+			}
+		})
+	}
+}
+
+func TestEventPutOmitsEmptyAttributes(t *testing.T) {
+	store := newAddressStore(newFakeDynamoDB(), "mail", "events", 3)
+	store.now = func() time.Time { return time.Date(2026, 1, 2, 4, 0, 0, 0, time.UTC) }
+
+	put := store.eventPut(newEvent(notificationDelivery, "", "m1", 0))
+	if _, ok := put.Item["sub_type"]; ok {
+		t.Error("sub_type is stored for an empty value")
+	}
+	want := map[string]string{
+		"event_key":   "2026-01-02T03:00:00.000000000Z#Delivery#m1",
+		"occurred_at": "2026-01-02T03:00:00.000000000Z",
+		"received_at": "2026-01-02T04:00:00.000000000Z",
+		"diagnostic":  "diagnostic",
+	}
+	for name, value := range want {
+		if got := put.Item[name].(*types.AttributeValueMemberS).Value; got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
@@ -3,57 +3,28 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/campaign"
 )
 
-// DynamoDBクライアント
-var dynamoClient *dynamodb.Client
+// メールアドレスの状態と通知の記録
+var addresses *addressStore
 
-// 環境変数
-var mailTable string
+// 配信状態の操作
+var campaigns *campaign.Service
 
-// SNSメッセージの構造体
-type SNSMessage struct {
-	NotificationType string `json:"notificationType"`
-	Bounce           Bounce `json:"bounce"`
-}
-
-type Bounce struct {
-	BouncedRecipients []BouncedRecipient `json:"bouncedRecipients"`
-}
-
-type BouncedRecipient struct {
-	EmailAddress string `json:"emailAddress"`
-}
-
-// 初期化処理
-func init() {
-	// 環境変数の読み込み
-	mailTable = os.Getenv("MAIL_TABLE")
-	if mailTable == "" {
-		log.Fatal("MAIL_TABLE environment variable is required")
-	}
-
-	// AWS設定の読み込み
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Fatal("AWS設定の読み込みに失敗しました:", err)
-	}
-
-	// DynamoDBクライアントの初期化
-	dynamoClient = dynamodb.NewFromConfig(cfg)
-}
-
-// Lambda ハンドラー関数
+// Lambda ハンドラー関数。
+// 通知の記録に失敗した場合はエラーを返して非同期呼び出しのリトライで再処理する(記録済みの通知は重複して処理しない)
 func handler(ctx context.Context, event events.SNSEvent) error {
+	var errs []error
 	// SNSイベントの各レコードを処理
 	for _, record := range event.Records {
 		// SNSメッセージの取得
@@ -66,42 +37,108 @@ func handler(ctx context.Context, event events.SNSEvent) error {
 			continue
 		}
 
-		// バウンス通知の場合のみ処理
-		if snsMessage.NotificationType == "Bounce" {
-			// バウンスした受信者の処理
-			for _, bouncedRecipient := range snsMessage.Bounce.BouncedRecipients {
-				email := bouncedRecipient.EmailAddress
-
-				// DynamoDBのhas_errorフィールドを1に更新
-				if err := updateErrorStatus(ctx, email); err != nil {
-					log.Printf("メールアドレス %s のエラーステータス更新に失敗しました: %v", email, err)
-				} else {
-					log.Printf("メールアドレス %s のエラーステータスを更新しました", email)
-				}
-			}
+		if err := processNotification(ctx, &snsMessage); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-// DynamoDBのhas_errorフィールドを更新する関数
-func updateErrorStatus(ctx context.Context, email string) error {
-	// UpdateItemの実行
-	_, err := dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(mailTable),
-		Key: map[string]types.AttributeValue{
-			"email": &types.AttributeValueMemberS{Value: email},
-		},
-		UpdateExpression: aws.String("set has_error = :val"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":val": &types.AttributeValueMemberN{Value: "1"},
-		},
-	})
-
-	return err
+// processNotification バウンス・苦情・配信の通知を宛先ごとに記録し、メールアドレスと配信の状態を更新する
+func processNotification(ctx context.Context, snsMessage *SNSMessage) error {
+	notifications, err := snsMessage.Events()
+	if err != nil {
+		log.Printf("通知を処理しません: %v", err)
+		return nil
+	}
+
+	var errs []error
+	// bounced ハードバウンス、または送信対象から除外されたソフトバウンスを受け取ったかどうか
+	bounced := false
+	for _, e := range notifications {
+		suppressed, err := addresses.record(ctx, e)
+		if err != nil {
+			log.Printf("メールアドレス %s の通知の記録に失敗しました: type=%s, error=%v", e.Email, e.Type, err)
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("メールアドレス %s の通知を記録しました: type=%s, sub_type=%s, diagnostic=%s", e.Email, e.Type, e.SubType, e.Diagnostic)
+		if suppressed {
+			log.Printf("メールアドレス %s を送信対象から除外しました", e.Email)
+		}
+		if e.Type == notificationBounce && (!e.Soft() || suppressed) {
+			bounced = true
+		}
+	}
+
+	// 配信の送信メールの場合は配信状態を更新(除外に至らないソフトバウンスでは更新しない)
+	switch snsMessage.NotificationType {
+	case notificationBounce:
+		if bounced {
+			updateDeliveryStatus(ctx, snsMessage.Mail.MessageID, campaign.StatusBounced)
+		}
+	case notificationComplaint:
+		updateDeliveryStatus(ctx, snsMessage.Mail.MessageID, campaign.StatusComplained)
+	}
+
+	return errors.Join(errs...)
+}
+
+// 送信メールのメッセージIDで特定した配信状態を更新する関数
+func updateDeliveryStatus(ctx context.Context, messageID string, status campaign.Status) {
+	d, changed, err := campaigns.TransitionByMessageID(ctx, messageID, status)
+	switch {
+	case errors.Is(err, campaign.ErrNotFound):
+		// 配信以外(ユーザー登録など)で送信したメール
+		log.Printf("配信の送信メールではないため配信状態を更新しません: message_id=%s", messageID)
+	case errors.Is(err, campaign.ErrInvalidTransition), errors.Is(err, campaign.ErrConflict):
+		log.Printf("配信状態を更新できませんでした: message_id=%s, error=%v", messageID, err)
+	case err != nil:
+		log.Printf("配信状態の更新に失敗しました: message_id=%s, error=%v", messageID, err)
+	case changed:
+		log.Printf("配信状態を更新しました: campaign_id=%s, email=%s, status=%s", d.CampaignID, d.Email, status)
+	}
 }
 
 func main() {
+	// 初期化処理(テストで実行されないようmainで行う)
+	// 環境変数の読み込み
+	mailTable := os.Getenv("MAIL_TABLE")
+	if mailTable == "" {
+		log.Fatal("MAIL_TABLE environment variable is required")
+	}
+	campaignTable := os.Getenv("CAMPAIGN_TABLE")
+	if campaignTable == "" {
+		log.Fatal("CAMPAIGN_TABLE environment variable is required")
+	}
+	deliveryTable := os.Getenv("CAMPAIGN_DELIVERY_TABLE")
+	if deliveryTable == "" {
+		log.Fatal("CAMPAIGN_DELIVERY_TABLE environment variable is required")
+	}
+	eventTable := os.Getenv("MAIL_EVENT_TABLE")
+	if eventTable == "" {
+		log.Fatal("MAIL_EVENT_TABLE environment variable is required")
+	}
+	softBounceThreshold := int64(defaultSoftBounceThreshold)
+	if v := os.Getenv("SOFT_BOUNCE_THRESHOLD"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			log.Fatal("SOFT_BOUNCE_THRESHOLD environment variable must be a positive integer")
+		}
+		softBounceThreshold = n
+	}
+
+	// AWS設定の読み込み
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal("AWS設定の読み込みに失敗しました:", err)
+	}
+
+	// DynamoDBクライアントの初期化
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+	addresses = newAddressStore(dynamoClient, mailTable, eventTable, softBounceThreshold)
+	campaigns = campaign.NewService(campaign.NewDynamoDBStore(dynamoClient, campaignTable, deliveryTable))
+
 	lambda.Start(handler)
 }
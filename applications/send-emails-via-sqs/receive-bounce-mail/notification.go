@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SESの通知の種類
+const (
+	notificationBounce    = "Bounce"
+	notificationComplaint = "Complaint"
+	notificationDelivery  = "Delivery"
+)
+
+// SESのバウンスの種類
+const (
+	// bouncePermanent 存在しないメールアドレスなど、再送しても届かないバウンス(ハードバウンス)
+	bouncePermanent = "Permanent"
+	// bounceUndetermined SESが種類を判定できなかったバウンス。
+	// メールボックスの容量超過などの一時的なバウンス(Transient)と同じくソフトバウンスとして扱う
+	bounceUndetermined = "Undetermined"
+)
+
+// SNSメッセージの構造体
+type SNSMessage struct {
+	NotificationType string    `json:"notificationType"`
+	Bounce           Bounce    `json:"bounce"`
+	Complaint        Complaint `json:"complaint"`
+	Delivery         Delivery  `json:"delivery"`
+	Mail             Mail      `json:"mail"`
+}
+
+// Mail 通知の対象となった送信メール
+type Mail struct {
+	MessageID string    `json:"messageId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Bounce バウンス通知の内容
+type Bounce struct {
+	BounceType        string             `json:"bounceType"`
+	BounceSubType     string             `json:"bounceSubType"`
+	BouncedRecipients []BouncedRecipient `json:"bouncedRecipients"`
+	Timestamp         time.Time          `json:"timestamp"`
+	FeedbackID        string             `json:"feedbackId"`
+}
+
+// BouncedRecipient バウンスした宛先
+type BouncedRecipient struct {
+	EmailAddress string `json:"emailAddress"`
+	// Status SMTPの拡張ステータスコード(例: 5.1.1)
+	Status string `json:"status"`
+	// DiagnosticCode 受信側のメールサーバーの応答
+	DiagnosticCode string `json:"diagnosticCode"`
+}
+
+// Complaint 苦情通知の内容
+type Complaint struct {
+	ComplainedRecipients []ComplainedRecipient `json:"complainedRecipients"`
+	Timestamp            time.Time             `json:"timestamp"`
+	FeedbackID           string                `json:"feedbackId"`
+	// ComplaintFeedbackType 受信者のメールサービスが報告した苦情の種類(例: abuse)
+	ComplaintFeedbackType string `json:"complaintFeedbackType"`
+	UserAgent             string `json:"userAgent"`
+}
+
+// ComplainedRecipient 苦情を報告した宛先
+type ComplainedRecipient struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+// Delivery 配信通知の内容
+type Delivery struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Recipients   []string  `json:"recipients"`
+	SMTPResponse string    `json:"smtpResponse"`
+}
+
+// Event 宛先ごとの通知の記録
+type Event struct {
+	Email string
+	// Type 通知の種類(Bounce・Complaint・Delivery)
+	Type string
+	// SubType バウンスの種類(Permanent・Transient・Undetermined)と詳細、または苦情の種類
+	SubType   string
+	MessageID string
+	// EventID 通知の識別子。SNSから同じ通知が再送された場合の重複の判定に使用する
+	EventID string
+	// Status SMTPの拡張ステータスコード
+	Status string
+	// Diagnostic 受信側のメールサーバーの応答などの診断情報
+	Diagnostic string
+	// OccurredAt バウンス・苦情・配信が発生した日時
+	OccurredAt time.Time
+}
+
+// Soft ソフトバウンス(一時的なバウンス)か
+func (e Event) Soft() bool {
+	return e.Type == notificationBounce && !isPermanent(e.SubType)
+}
+
+// isPermanent バウンスの種類がハードバウンスか
+func isPermanent(subType string) bool {
+	return strings.HasPrefix(subType, bouncePermanent)
+}
+
+// Events 通知を宛先ごとの記録に変換する。未対応の種類の場合はエラー
+func (m *SNSMessage) Events() ([]Event, error) {
+	var events []Event
+	switch m.NotificationType {
+	case notificationBounce:
+		subType := m.Bounce.BounceType
+		if subType == "" {
+			subType = bounceUndetermined
+		}
+		if m.Bounce.BounceSubType != "" {
+			subType += "/" + m.Bounce.BounceSubType
+		}
+		for _, r := range m.Bounce.BouncedRecipients {
+			events = append(events, Event{
+				Email:      r.EmailAddress,
+				Type:       notificationBounce,
+				SubType:    subType,
+				MessageID:  m.Mail.MessageID,
+				EventID:    m.Bounce.FeedbackID,
+				Status:     r.Status,
+				Diagnostic: r.DiagnosticCode,
+				OccurredAt: m.Bounce.Timestamp,
+			})
+		}
+	case notificationComplaint:
+		for _, r := range m.Complaint.ComplainedRecipients {
+			events = append(events, Event{
+				Email:      r.EmailAddress,
+				Type:       notificationComplaint,
+				SubType:    m.Complaint.ComplaintFeedbackType,
+				MessageID:  m.Mail.MessageID,
+				EventID:    m.Complaint.FeedbackID,
+				Diagnostic: m.Complaint.UserAgent,
+				OccurredAt: m.Complaint.Timestamp,
+			})
+		}
+	case notificationDelivery:
+		for _, email := range m.Delivery.Recipients {
+			events = append(events, Event{
+				Email:      email,
+				Type:       notificationDelivery,
+				MessageID:  m.Mail.MessageID,
+				EventID:    m.Mail.MessageID,
+				Diagnostic: m.Delivery.SMTPResponse,
+				OccurredAt: m.Delivery.Timestamp,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("未対応の通知の種類です: %s", m.NotificationType)
+	}
+	return events, nil
+}
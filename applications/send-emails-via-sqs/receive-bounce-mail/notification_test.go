@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	tests := map[string]struct {
+		message string
+		want    []Event
+		soft    bool
+	}{
+		"permanent bounce": {
+			message: `{"notificationType":"Bounce","mail":{"messageId":"m1"},"bounce":{"bounceType":"Permanent","bounceSubType":"General","timestamp":"2026-01-02T03:04:05.000Z","feedbackId":"f1",
+				"bouncedRecipients":[{"emailAddress":"a@example.com","status":"5.1.1","diagnosticCode":"smtp; 550 5.1.1 user unknown"}]}}`,
+			want: []Event{{Email: "a@example.com", Type: "Bounce", SubType: "Permanent/General", MessageID: "m1", EventID: "f1",
+				Status: "5.1.1", Diagnostic: "smtp; 550 5.1.1 user unknown", OccurredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		},
+		"transient bounce": {
+			message: `{"notificationType":"Bounce","mail":{"messageId":"m1"},"bounce":{"bounceType":"Transient","bounceSubType":"MailboxFull","timestamp":"2026-01-02T03:04:05.000Z","feedbackId":"f1",
+				"bouncedRecipients":[{"emailAddress":"a@example.com"},{"emailAddress":"b@example.com"}]}}`,
+			want: []Event{
+				{Email: "a@example.com", Type: "Bounce", SubType: "Transient/MailboxFull", MessageID: "m1", EventID: "f1", OccurredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+				{Email: "b@example.com", Type: "Bounce", SubType: "Transient/MailboxFull", MessageID: "m1", EventID: "f1", OccurredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+			},
+			soft: true,
+		},
+		"undetermined bounce": {
+			message: `{"notificationType":"Bounce","mail":{"messageId":"m1"},"bounce":{"timestamp":"2026-01-02T03:04:05.000Z","feedbackId":"f1","bouncedRecipients":[{"emailAddress":"a@example.com"}]}}`,
+			want:    []Event{{Email: "a@example.com", Type: "Bounce", SubType: "Undetermined", MessageID: "m1", EventID: "f1", OccurredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}},
+			soft:    true,
+		},
+		"complaint": {
+			message: `{"notificationType":"Complaint","mail":{"messageId":"m1"},"complaint":{"timestamp":"2026-01-02T03:04:05.000Z","feedbackId":"f1","complaintFeedbackType":"abuse","userAgent":"ExampleMail",
+				"complainedRecipients":[{"emailAddress":"a@example.com"}]}}`,
+			want: []Event{{Email: "a@example.com", Type: "Complaint", SubType: "abuse", MessageID: "m1", EventID: "f1", Diagnostic: "ExampleMail", OccurredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		},
+		"delivery": {
+			message: `{"notificationType":"Delivery","mail":{"messageId":"m1"},"delivery":{"timestamp":"2026-01-02T03:04:05.000Z","recipients":["a@example.com"],"smtpResponse":"250 ok"}}`,
+			want:    []Event{{Email: "a@example.com", Type: "Delivery", MessageID: "m1", EventID: "m1", Diagnostic: "250 ok", OccurredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var m SNSMessage
+			if err := json.Unmarshal([]byte(tt.message), &m); err != nil {
+				t.Fatalf("Unmarshal() returned an error: %v", err)
+			}
+			got, err := m.Events()
+			if err != nil {
+				t.Fatalf("Events() returned an error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("len(Events()) = %d, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !got[i].OccurredAt.Equal(tt.want[i].OccurredAt) {
+					t.Errorf("Events()[%d].OccurredAt = %v, want %v", i, got[i].OccurredAt, tt.want[i].OccurredAt)
+				}
+				got[i].OccurredAt, tt.want[i].OccurredAt = time.Time{}, time.Time{}
+				if got[i] != tt.want[i] {
+					t.Errorf("Events()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+				if got[i].Soft() != tt.soft {
+					t.Errorf("Events()[%d].Soft() = %v, want %v", i, got[i].Soft(), tt.soft)
+				}
+			}
+		})
+	}
+}
+
+func TestEventsUnsupported(t *testing.T) {
+	m := SNSMessage{NotificationType: "AmazonSnsSubscriptionSucceeded"}
+	if _, err := m.Events(); err == nil {
+		t.Error("Events() returned no error")
+	}
+}
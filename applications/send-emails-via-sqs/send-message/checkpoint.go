@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// checkpointTTL チェックポイントを保持する期間
+const checkpointTTL = 30 * 24 * time.Hour
+
+// Checkpoint 配信の進捗。Lambdaがタイムアウトした場合に続きから再開するために保存する
+type Checkpoint struct {
+	// CampaignID 配信の識別子(S3のバケット名・キー・バージョン)
+	CampaignID string
+	// LastKey 最後に処理した宛先のhas_error-indexのキー(未処理の場合はnil)。再開時のExclusiveStartKeyにする
+	LastKey map[string]types.AttributeValue
+	// Queued キューに登録した件数
+	Queued int64
+	// Enqueued 処理中のバッチで登録済みの宛先(バッチの途中で失敗した場合に、再開時に登録し直さないよう保持する)
+	Enqueued []string
+	// Completed 全ての宛先の登録が完了したかどうか
+	Completed bool
+}
+
+// CheckpointStore チェックポイントの永続化
+type CheckpointStore interface {
+	// Get チェックポイントを取得する。存在しない場合は最初から処理するチェックポイントを返す
+	Get(ctx context.Context, campaignID string) (*Checkpoint, error)
+	// Save チェックポイントを保存する
+	Save(ctx context.Context, cp *Checkpoint) error
+}
+
+// DynamoDBCheckpointStore DynamoDBのsend-message-checkpointsテーブルを使用するストア
+type DynamoDBCheckpointStore struct {
+	client    DynamoDBAPI
+	tableName string
+	now       func() time.Time
+}
+
+// NewDynamoDBCheckpointStore DynamoDBCheckpointStoreを生成する
+func NewDynamoDBCheckpointStore(client DynamoDBAPI, tableName string) *DynamoDBCheckpointStore {
+	return &DynamoDBCheckpointStore{client: client, tableName: tableName, now: time.Now}
+}
+
+// Get チェックポイントを取得する
+func (s *DynamoDBCheckpointStore) Get(ctx context.Context, campaignID string) (*Checkpoint, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		Key:            map[string]types.AttributeValue{"campaign_id": &types.AttributeValueMemberS{Value: campaignID}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{CampaignID: campaignID}
+	if out.Item == nil {
+		return cp, nil
+	}
+
+	if v, ok := out.Item["last_key"].(*types.AttributeValueMemberM); ok {
+		cp.LastKey = v.Value
+	}
+	queued, err := intAttr(out.Item, "queued")
+	if err != nil {
+		return nil, err
+	}
+	cp.Queued = int64(queued)
+	if v, ok := out.Item["enqueued"].(*types.AttributeValueMemberSS); ok {
+		cp.Enqueued = v.Value
+	}
+	if v, ok := out.Item["completed"].(*types.AttributeValueMemberBOOL); ok {
+		cp.Completed = v.Value
+	}
+	return cp, nil
+}
+
+// Save チェックポイントを保存する。expires_at属性をTTLに設定し、古いチェックポイントを自動削除する
+func (s *DynamoDBCheckpointStore) Save(ctx context.Context, cp *Checkpoint) error {
+	now := s.now()
+	item := map[string]types.AttributeValue{
+		"campaign_id": &types.AttributeValueMemberS{Value: cp.CampaignID},
+		"queued":      &types.AttributeValueMemberN{Value: strconv.FormatInt(cp.Queued, 10)},
+		"completed":   &types.AttributeValueMemberBOOL{Value: cp.Completed},
+		"updated_at":  &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		"expires_at":  &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(checkpointTTL).Unix(), 10)},
+	}
+	if cp.LastKey != nil {
+		item["last_key"] = &types.AttributeValueMemberM{Value: cp.LastKey}
+	}
+	// 文字列セットは空にできないため、登録済みの宛先がない場合は属性を書き込まない
+	if len(cp.Enqueued) > 0 {
+		item["enqueued"] = &types.AttributeValueMemberSS{Value: cp.Enqueued}
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	return err
+}
+
+// intAttr 数値属性の値を取得する。存在しない場合は0
+func intAttr(item map[string]types.AttributeValue, name string) (int, error) {
+	v, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v.Value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+)
+
+// LambdaAPI send-messageが使用するLambdaクライアントのメソッド
+type LambdaAPI interface {
+	Invoke(ctx context.Context, params *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error)
+}
+
+// continueAsync 中断した配信の続きを処理するため、未処理のS3イベントで自身を非同期に呼び出す。
+// 進捗はチェックポイントテーブルに保存済みのため、呼び出された実行は保存した進捗から再開する。
+// 非同期呼び出しのリトライ(最大2回)と異なり、中断の回数に上限はない
+func continueAsync(ctx context.Context, client LambdaAPI, functionName string, records []events.S3EventRecord) error {
+	payload, err := json.Marshal(events.S3Event{Records: records})
+	if err != nil {
+		return fmt.Errorf("failed to marshal continuation event: %w", err)
+	}
+	_, err = client.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(functionName),
+		InvocationType: lambdaTypes.InvocationTypeEvent,
+		Payload:        payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invoke continuation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdaTypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+)
+
+// fakeLambda 呼び出しを記録するLambdaクライアント
+type fakeLambda struct {
+	invocations []*lambda.InvokeInput
+}
+
+func (f *fakeLambda) Invoke(_ context.Context, params *lambda.InvokeInput, _ ...func(*lambda.Options)) (*lambda.InvokeOutput, error) {
+	f.invocations = append(f.invocations, params)
+	return &lambda.InvokeOutput{StatusCode: 202}, nil
+}
+
+func TestContinueAsync(t *testing.T) {
+	client := &fakeLambda{}
+	records := []events.S3EventRecord{{}, {}}
+	records[0].S3.Bucket.Name, records[0].S3.Object.Key, records[0].S3.Object.VersionID = "bucket", "mail.txt", "v1"
+	records[1].S3.Bucket.Name, records[1].S3.Object.Key, records[1].S3.Object.VersionID = "bucket", "next.txt", "v2"
+
+	if err := continueAsync(context.Background(), client, "send-message", records); err != nil {
+		t.Fatalf("continueAsync() returned an error: %v", err)
+	}
+	if len(client.invocations) != 1 {
+		t.Fatalf("len(invocations) = %d, want 1", len(client.invocations))
+	}
+	in := client.invocations[0]
+	if aws.ToString(in.FunctionName) != "send-message" || in.InvocationType != lambdaTypes.InvocationTypeEvent {
+		t.Errorf("FunctionName = %s, InvocationType = %s", aws.ToString(in.FunctionName), in.InvocationType)
+	}
+
+	// 中断した配信から後のレコードを同じS3イベントの形式で渡す
+	var event events.S3Event
+	if err := json.Unmarshal(in.Payload, &event); err != nil {
+		t.Fatalf("payload is not an S3 event: %v", err)
+	}
+	if len(event.Records) != 2 || event.Records[0].S3.Object.Key != "mail.txt" || event.Records[1].S3.Object.VersionID != "v2" {
+		t.Errorf("records = %+v", event.Records)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/campaign"
+)
+
+const (
+	// batchSize 1回のSendMessageBatchで扱う宛先の数(SendMessageBatchの上限)
+	batchSize = 10
+	// maxSendAttempts 失敗したエントリを再送信する場合の最大試行回数
+	maxSendAttempts = 3
+)
+
+// retryInterval 失敗したエントリを再送信するまでの待機時間の初期値(試行ごとに2倍にする)
+var retryInterval = 200 * time.Millisecond
+
+// SQSAPI send-messageが使用するSQSクライアントのメソッド
+type SQSAPI interface {
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+// enqueuer 宛先の配信状態を未送信で作成し、メール送信キューに登録する
+type enqueuer struct {
+	campaigns  *campaign.Service
+	sqsClient  SQSAPI
+	queueURL   string
+	campaignID string
+	bucketName string
+	fileName   string
+	// versionID 検証したメール本文ファイルのバージョン(バージョニングが無効な場合は空)
+	versionID string
+	// list メール本文ファイルの配信リスト
+	list string
+}
+
+// enqueue 最大batchSize件の宛先の配信状態を未送信で作成してキューに登録し、登録した宛先のメールアドレスを返す。
+// 配信リストの配信を停止した宛先と、前回の実行で送信済みになった宛先は登録しない
+func (e *enqueuer) enqueue(ctx context.Context, addresses []MailAddress) ([]string, error) {
+	recipients := make([]campaign.Recipient, 0, len(addresses))
+	for _, address := range addresses {
+		// チェックポイントのページ内の位置がずれないよう、Queryの条件ではなく取得後に除く
+		if slices.Contains(address.UnsubscribedLists, e.list) {
+			log.Printf("配信停止した宛先のため登録しません: email=%s, list=%s", address.Email, e.list)
+			continue
+		}
+		recipients = append(recipients, campaign.Recipient{Email: address.Email, UserName: address.UserName})
+	}
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+	queue, err := e.campaigns.Queue(ctx, e.campaignID, recipients)
+	if err != nil {
+		return nil, err
+	}
+	if len(queue) == 0 {
+		return nil, nil
+	}
+	return e.send(ctx, queue)
+}
+
+// send SendMessageBatchでキューに登録し、失敗したエントリのうち再試行できるものだけを再送信する。
+// 送信者側の誤りで失敗したエントリは再試行しても成功しないため、ログに出力して除外する。
+// 登録した宛先のメールアドレスを返す(失敗した場合も途中までに登録した宛先を返す)
+func (e *enqueuer) send(ctx context.Context, addresses []campaign.Recipient) ([]string, error) {
+	// エントリIDはバッチ内の位置
+	pending := make(map[string]campaign.Recipient, len(addresses))
+	for i, address := range addresses {
+		pending[strconv.Itoa(i)] = address
+	}
+
+	var queued []string
+	interval := retryInterval
+	for attempt := 1; ; attempt++ {
+		entries := make([]sqsTypes.SendMessageBatchRequestEntry, 0, len(pending))
+		for i := range addresses {
+			id := strconv.Itoa(i)
+			if address, ok := pending[id]; ok {
+				entries = append(entries, e.newEntry(id, address))
+			}
+		}
+
+		result, err := e.sqsClient.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(e.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return queued, fmt.Errorf("failed to send SQS message batch: %w", err)
+		}
+
+		for _, entry := range result.Successful {
+			queued = append(queued, pending[aws.ToString(entry.Id)].Email)
+			delete(pending, aws.ToString(entry.Id))
+		}
+		for _, entry := range result.Failed {
+			if entry.SenderFault {
+				email := pending[aws.ToString(entry.Id)].Email
+				log.Printf("キューへの登録に失敗したため除外します: email=%s, code=%s, message=%s",
+					email, aws.ToString(entry.Code), aws.ToString(entry.Message))
+				delete(pending, aws.ToString(entry.Id))
+				if _, err := e.campaigns.Transition(ctx, e.campaignID, email, campaign.StatusSkipped); err != nil {
+					return queued, err
+				}
+			}
+		}
+
+		if len(pending) == 0 {
+			return queued, nil
+		}
+		if attempt == maxSendAttempts {
+			return queued, fmt.Errorf("failed to send %d SQS messages after %d attempts", len(pending), attempt)
+		}
+		log.Printf("キューへの登録に失敗したエントリを再送信します: count=%d, attempt=%d", len(pending), attempt)
+		select {
+		case <-ctx.Done():
+			return queued, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+// newEntry 宛先のメール送信メッセージを生成する
+func (e *enqueuer) newEntry(id string, address campaign.Recipient) sqsTypes.SendMessageBatchRequestEntry {
+	entry := sqsTypes.SendMessageBatchRequestEntry{
+		Id:          aws.String(id),
+		MessageBody: aws.String(address.Email),
+		MessageAttributes: map[string]sqsTypes.MessageAttributeValue{
+			"campaign_id": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(e.campaignID),
+			},
+			"bucket_name": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(e.bucketName),
+			},
+			"file_name": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(e.fileName),
+			},
+		},
+	}
+	// メッセージ属性は空文字にできないため、ユーザー名が登録されていない場合は設定しない
+	if address.UserName != "" {
+		entry.MessageAttributes["user_name"] = sqsTypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(address.UserName),
+		}
+	}
+	// 配信中にメール本文ファイルが再アップロードされても検証したバージョンを送信するよう、バージョンを渡す。
+	// バージョニングが無効な場合は設定しない
+	if e.versionID != "" {
+		entry.MessageAttributes["version_id"] = sqsTypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(e.versionID),
+		}
+	}
+	return entry
+}
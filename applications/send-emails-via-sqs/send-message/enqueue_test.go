@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/campaign"
+)
+
+// fakeSQS 指定したメールアドレスのエントリを失敗させるSQSクライアント
+type fakeSQS struct {
+	// transient 最初の1回だけ再試行可能なエラーで失敗させるメールアドレス
+	transient map[string]bool
+	// senderFault 毎回送信者側の誤りで失敗させるメールアドレス
+	senderFault map[string]bool
+	// batches SendMessageBatchで送信されたメールアドレス(呼び出しごと)
+	batches [][]string
+	queued  []string
+	// attributes 最後に送信したメッセージのメッセージ属性
+	attributes map[string]sqsTypes.MessageAttributeValue
+}
+
+func (f *fakeSQS) SendMessageBatch(_ context.Context, params *sqs.SendMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	out := &sqs.SendMessageBatchOutput{}
+	var emails []string
+	for _, entry := range params.Entries {
+		email := aws.ToString(entry.MessageBody)
+		emails = append(emails, email)
+		f.attributes = entry.MessageAttributes
+		switch {
+		case f.senderFault[email]:
+			out.Failed = append(out.Failed, sqsTypes.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InvalidParameterValue"), SenderFault: true})
+		case f.transient[email]:
+			delete(f.transient, email)
+			out.Failed = append(out.Failed, sqsTypes.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError")})
+		default:
+			f.queued = append(f.queued, email)
+			out.Successful = append(out.Successful, sqsTypes.SendMessageBatchResultEntry{Id: entry.Id})
+		}
+	}
+	f.batches = append(f.batches, emails)
+	return out, nil
+}
+
+// newTestEnqueuer 配信を作成し、その配信の宛先を登録するenqueuerを生成する
+func newTestEnqueuer(t *testing.T, sqsClient SQSAPI) (*enqueuer, *campaign.Service) {
+	t.Helper()
+	retryInterval = 0
+	campaigns := campaign.NewService(campaign.NewMemoryStore())
+	c, err := campaigns.Create(context.Background(), "bucket", "mail.txt", "v1")
+	if err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+	e := &enqueuer{campaigns: campaigns, sqsClient: sqsClient, queueURL: "queue", campaignID: c.ID, bucketName: "bucket", fileName: "mail.txt", versionID: "v1", list: "news"}
+	return e, campaigns
+}
+
+// assertTotals 配信状態ごとの件数を検証する
+func assertTotals(t *testing.T, campaigns *campaign.Service, campaignID string, want map[campaign.Status]int64) {
+	t.Helper()
+	c, err := campaigns.Get(context.Background(), campaignID)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	for _, status := range campaign.Statuses {
+		if c.Totals[status] != want[status] {
+			t.Errorf("Totals[%s] = %d, want %d", status, c.Totals[status], want[status])
+		}
+	}
+}
+
+func TestEnqueueRetriesOnlyFailedEntries(t *testing.T) {
+	sqsClient := &fakeSQS{
+		transient:   map[string]bool{"b@example.com": true, "d@example.com": true},
+		senderFault: map[string]bool{"c@example.com": true},
+	}
+	e, campaigns := newTestEnqueuer(t, sqsClient)
+
+	addresses := []MailAddress{{Email: "a@example.com"}, {Email: "b@example.com"}, {Email: "c@example.com"}, {Email: "d@example.com"}}
+	queued, err := e.enqueue(context.Background(), addresses)
+	if err != nil {
+		t.Fatalf("enqueue() returned an error: %v", err)
+	}
+	if want := []string{"a@example.com", "b@example.com", "d@example.com"}; !reflect.DeepEqual(queued, want) {
+		t.Errorf("queued = %v, want %v", queued, want)
+	}
+
+	// 再試行可能なエラーで失敗したエントリだけを再送信すること
+	want := [][]string{
+		{"a@example.com", "b@example.com", "c@example.com", "d@example.com"},
+		{"b@example.com", "d@example.com"},
+	}
+	if !reflect.DeepEqual(sqsClient.batches, want) {
+		t.Errorf("batches = %v, want %v", sqsClient.batches, want)
+	}
+	// 登録できなかった宛先は除外として数えること
+	assertTotals(t, campaigns, e.campaignID, map[campaign.Status]int64{campaign.StatusQueued: 3, campaign.StatusSkipped: 1})
+	for _, attr := range []string{"campaign_id", "bucket_name", "file_name", "version_id"} {
+		if _, ok := sqsClient.attributes[attr]; !ok {
+			t.Errorf("message attribute %s is missing", attr)
+		}
+	}
+}
+
+func TestNewEntryOmitsEmptyAttributes(t *testing.T) {
+	e, _ := newTestEnqueuer(t, &fakeSQS{})
+
+	entry := e.newEntry("0", campaign.Recipient{Email: "a@example.com", UserName: "Alice"})
+	if got := aws.ToString(entry.MessageAttributes["user_name"].StringValue); got != "Alice" {
+		t.Errorf("user_name = %q, want %q", got, "Alice")
+	}
+
+	// SQSは空文字のメッセージ属性を受け付けないため設定しないこと
+	e.versionID = ""
+	entry = e.newEntry("0", campaign.Recipient{Email: "b@example.com"})
+	for _, attr := range []string{"user_name", "version_id"} {
+		if _, ok := entry.MessageAttributes[attr]; ok {
+			t.Errorf("message attribute %s is set for an empty value", attr)
+		}
+	}
+}
+
+func TestEnqueueSkipsSentRecipients(t *testing.T) {
+	sqsClient := &fakeSQS{}
+	e, campaigns := newTestEnqueuer(t, sqsClient)
+	ctx := context.Background()
+	addresses := []MailAddress{{Email: "a@example.com"}, {Email: "b@example.com"}}
+
+	if _, err := e.enqueue(ctx, addresses); err != nil {
+		t.Fatalf("enqueue() returned an error: %v", err)
+	}
+	d, err := campaigns.Claim(ctx, e.campaignID, "a@example.com", time.Minute)
+	if err != nil {
+		t.Fatalf("Claim() returned an error: %v", err)
+	}
+	if err := campaigns.Complete(ctx, d, "message-1"); err != nil {
+		t.Fatalf("Complete() returned an error: %v", err)
+	}
+
+	// 再実行時は送信済みの宛先を登録せず、未送信のままの宛先だけを登録し直すこと
+	queued, err := e.enqueue(ctx, addresses)
+	if err != nil {
+		t.Fatalf("enqueue() returned an error: %v", err)
+	}
+	if len(queued) != 1 || !reflect.DeepEqual(sqsClient.batches[1], []string{"b@example.com"}) {
+		t.Errorf("enqueue() = %v (%v), want only b@example.com", queued, sqsClient.batches[1])
+	}
+	assertTotals(t, campaigns, e.campaignID, map[campaign.Status]int64{campaign.StatusQueued: 1, campaign.StatusSent: 1})
+}
+
+func TestEnqueueSkipsUnsubscribedRecipients(t *testing.T) {
+	sqsClient := &fakeSQS{}
+	e, campaigns := newTestEnqueuer(t, sqsClient)
+	addresses := []MailAddress{
+		{Email: "a@example.com", UnsubscribedLists: []string{"news"}},
+		{Email: "b@example.com", UnsubscribedLists: []string{"other"}},
+		{Email: "c@example.com"},
+	}
+
+	// 配信リストの配信を停止した宛先だけを登録しないこと
+	queued, err := e.enqueue(context.Background(), addresses)
+	if err != nil {
+		t.Fatalf("enqueue() returned an error: %v", err)
+	}
+	if len(queued) != 2 || !reflect.DeepEqual(sqsClient.batches, [][]string{{"b@example.com", "c@example.com"}}) {
+		t.Errorf("enqueue() = %v (%v), want b@example.com and c@example.com", queued, sqsClient.batches)
+	}
+	assertTotals(t, campaigns, e.campaignID, map[campaign.Status]int64{campaign.StatusQueued: 2})
+}
+
+func TestEnqueueGivesUpAfterMaxAttempts(t *testing.T) {
+	sqsClient := &fakeSQS{transient: map[string]bool{}}
+	e, _ := newTestEnqueuer(t, &alwaysFailingSQS{sqsClient})
+
+	_, err := e.enqueue(context.Background(), []MailAddress{{Email: "a@example.com"}})
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("enqueue() error = %v, want failure after %d attempts", err, maxSendAttempts)
+	}
+	if len(sqsClient.batches) != maxSendAttempts {
+		t.Errorf("SendMessageBatch called %d times, want %d", len(sqsClient.batches), maxSendAttempts)
+	}
+}
+
+// alwaysFailingSQS 全てのエントリを毎回再試行可能なエラーで失敗させるSQSクライアント
+type alwaysFailingSQS struct {
+	*fakeSQS
+}
+
+func (f *alwaysFailingSQS) SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	for _, entry := range params.Entries {
+		f.transient[aws.ToString(entry.MessageBody)] = true
+	}
+	return f.fakeSQS.SendMessageBatch(ctx, params, optFns...)
+}
@@ -2,19 +2,24 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	lambdaService "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/campaign"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/mail"
 )
 
 var env string
@@ -23,7 +28,55 @@ type MailAddress struct {
 	Email    string `json:"email"`
 	UserName string `json:"user_name"`
 	HasError int    `json:"has_error"`
-	IsSent   int    `json:"is_sent"`
+	// UnsubscribedLists 配信を停止した配信リスト
+	UnsubscribedLists []string `json:"unsubscribed_lists"`
+
+	// key has_error-indexでの宛先の位置を表すキー。処理済みの位置としてチェックポイントに保存する
+	key map[string]types.AttributeValue
+}
+
+// objectVersion S3イベントから配信を区別するためのオブジェクトのバージョンを取得する。
+// バージョニングが無効な場合は同じキーへの再アップロードを区別するためにシーケンサーを使用する
+func objectVersion(record events.S3EventRecord) string {
+	if record.S3.Object.VersionID != "" {
+		return record.S3.Object.VersionID
+	}
+	return record.S3.Object.Sequencer
+}
+
+// validateTemplate S3に置かれたメール本文ファイルを取得し、テンプレートとして解析・検証する。
+// 添付ファイルのオブジェクトが存在することも確認する
+func validateTemplate(ctx context.Context, s3Client *s3.Client, bucketName, fileName, versionID string) (*mail.Template, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(fileName),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	result, err := s3Client.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mail body: %w", err)
+	}
+	defer func() {
+		if err := result.Body.Close(); err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mail body: %w", err)
+	}
+
+	tmpl, err := mail.ParseTemplate(string(data))
+	if err != nil {
+		return nil, err
+	}
+	// 添付ファイルがない場合は全ての宛先の送信が失敗し続けるため、キューに登録する前に配信を中止する
+	if err := mail.CheckAttachments(ctx, s3Client, bucketName, tmpl.Attachments()); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
 }
 
 func handler(ctx context.Context, event events.S3Event) error {
@@ -36,6 +89,13 @@ func handler(ctx context.Context, event events.S3Event) error {
 	// ①DynamoDBのmail-addressesテーブルを操作するオブジェクト
 	dynamoClient := dynamodb.NewFromConfig(cfg)
 	tableName := fmt.Sprintf("my-modern-application-sample-%s-mail-addresses", env)
+	checkpoints := NewDynamoDBCheckpointStore(dynamoClient, fmt.Sprintf("my-modern-application-sample-%s-send-message-checkpoints", env))
+	campaigns := campaign.NewService(campaign.NewDynamoDBStore(dynamoClient,
+		fmt.Sprintf("my-modern-application-sample-%s-campaigns", env),
+		fmt.Sprintf("my-modern-application-sample-%s-campaign-deliveries", env)))
+
+	// メール本文ファイルを取得するオブジェクト
+	s3Client := s3.NewFromConfig(cfg)
 
 	// ②SQSのキューを操作するオブジェクト
 	sqsClient := sqs.NewFromConfig(cfg)
@@ -50,97 +110,71 @@ func handler(ctx context.Context, event events.S3Event) error {
 	}
 	queueURL := queueResult.QueueUrl
 
-	for _, record := range event.Records {
+	// 中断した配信の続きを処理するために自身を呼び出すオブジェクト
+	lambdaClient := lambdaService.NewFromConfig(cfg)
+
+	for i, record := range event.Records {
 		// ③S3に置かれたファイルパスを取得
 		bucketName := record.S3.Bucket.Name
 		fileName := record.S3.Object.Key
 
-		// ④has_errorが0のものをmail-addressesテーブルから取得
-		queryInput := &dynamodb.QueryInput{
-			TableName:              aws.String(tableName),
-			IndexName:              aws.String("has_error-index"),
-			KeyConditionExpression: aws.String("has_error = :has_error"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":has_error": &types.AttributeValueMemberN{
-					Value: "0",
-				},
-			},
-		}
-
-		result, err := dynamoClient.Query(ctx, queryInput)
+		// 配信を作成する(同じファイルのイベントが再度届いた場合は既存の配信)
+		c, err := campaigns.Create(ctx, bucketName, fileName, objectVersion(record))
 		if err != nil {
-			return fmt.Errorf("failed to query DynamoDB: %v", err)
+			return fmt.Errorf("failed to create campaign: %v", err)
+		}
+		if c.Error != "" {
+			log.Printf("中止した配信のためスキップ: campaign_id=%s, error=%s", c.ID, c.Error)
+			continue
 		}
 
-		// ⑤上記の1件1件についてループ処理
-		for _, item := range result.Items {
-			email := ""
-			userName := ""
-
-			// emailの値を取得
-			if emailAttr, ok := item["email"]; ok {
-				if emailVal, ok := emailAttr.(*types.AttributeValueMemberS); ok {
-					email = emailVal.Value
-				}
-			}
-
-			// user_nameの値を取得
-			if userNameAttr, ok := item["user_name"]; ok {
-				if userNameVal, ok := userNameAttr.(*types.AttributeValueMemberS); ok {
-					userName = userNameVal.Value
-				}
-			}
-
-			// ⑥送信済みを示すis_sentを0にする
-			updateInput := &dynamodb.UpdateItemInput{
-				TableName: aws.String(tableName),
-				Key: map[string]types.AttributeValue{
-					"email": &types.AttributeValueMemberS{
-						Value: email,
-					},
-				},
-				UpdateExpression: aws.String("set is_sent = :val"),
-				ExpressionAttributeValues: map[string]types.AttributeValue{
-					":val": &types.AttributeValueMemberN{
-						Value: "0",
-					},
-				},
-			}
-
-			_, err := dynamoClient.UpdateItem(ctx, updateInput)
-			if err != nil {
-				return fmt.Errorf("failed to update DynamoDB item: %v", err)
-			}
-
-			// ⑦SQSにメッセージとして登録する
-			sendMessageInput := &sqs.SendMessageInput{
-				QueueUrl:    queueURL,
-				MessageBody: aws.String(email),
-				MessageAttributes: map[string]sqsTypes.MessageAttributeValue{
-					"user_name": {
-						DataType:    aws.String("String"),
-						StringValue: aws.String(userName),
-					},
-					"bucket_name": {
-						DataType:    aws.String("String"),
-						StringValue: aws.String(bucketName),
-					},
-					"file_name": {
-						DataType:    aws.String("String"),
-						StringValue: aws.String(fileName),
-					},
-				},
+		// テンプレートが不正な場合は、宛先を登録する前に配信を中止する
+		tmpl, err := validateTemplate(ctx, s3Client, bucketName, fileName, record.S3.Object.VersionID)
+		if errors.Is(err, mail.ErrInvalidTemplate) {
+			if err := campaigns.Fail(ctx, c.ID, err.Error()); err != nil {
+				return err
 			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-			sqsResponse, err := sqsClient.SendMessage(ctx, sendMessageInput)
-			if err != nil {
-				return fmt.Errorf("failed to send SQS message: %v", err)
-			}
+		// 前回の実行がタイムアウトした場合は、保存した進捗から再開する
+		cp, err := checkpoints.Get(ctx, c.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get checkpoint: %v", err)
+		}
+		if cp.Completed {
+			log.Printf("登録済みの配信のためスキップ: campaign_id=%s, queued=%d", cp.CampaignID, cp.Queued)
+			continue
+		}
+		if cp.LastKey != nil || len(cp.Enqueued) > 0 {
+			log.Printf("前回の進捗から再開します: campaign_id=%s, queued=%d", cp.CampaignID, cp.Queued)
+		}
 
-			// 結果をログに出力しておく
-			responseJSON, _ := json.Marshal(sqsResponse)
-			log.Printf("SQS Response: %s", string(responseJSON))
+		// ④has_errorが0のものをmail-addressesテーブルからページごとに取得し、⑤10件ずつ処理
+		// ⑥配信リストの配信を停止した宛先を除いて配信ごとの配信状態を未送信で作成し、⑦SQSにまとめてメッセージとして登録する
+		e := &enqueuer{
+			campaigns:  campaigns,
+			sqsClient:  sqsClient,
+			queueURL:   aws.ToString(queueURL),
+			campaignID: c.ID,
+			bucketName: bucketName,
+			fileName:   fileName,
+			versionID:  record.S3.Object.VersionID,
+			list:       tmpl.List(),
+		}
+		err = forEachRecipientBatch(ctx, dynamoClient, tableName, checkpoints, cp, e.enqueue)
+		if errors.Is(err, errInterrupted) {
+			// 自身を非同期に呼び出し、この配信と未処理のレコードを続きから処理させる
+			log.Printf("実行期限が近いため中断し、続きを新しい実行で処理します: campaign_id=%s, queued=%d", cp.CampaignID, cp.Queued)
+			return continueAsync(ctx, lambdaClient, lambdacontext.FunctionName, event.Records[i:])
+		}
+		if err != nil {
+			return err
 		}
+		log.Printf("配信の登録完了: campaign_id=%s, queued=%d", cp.CampaignID, cp.Queued)
 	}
 
 	return nil
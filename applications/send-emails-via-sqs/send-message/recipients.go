@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// pageSize has_error-indexを1回のQueryで取得する件数
+	pageSize = 100
+	// deadlineMargin Lambdaの実行期限のこの時間前になったら、チェックポイントを保存して中断する
+	deadlineMargin = 10 * time.Second
+)
+
+// errInterrupted Lambdaの実行期限が近いため処理を中断した
+var errInterrupted = errors.New("interrupted before the Lambda timeout")
+
+// DynamoDBAPI send-messageが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// recipientKeyAttributes has_error-indexでの位置を表す属性(インデックスのキーとテーブルのキー)
+var recipientKeyAttributes = []string{"has_error", "email"}
+
+// queryRecipients has_errorが0のメールアドレスをstartKeyから1ページ取得する。最後のページの場合、次のページのキーはnil
+func queryRecipients(ctx context.Context, client DynamoDBAPI, tableName string, startKey map[string]types.AttributeValue) ([]MailAddress, map[string]types.AttributeValue, error) {
+	result, err := client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String("has_error-index"),
+		KeyConditionExpression: aws.String("has_error = :has_error"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":has_error": &types.AttributeValueMemberN{
+				Value: "0",
+			},
+		},
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(pageSize),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query DynamoDB: %w", err)
+	}
+
+	addresses := make([]MailAddress, 0, len(result.Items))
+	for _, item := range result.Items {
+		var address MailAddress
+		// emailの値を取得
+		if emailVal, ok := item["email"].(*types.AttributeValueMemberS); ok {
+			address.Email = emailVal.Value
+		}
+		// user_nameの値を取得
+		if userNameVal, ok := item["user_name"].(*types.AttributeValueMemberS); ok {
+			address.UserName = userNameVal.Value
+		}
+		// unsubscribed_listsの値を取得
+		if listsVal, ok := item["unsubscribed_lists"].(*types.AttributeValueMemberSS); ok {
+			address.UnsubscribedLists = listsVal.Value
+		}
+		address.key = make(map[string]types.AttributeValue, len(recipientKeyAttributes))
+		for _, name := range recipientKeyAttributes {
+			address.key[name] = item[name]
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, result.LastEvaluatedKey, nil
+}
+
+// forEachRecipientBatch チェックポイントの位置から全てのページの宛先を最大batchSize件ずつfnで処理し、進捗をチェックポイントに保存する。
+// fnは登録した宛先のメールアドレスを返す(失敗した場合も途中までに登録した宛先を返す)。
+// fnが失敗した場合やLambdaの実行期限が近い場合は、処理済みの位置を保存してエラーを返す。
+// 再開時は最後に処理した宛先のキーから取得し直すため、その間に宛先がインデックスから外れても未処理の宛先を読み飛ばさない
+func forEachRecipientBatch(ctx context.Context, client DynamoDBAPI, tableName string, store CheckpointStore, cp *Checkpoint, fn func(ctx context.Context, addresses []MailAddress) ([]string, error)) error {
+	// 中断時はLambdaの実行期限を過ぎてもチェックポイントを保存する
+	save := func() error {
+		if err := store.Save(context.WithoutCancel(ctx), cp); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		return nil
+	}
+
+	for {
+		addresses, nextKey, err := queryRecipients(ctx, client, tableName, cp.LastKey)
+		if err != nil {
+			return err
+		}
+
+		for start := 0; start < len(addresses); start += batchSize {
+			if nearDeadline(ctx) {
+				log.Printf("Lambdaの実行期限が近いため中断します: campaign_id=%s, queued=%d", cp.CampaignID, cp.Queued)
+				if err := save(); err != nil {
+					return err
+				}
+				return errInterrupted
+			}
+			batch := addresses[start:min(start+batchSize, len(addresses))]
+			enqueued, err := fn(ctx, excludeEnqueued(batch, cp.Enqueued))
+			cp.Queued += int64(len(enqueued))
+			if err != nil {
+				// 失敗したバッチは再開時に登録済みの宛先を除いて処理し直す
+				cp.Enqueued = append(cp.Enqueued, enqueued...)
+				if saveErr := save(); saveErr != nil {
+					log.Printf("チェックポイントの保存に失敗しました: campaign_id=%s, error=%v", cp.CampaignID, saveErr)
+				}
+				return err
+			}
+			cp.LastKey = batch[len(batch)-1].key
+			cp.Enqueued = nil
+		}
+
+		// ページを処理し終えたら進捗を保存する
+		cp.Completed = nextKey == nil
+		if err := save(); err != nil {
+			return err
+		}
+		if cp.Completed {
+			return nil
+		}
+	}
+}
+
+// excludeEnqueued 前回の実行で登録済みの宛先を除く
+func excludeEnqueued(addresses []MailAddress, enqueued []string) []MailAddress {
+	if len(enqueued) == 0 {
+		return addresses
+	}
+	var pending []MailAddress
+	for _, address := range addresses {
+		if !slices.Contains(enqueued, address.Email) {
+			pending = append(pending, address)
+		}
+	}
+	return pending
+}
+
+// nearDeadline Lambdaの実行期限が近いかどうかを返す
+func nearDeadline(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < deadlineMargin
+}
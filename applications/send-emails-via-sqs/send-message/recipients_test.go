@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeDynamoDB mail-addressesテーブルのhas_error-index(メールアドレス順)とチェックポイントテーブルを模したクライアント
+type fakeDynamoDB struct {
+	emails      []string
+	checkpoints map[string]map[string]types.AttributeValue
+	queries     int
+}
+
+func newFakeDynamoDB(n int) *fakeDynamoDB {
+	f := &fakeDynamoDB{checkpoints: make(map[string]map[string]types.AttributeValue)}
+	for i := range n {
+		f.emails = append(f.emails, fmt.Sprintf("user%04d@example.com", i))
+	}
+	return f
+}
+
+func (f *fakeDynamoDB) Query(_ context.Context, params *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.queries++
+	start := 0
+	if params.ExclusiveStartKey != nil {
+		last := params.ExclusiveStartKey["email"].(*types.AttributeValueMemberS).Value
+		for start < len(f.emails) && f.emails[start] <= last {
+			start++
+		}
+	}
+	end := min(start+int(aws.ToInt32(params.Limit)), len(f.emails))
+
+	out := &dynamodb.QueryOutput{}
+	for _, email := range f.emails[start:end] {
+		out.Items = append(out.Items, map[string]types.AttributeValue{
+			"email":     &types.AttributeValueMemberS{Value: email},
+			"user_name": &types.AttributeValueMemberS{Value: "name-" + email},
+			"has_error": &types.AttributeValueMemberN{Value: "0"},
+		})
+	}
+	if end < len(f.emails) {
+		out.LastEvaluatedKey = map[string]types.AttributeValue{
+			"email":     &types.AttributeValueMemberS{Value: f.emails[end-1]},
+			"has_error": &types.AttributeValueMemberN{Value: "0"},
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDynamoDB) GetItem(_ context.Context, params *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	id := params.Key["campaign_id"].(*types.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: f.checkpoints[id]}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(_ context.Context, params *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	id := params.Item["campaign_id"].(*types.AttributeValueMemberS).Value
+	f.checkpoints[id] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestForEachRecipientPaginates(t *testing.T) {
+	client := newFakeDynamoDB(2*pageSize + 5)
+	store := NewDynamoDBCheckpointStore(client, "checkpoints")
+	cp := &Checkpoint{CampaignID: "bucket/mail.txt/v1"}
+
+	seen := make(map[string]int)
+	err := forEachRecipientBatch(context.Background(), client, "mail-addresses", store, cp, func(_ context.Context, addresses []MailAddress) ([]string, error) {
+		if len(addresses) > batchSize {
+			t.Errorf("batch size = %d, want <= %d", len(addresses), batchSize)
+		}
+		return emailsOf(addresses, seen), nil
+	})
+	if err != nil {
+		t.Fatalf("forEachRecipientBatch() returned an error: %v", err)
+	}
+
+	// 1ページ目以降の宛先も漏れなく処理すること
+	if len(seen) != len(client.emails) || cp.Queued != int64(len(client.emails)) {
+		t.Errorf("processed %d recipients (queued=%d), want %d", len(seen), cp.Queued, len(client.emails))
+	}
+	if client.queries != 3 {
+		t.Errorf("queries = %d, want %d", client.queries, 3)
+	}
+
+	saved, err := store.Get(context.Background(), cp.CampaignID)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if !saved.Completed || saved.Queued != int64(len(client.emails)) {
+		t.Errorf("checkpoint = %+v, want completed", saved)
+	}
+}
+
+func TestForEachRecipientResumes(t *testing.T) {
+	client := newFakeDynamoDB(2*pageSize + 5)
+	store := NewDynamoDBCheckpointStore(client, "checkpoints")
+	campaign := "bucket/mail.txt/v1"
+	failAt := client.emails[pageSize+12]
+	wantErr := errors.New("sqs unavailable")
+
+	seen := make(map[string]int)
+	process := func(_ context.Context, addresses []MailAddress) ([]string, error) {
+		for i, address := range addresses {
+			if address.Email == failAt {
+				// 失敗した宛先より前の宛先は登録済みとする
+				return emailsOf(addresses[:i], seen), wantErr
+			}
+		}
+		return emailsOf(addresses, seen), nil
+	}
+
+	// 2ページ目の2つ目のバッチの途中で失敗させる
+	cp, err := store.Get(context.Background(), campaign)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if err := forEachRecipientBatch(context.Background(), client, "mail-addresses", store, cp, process); !errors.Is(err, wantErr) {
+		t.Fatalf("forEachRecipientBatch() error = %v, want %v", err, wantErr)
+	}
+
+	// 保存したチェックポイントから再開し、失敗したバッチの未登録の宛先以降だけを処理すること
+	failAt = ""
+	cp, err = store.Get(context.Background(), campaign)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if cp.Completed || cp.Queued != pageSize+12 || len(cp.Enqueued) != 2 {
+		t.Fatalf("checkpoint = %+v, want queued %d with 2 enqueued in the failed batch", cp, pageSize+12)
+	}
+	if err := forEachRecipientBatch(context.Background(), client, "mail-addresses", store, cp, process); err != nil {
+		t.Fatalf("forEachRecipientBatch() returned an error: %v", err)
+	}
+
+	if len(seen) != len(client.emails) {
+		t.Errorf("processed %d recipients, want %d", len(seen), len(client.emails))
+	}
+	for email, n := range seen {
+		if n != 1 {
+			t.Errorf("%s processed %d times, want 1", email, n)
+		}
+	}
+	if cp.Queued != int64(len(client.emails)) || !cp.Completed {
+		t.Errorf("checkpoint = %+v, want completed with %d queued", cp, len(client.emails))
+	}
+}
+
+func TestForEachRecipientResumesAfterRecipientsLeaveIndex(t *testing.T) {
+	client := newFakeDynamoDB(2*pageSize + 5)
+	store := NewDynamoDBCheckpointStore(client, "checkpoints")
+	campaign := "bucket/mail.txt/v1"
+	failAt := client.emails[pageSize+20]
+	wantErr := errors.New("sqs unavailable")
+
+	seen := make(map[string]int)
+	process := func(_ context.Context, addresses []MailAddress) ([]string, error) {
+		for i, address := range addresses {
+			if address.Email == failAt {
+				return emailsOf(addresses[:i], seen), wantErr
+			}
+		}
+		return emailsOf(addresses, seen), nil
+	}
+
+	cp, err := store.Get(context.Background(), campaign)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if err := forEachRecipientBatch(context.Background(), client, "mail-addresses", store, cp, process); !errors.Is(err, wantErr) {
+		t.Fatalf("forEachRecipientBatch() error = %v, want %v", err, wantErr)
+	}
+
+	// 再開までに処理済みの宛先がバウンス等でhas_error-indexから外れても、未処理の宛先を読み飛ばさないこと
+	removed := client.emails[pageSize+2 : pageSize+7]
+	for _, email := range removed {
+		delete(seen, email)
+	}
+	client.emails = append(client.emails[:pageSize+2:pageSize+2], client.emails[pageSize+7:]...)
+
+	failAt = ""
+	cp, err = store.Get(context.Background(), campaign)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if err := forEachRecipientBatch(context.Background(), client, "mail-addresses", store, cp, process); err != nil {
+		t.Fatalf("forEachRecipientBatch() returned an error: %v", err)
+	}
+
+	if len(seen) != len(client.emails) {
+		t.Errorf("processed %d recipients, want %d", len(seen), len(client.emails))
+	}
+	for _, email := range client.emails {
+		if seen[email] != 1 {
+			t.Errorf("%s processed %d times, want 1", email, seen[email])
+		}
+	}
+}
+
+func TestForEachRecipientInterruptsNearDeadline(t *testing.T) {
+	client := newFakeDynamoDB(5)
+	store := NewDynamoDBCheckpointStore(client, "checkpoints")
+	cp := &Checkpoint{CampaignID: "bucket/mail.txt/v1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadlineMargin/2)
+	defer cancel()
+	err := forEachRecipientBatch(ctx, client, "mail-addresses", store, cp, func(context.Context, []MailAddress) ([]string, error) {
+		t.Error("recipients processed after the deadline margin")
+		return nil, nil
+	})
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("forEachRecipientBatch() error = %v, want %v", err, errInterrupted)
+	}
+	// 中断した位置を保存すること
+	if _, ok := client.checkpoints[cp.CampaignID]; !ok {
+		t.Error("checkpoint was not saved")
+	}
+}
+
+// emailsOf 宛先のメールアドレスを返し、処理した回数を数える
+func emailsOf(addresses []MailAddress, seen map[string]int) []string {
+	emails := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		seen[address.Email]++
+		emails = append(emails, address.Email)
+	}
+	return emails
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/mail"
+)
+
+func TestExampleMailBodies(t *testing.T) {
+	// メール本文ファイルの例がテンプレートの検証を通ること
+	for _, name := range []string{"mail-body-example.txt", "mail-body-template-example.txt", "mail-body-structured-example.txt"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile() returned an error: %v", err)
+		}
+		if _, err := mail.ParseTemplate(string(data)); err != nil {
+			t.Errorf("ParseTemplate(%s) returned an error: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/unsubscribe"
+)
+
+func main() {
+	// 環境変数の読み込み
+	env := os.Getenv("ENV")
+	if env == "" {
+		log.Fatalf("Environment variable ENV is required")
+	}
+
+	// 配信停止のトークンの署名の鍵(read-message-and-send-mailと同じ値)
+	secret := os.Getenv("UNSUBSCRIBE_SECRET")
+	if secret == "" {
+		log.Fatalf("Environment variable UNSUBSCRIBE_SECRET is required")
+	}
+
+	// AWS設定の初期化
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatalf("AWS設定の読み込みに失敗しました: %v", err)
+	}
+
+	u := &unsubscriber{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: fmt.Sprintf("my-modern-application-sample-%s-mail-addresses", env),
+		signer:    unsubscribe.NewSigner([]byte(secret)),
+		now:       time.Now,
+	}
+	lambda.Start(u.handle)
+}
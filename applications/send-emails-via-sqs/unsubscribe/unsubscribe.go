@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/unsubscribe"
+)
+
+// DynamoDBAPI unsubscribeが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// pageTemplate 配信停止の確認・完了・エラーの画面
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head><meta charset="UTF-8"><title>配信停止</title></head>
+<body>
+{{if .Confirm}}<p>{{.Email}}への配信({{.List}})を停止しますか。</p>
+<form method="post">
+<input type="hidden" name="List-Unsubscribe" value="One-Click">
+<button type="submit">配信を停止する</button>
+</form>
+{{else}}<p>{{.Message}}</p>
+{{end}}</body>
+</html>
+`))
+
+// page 画面に表示する内容
+type page struct {
+	Confirm bool
+	Email   string
+	List    string
+	Message string
+}
+
+// unsubscriber 配信停止のリンク(List-Unsubscribeヘッダー)のリクエストを処理する
+type unsubscriber struct {
+	client    DynamoDBAPI
+	tableName string
+	signer    *unsubscribe.Signer
+	now       func() time.Time
+}
+
+// handle 配信停止のリクエストを処理する。
+//   - GET: トークンを検証して確認画面を表示する(メールのリンクの事前読み込みで配信停止しないよう、GETでは更新しない)
+//   - POST: トークンを検証して配信を停止する(RFC 8058のワンクリックの配信停止と確認画面のフォーム)
+func (u *unsubscriber) handle(ctx context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	method := request.RequestContext.HTTP.Method
+	if method != http.MethodGet && method != http.MethodPost {
+		return response(http.StatusMethodNotAllowed, page{Message: "許可されていないメソッドです"}), nil
+	}
+
+	token := request.QueryStringParameters["token"]
+	if method == http.MethodPost && token == "" {
+		// フォームの本文にトークンがある場合も受け付ける
+		form, err := parseForm(request)
+		if err != nil {
+			log.Printf("フォームのパースに失敗しました: %v", err)
+			return response(http.StatusBadRequest, page{Message: "リクエストが不正です"}), nil
+		}
+		token = form.Get("token")
+	}
+
+	claims, err := u.signer.Verify(token)
+	if errors.Is(err, unsubscribe.ErrExpiredToken) {
+		return response(http.StatusBadRequest, page{Message: "配信停止のリンクの有効期限が切れています"}), nil
+	}
+	if err != nil {
+		log.Printf("トークンの検証に失敗しました: %v", err)
+		return response(http.StatusBadRequest, page{Message: "配信停止のリンクが不正です"}), nil
+	}
+
+	if method == http.MethodGet {
+		return response(http.StatusOK, page{Confirm: true, Email: claims.Email, List: claims.List}), nil
+	}
+
+	if err := u.unsubscribe(ctx, claims); err != nil {
+		log.Printf("配信停止に失敗しました: email=%s, list=%s, error=%v", claims.Email, claims.List, err)
+		return response(http.StatusInternalServerError, page{Message: "内部エラーが発生しました"}), nil
+	}
+	log.Printf("配信を停止しました: email=%s, list=%s", claims.Email, claims.List)
+	return response(http.StatusOK, page{Message: "配信を停止しました"}), nil
+}
+
+// unsubscribe mail-addressesテーブルのunsubscribed_listsに配信リストを追加する。
+// 登録されていないメールアドレスの場合は何もしない
+func (u *unsubscriber) unsubscribe(ctx context.Context, claims *unsubscribe.Claims) error {
+	_, err := u.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(u.tableName),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: claims.Email},
+		},
+		UpdateExpression:    aws.String("ADD unsubscribed_lists :lists SET unsubscribed_at = :now"),
+		ConditionExpression: aws.String("attribute_exists(email)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":lists": &types.AttributeValueMemberSS{Value: []string{claims.List}},
+			":now":   &types.AttributeValueMemberS{Value: u.now().UTC().Format(time.RFC3339)},
+		},
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		log.Printf("登録されていないメールアドレスのため配信停止しません: email=%s", claims.Email)
+		return nil
+	}
+	return err
+}
+
+// parseForm application/x-www-form-urlencodedの本文をパースする
+func parseForm(request events.APIGatewayV2HTTPRequest) (url.Values, error) {
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(decoded)
+	}
+	return url.ParseQuery(body)
+}
+
+// response 画面を表示するレスポンスを生成する
+func response(statusCode int, p page) events.APIGatewayV2HTTPResponse {
+	var body bytes.Buffer
+	if err := pageTemplate.Execute(&body, p); err != nil {
+		log.Printf("画面の生成に失敗しました: %v", err)
+	}
+	headers := map[string]string{
+		"Content-Type": "text/html; charset=UTF-8",
+	}
+	if statusCode == http.StatusMethodNotAllowed {
+		headers["Allow"] = "GET, POST"
+	}
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode: statusCode,
+		Headers:    headers,
+		Body:       body.String(),
+	}
+}
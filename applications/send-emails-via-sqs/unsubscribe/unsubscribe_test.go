@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/unsubscribe"
+)
+
+// fakeDynamoDB mail-addressesテーブルの登録済みのメールアドレスと配信停止した配信リストを模したクライアント
+type fakeDynamoDB struct {
+	unsubscribed map[string][]string
+}
+
+func (f *fakeDynamoDB) UpdateItem(_ context.Context, params *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	email := params.Key["email"].(*types.AttributeValueMemberS).Value
+	lists, ok := f.unsubscribed[email]
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	f.unsubscribed[email] = append(lists, params.ExpressionAttributeValues[":lists"].(*types.AttributeValueMemberSS).Value...)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func newTestUnsubscriber() (*unsubscriber, *fakeDynamoDB) {
+	client := &fakeDynamoDB{unsubscribed: map[string][]string{"user@example.com": nil}}
+	return &unsubscriber{
+		client:    client,
+		tableName: "mail-addresses",
+		signer:    unsubscribe.NewSigner([]byte("secret")),
+		now:       time.Now,
+	}, client
+}
+
+func newRequest(method, token, body string) events.APIGatewayV2HTTPRequest {
+	request := events.APIGatewayV2HTTPRequest{Body: body}
+	request.RequestContext.HTTP.Method = method
+	if token != "" {
+		request.QueryStringParameters = map[string]string{"token": token}
+	}
+	return request
+}
+
+func sign(t *testing.T, email string, expiresAt time.Time) string {
+	t.Helper()
+	token, err := unsubscribe.NewSigner([]byte("secret")).Sign(email, "news", expiresAt)
+	if err != nil {
+		t.Fatalf("Sign() returned an error: %v", err)
+	}
+	return token
+}
+
+func TestHandleGetShowsConfirmation(t *testing.T) {
+	u, client := newTestUnsubscriber()
+	res, err := u.handle(context.Background(), newRequest(http.MethodGet, sign(t, "user@example.com", time.Now().Add(time.Hour)), ""))
+	if err != nil {
+		t.Fatalf("handle() returned an error: %v", err)
+	}
+	if res.StatusCode != http.StatusOK || !strings.Contains(res.Body, `<form method="post">`) {
+		t.Errorf("handle() = %d, %s", res.StatusCode, res.Body)
+	}
+	// GETでは配信停止しないこと
+	if len(client.unsubscribed["user@example.com"]) != 0 {
+		t.Errorf("unsubscribed on GET: %v", client.unsubscribed)
+	}
+}
+
+func TestHandlePostUnsubscribes(t *testing.T) {
+	token := sign(t, "user@example.com", time.Now().Add(time.Hour))
+	tests := map[string]events.APIGatewayV2HTTPRequest{
+		// RFC 8058のワンクリックの配信停止(トークンはList-UnsubscribeヘッダーのURLのクエリ)
+		"one-click": newRequest(http.MethodPost, token, "List-Unsubscribe=One-Click"),
+		// フォームの本文のトークン(base64でエンコードされた本文)
+		"form body": func() events.APIGatewayV2HTTPRequest {
+			r := newRequest(http.MethodPost, "", base64.StdEncoding.EncodeToString([]byte("token="+token)))
+			r.IsBase64Encoded = true
+			return r
+		}(),
+	}
+	for name, request := range tests {
+		t.Run(name, func(t *testing.T) {
+			u, client := newTestUnsubscriber()
+			res, err := u.handle(context.Background(), request)
+			if err != nil {
+				t.Fatalf("handle() returned an error: %v", err)
+			}
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("StatusCode = %d, want %d", res.StatusCode, http.StatusOK)
+			}
+			if got := client.unsubscribed["user@example.com"]; len(got) != 1 || got[0] != "news" {
+				t.Errorf("unsubscribed lists = %v, want [news]", got)
+			}
+		})
+	}
+}
+
+func TestHandleUnknownAddress(t *testing.T) {
+	u, _ := newTestUnsubscriber()
+	res, err := u.handle(context.Background(), newRequest(http.MethodPost, sign(t, "unknown@example.com", time.Now().Add(time.Hour)), ""))
+	if err != nil {
+		t.Fatalf("handle() returned an error: %v", err)
+	}
+	// 登録されていないメールアドレスでも登録の有無が分からないよう完了とすること
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("StatusCode = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+}
+
+func TestHandleRejects(t *testing.T) {
+	tests := map[string]struct {
+		request events.APIGatewayV2HTTPRequest
+		want    int
+	}{
+		"missing token":  {newRequest(http.MethodPost, "", ""), http.StatusBadRequest},
+		"invalid token":  {newRequest(http.MethodPost, "invalid.token", ""), http.StatusBadRequest},
+		"expired token":  {newRequest(http.MethodGet, sign(t, "user@example.com", time.Now().Add(-time.Hour)), ""), http.StatusBadRequest},
+		"invalid method": {newRequest(http.MethodDelete, sign(t, "user@example.com", time.Now().Add(time.Hour)), ""), http.StatusMethodNotAllowed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			u, client := newTestUnsubscriber()
+			res, err := u.handle(context.Background(), tt.request)
+			if err != nil {
+				t.Fatalf("handle() returned an error: %v", err)
+			}
+			if res.StatusCode != tt.want {
+				t.Errorf("StatusCode = %d, want %d", res.StatusCode, tt.want)
+			}
+			if len(client.unsubscribed["user@example.com"]) != 0 {
+				t.Errorf("unsubscribed: %v", client.unsubscribed)
+			}
+		})
+	}
+}
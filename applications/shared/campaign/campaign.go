@@ -0,0 +1,304 @@
+// Package campaign はメール配信システムの配信(S3に置かれたメール本文ファイルごとの送信)と、
+// 宛先ごとの配信状態・配信ごとの状態別の件数を提供する
+package campaign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// Status 宛先ごとの配信状態
+type Status string
+
+const (
+	// StatusQueued メール送信キューに登録済み(未送信)
+	StatusQueued Status = "QUEUED"
+	// StatusSending SESで送信中(リースの期限までは他の呼び出しは送信しない)
+	StatusSending Status = "SENDING"
+	// StatusSent SESで送信済み
+	StatusSent Status = "SENT"
+	// StatusFailed SESでの送信に失敗した(キューからの再配信で再送信する)
+	StatusFailed Status = "FAILED"
+	// StatusBounced 送信後にバウンスした
+	StatusBounced Status = "BOUNCED"
+	// StatusComplained 送信後に受信者から苦情(迷惑メール報告)を受けた
+	StatusComplained Status = "COMPLAINED"
+	// StatusSkipped 送信対象から除外した(キューへの登録の失敗・送信前にエラーのあるアドレスになった場合など)
+	StatusSkipped Status = "SKIPPED"
+)
+
+// Statuses 全ての配信状態(配信ごとの件数の集計対象)
+var Statuses = []Status{StatusQueued, StatusSending, StatusSent, StatusFailed, StatusBounced, StatusComplained, StatusSkipped}
+
+// transitions 配信状態ごとの遷移元の状態
+var transitions = map[Status][]Status{
+	// 送信中は未送信・送信失敗・リースの期限が切れた送信中から遷移する(Claim)
+	StatusSending: {StatusQueued, StatusFailed, StatusSending},
+	// 送信の結果はリースを取得した呼び出しだけが記録する(Complete・Release)
+	StatusSent:       {StatusSending},
+	StatusFailed:     {StatusSending},
+	StatusSkipped:    {StatusQueued, StatusFailed},
+	StatusBounced:    {StatusSent},
+	StatusComplained: {StatusSent},
+}
+
+// leasedStatuses Transitionでは遷移できない、送信のリースで管理する配信状態
+var leasedStatuses = []Status{StatusSending, StatusSent, StatusFailed}
+
+var (
+	// ErrNotFound 配信または宛先の配信状態が存在しない
+	ErrNotFound = errors.New("campaign not found")
+	// ErrAlreadyExists 配信が既に存在する
+	ErrAlreadyExists = errors.New("campaign already exists")
+	// ErrConflict 配信状態が他の呼び出しで更新された(他の呼び出しが送信中の場合も含む)
+	ErrConflict = errors.New("delivery status was changed concurrently")
+	// ErrInvalidTransition 現在の配信状態から指定した状態に遷移できない
+	ErrInvalidTransition = errors.New("invalid delivery status transition")
+)
+
+// ID S3のバケット名・キー・バージョンから配信IDを生成する
+func ID(bucketName, key, version string) string {
+	return fmt.Sprintf("%s/%s/%s", bucketName, key, version)
+}
+
+// Campaign S3に置かれたメール本文ファイルごとの配信
+type Campaign struct {
+	ID         string `json:"campaign_id"`
+	BucketName string `json:"bucket_name"`
+	FileName   string `json:"file_name"`
+	Version    string `json:"version"`
+	// Totals 配信状態ごとの宛先の件数
+	Totals map[Status]int64 `json:"totals"`
+	// Error 配信を中止した理由(メール本文ファイルのテンプレートが不正な場合など)。中止していない場合は空
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Recipient 配信の宛先
+type Recipient struct {
+	Email    string `json:"email"`
+	UserName string `json:"user_name"`
+}
+
+// Delivery 配信ごとの宛先の配信状態
+type Delivery struct {
+	CampaignID string `json:"campaign_id"`
+	Email      string `json:"email"`
+	UserName   string `json:"user_name"`
+	Status     Status `json:"status"`
+	// MessageID SESのメッセージID(バウンス・苦情の通知から配信状態を特定するために使用)
+	MessageID string `json:"message_id,omitempty"`
+	// LeaseExpiresAt 送信中のリースの期限。期限が切れた送信中の配信状態は他の呼び出しが送信し直せる
+	LeaseExpiresAt time.Time `json:"lease_expires_at,omitzero"`
+	// Error 最後に送信に失敗した理由
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Service 配信と配信状態の操作
+type Service struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewService 配信と配信状態の操作を生成する
+func NewService(store Store) *Service {
+	return &Service{store: store, now: time.Now}
+}
+
+// Create 配信を作成する。既に存在する場合は既存の配信を返す
+func (s *Service) Create(ctx context.Context, bucketName, key, version string) (*Campaign, error) {
+	c := &Campaign{
+		ID:         ID(bucketName, key, version),
+		BucketName: bucketName,
+		FileName:   key,
+		Version:    version,
+		Totals:     make(map[Status]int64),
+		CreatedAt:  s.now(),
+	}
+	err := s.store.CreateCampaign(ctx, c)
+	if errors.Is(err, ErrAlreadyExists) {
+		return s.store.GetCampaign(ctx, c.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("配信の作成エラー: %w", err)
+	}
+	log.Printf("配信を作成しました: campaign_id=%s", c.ID)
+	return c, nil
+}
+
+// Get 配信を取得する
+func (s *Service) Get(ctx context.Context, campaignID string) (*Campaign, error) {
+	return s.store.GetCampaign(ctx, campaignID)
+}
+
+// Fail 宛先をキューに登録する前に配信を中止し、理由を記録する
+func (s *Service) Fail(ctx context.Context, campaignID, reason string) error {
+	if err := s.store.Fail(ctx, campaignID, reason); err != nil {
+		return fmt.Errorf("配信の中止の記録エラー: %w", err)
+	}
+	log.Printf("配信を中止しました: campaign_id=%s, reason=%s", campaignID, reason)
+	return nil
+}
+
+// Queue 宛先の配信状態を未送信で作成し、キューに登録すべき宛先を返す。
+// 配信状態が既に存在する宛先は、未送信・送信失敗のまま(前回の登録が途中で失敗した場合など)のものだけを返す
+func (s *Service) Queue(ctx context.Context, campaignID string, recipients []Recipient) ([]Recipient, error) {
+	now := s.now()
+	deliveries := make([]Delivery, 0, len(recipients))
+	for _, r := range recipients {
+		deliveries = append(deliveries, Delivery{
+			CampaignID: campaignID,
+			Email:      r.Email,
+			UserName:   r.UserName,
+			Status:     StatusQueued,
+			UpdatedAt:  now,
+		})
+	}
+
+	existing, err := s.store.Queue(ctx, campaignID, deliveries)
+	if err != nil {
+		return nil, fmt.Errorf("配信状態の作成エラー: %w", err)
+	}
+	if created := int64(len(deliveries) - len(existing)); created > 0 {
+		s.addTotals(ctx, campaignID, map[Status]int64{StatusQueued: created})
+	}
+	done := make(map[string]bool, len(existing))
+	for _, d := range existing {
+		done[d.Email] = d.Status != StatusQueued && d.Status != StatusFailed
+	}
+
+	var queue []Recipient
+	for _, r := range recipients {
+		if done[r.Email] {
+			log.Printf("配信状態が未送信・送信失敗ではないため登録をスキップ: campaign_id=%s, email=%s", campaignID, r.Email)
+			continue
+		}
+		queue = append(queue, r)
+	}
+	return queue, nil
+}
+
+// Claim 宛先の配信状態を送信中に更新し、lease後を期限とする送信のリースを取得する。
+// 未送信・送信失敗・リースの期限が切れた送信中の場合のみ取得でき、送信済み・除外済みなどの場合はErrInvalidTransition、
+// 他の呼び出しがリースを取得している(送信中の)場合はErrConflictを返す
+func (s *Service) Claim(ctx context.Context, campaignID, email string, lease time.Duration) (*Delivery, error) {
+	d, err := s.store.GetDelivery(ctx, campaignID, email)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(transitions[StatusSending], d.Status) {
+		return nil, fmt.Errorf("%w: %s->%s, campaign_id=%s, email=%s", ErrInvalidTransition, d.Status, StatusSending, campaignID, email)
+	}
+	now := s.now()
+	if d.Status == StatusSending && now.Before(d.LeaseExpiresAt) {
+		return nil, fmt.Errorf("%w: 送信中(リースの期限: %s), campaign_id=%s, email=%s", ErrConflict, d.LeaseExpiresAt, campaignID, email)
+	}
+
+	from := d.Status
+	leaseExpiresAt := now.Add(lease)
+	if err := s.store.Claim(ctx, campaignID, email, from, now, leaseExpiresAt); err != nil {
+		return nil, fmt.Errorf("配信状態の更新エラー: %w", err)
+	}
+	s.moveTotal(ctx, campaignID, from, StatusSending)
+	if from == StatusSending {
+		log.Printf("リースの期限が切れた送信中の配信状態を取得しました: campaign_id=%s, email=%s, lease_expires_at=%s", campaignID, email, d.LeaseExpiresAt)
+	}
+	d.Status = StatusSending
+	d.LeaseExpiresAt = leaseExpiresAt
+	d.UpdatedAt = now
+	log.Printf("配信状態を更新しました: campaign_id=%s, email=%s, status=%s->%s", campaignID, email, from, StatusSending)
+	return d, nil
+}
+
+// Complete リースを取得した送信中の配信状態を送信済みに更新し、SESのメッセージIDを記録する。
+// リースの期限が切れて他の呼び出しに取得された場合はErrConflictを返す
+func (s *Service) Complete(ctx context.Context, d *Delivery, messageID string) error {
+	return s.finish(ctx, d, StatusSent, messageID, "")
+}
+
+// Release リースを取得した送信中の配信状態を送信失敗に更新し、理由を記録する。
+// 送信失敗の配信状態はキューからの再配信で再度Claimできる
+func (s *Service) Release(ctx context.Context, d *Delivery, reason string) error {
+	return s.finish(ctx, d, StatusFailed, d.MessageID, reason)
+}
+
+// finish 送信中の配信状態を送信の結果に更新し、リースを解放する
+func (s *Service) finish(ctx context.Context, d *Delivery, to Status, messageID, reason string) error {
+	if d.Status != StatusSending {
+		return fmt.Errorf("%w: %s->%s, campaign_id=%s, email=%s", ErrInvalidTransition, d.Status, to, d.CampaignID, d.Email)
+	}
+	result := *d
+	result.Status = to
+	result.MessageID = messageID
+	result.Error = reason
+	result.LeaseExpiresAt = time.Time{}
+	result.UpdatedAt = s.now()
+	if err := s.store.Finish(ctx, &result, d.LeaseExpiresAt); err != nil {
+		return fmt.Errorf("配信状態の更新エラー: %w", err)
+	}
+	s.moveTotal(ctx, d.CampaignID, StatusSending, to)
+	*d = result
+	log.Printf("配信状態を更新しました: campaign_id=%s, email=%s, status=%s->%s", d.CampaignID, d.Email, StatusSending, to)
+	return nil
+}
+
+// Transition 宛先の配信状態をtoに更新する。今回更新した場合はtrue、既にtoの場合はfalseを返す。
+// 現在の状態からtoに遷移できない場合はErrInvalidTransition、他の呼び出しで更新された場合はErrConflictを返す。
+// 送信中・送信済み・送信失敗へはClaim・Complete・Releaseで更新する
+func (s *Service) Transition(ctx context.Context, campaignID, email string, to Status) (bool, error) {
+	d, err := s.store.GetDelivery(ctx, campaignID, email)
+	if err != nil {
+		return false, err
+	}
+	return s.transition(ctx, d, to)
+}
+
+// TransitionByMessageID SESのメッセージIDで特定した宛先の配信状態をtoに更新する
+func (s *Service) TransitionByMessageID(ctx context.Context, messageID string, to Status) (*Delivery, bool, error) {
+	d, err := s.store.FindByMessageID(ctx, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+	changed, err := s.transition(ctx, d, to)
+	return d, changed, err
+}
+
+// transition 配信状態を現在の状態からtoに更新する
+func (s *Service) transition(ctx context.Context, d *Delivery, to Status) (bool, error) {
+	if d.Status == to {
+		return false, nil
+	}
+	if slices.Contains(leasedStatuses, to) || !slices.Contains(transitions[to], d.Status) {
+		return false, fmt.Errorf("%w: %s->%s, campaign_id=%s, email=%s", ErrInvalidTransition, d.Status, to, d.CampaignID, d.Email)
+	}
+
+	from := d.Status
+	if err := s.store.Transition(ctx, d.CampaignID, d.Email, from, to, s.now()); err != nil {
+		return false, fmt.Errorf("配信状態の更新エラー: %w", err)
+	}
+	s.moveTotal(ctx, d.CampaignID, from, to)
+	d.Status = to
+	log.Printf("配信状態を更新しました: campaign_id=%s, email=%s, status=%s->%s", d.CampaignID, d.Email, from, to)
+	return true, nil
+}
+
+// moveTotal 配信の状態別の件数をfromからtoへ1件移し替える
+func (s *Service) moveTotal(ctx context.Context, campaignID string, from, to Status) {
+	// リースの期限が切れた送信中の宛先を取得し直した場合は件数は変わらない
+	if from == to {
+		return
+	}
+	s.addTotals(ctx, campaignID, map[Status]int64{from: -1, to: 1})
+}
+
+// addTotals 配信の状態別の件数を加算する。件数は集計用のため、加算に失敗しても配信状態の更新は成功として扱う
+func (s *Service) addTotals(ctx context.Context, campaignID string, deltas map[Status]int64) {
+	if err := s.store.AddTotals(context.WithoutCancel(ctx), campaignID, deltas); err != nil {
+		log.Printf("配信の件数の更新に失敗しました: campaign_id=%s, deltas=%v, error=%v", campaignID, deltas, err)
+	}
+}
@@ -0,0 +1,232 @@
+package campaign
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestService() (*Service, *Campaign) {
+	service, c, _ := newTestServiceWithClock()
+	return service, c
+}
+
+// newTestServiceWithClock 時刻を進める関数とともにServiceを生成する
+func newTestServiceWithClock() (*Service, *Campaign, func(time.Duration)) {
+	service := NewService(NewMemoryStore())
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	service.now = func() time.Time { return now }
+	c, err := service.Create(context.Background(), "bucket", "mail-body.txt", "v1")
+	if err != nil {
+		panic(err)
+	}
+	return service, c, func(d time.Duration) { now = now.Add(d) }
+}
+
+// send 宛先の送信のリースを取得して送信済みにする
+func send(t *testing.T, s *Service, campaignID, email, messageID string) {
+	t.Helper()
+	d, err := s.Claim(context.Background(), campaignID, email, time.Minute)
+	if err != nil {
+		t.Fatalf("Claim() returned an error: %v", err)
+	}
+	if err := s.Complete(context.Background(), d, messageID); err != nil {
+		t.Fatalf("Complete() returned an error: %v", err)
+	}
+}
+
+func assertTotals(t *testing.T, s *Service, campaignID string, want map[Status]int64) {
+	t.Helper()
+	c, err := s.Get(context.Background(), campaignID)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	for _, status := range Statuses {
+		if c.Totals[status] != want[status] {
+			t.Errorf("Totals[%s] = %d, want %d", status, c.Totals[status], want[status])
+		}
+	}
+}
+
+func TestServiceCreate(t *testing.T) {
+	service, c := newTestService()
+	if c.ID != "bucket/mail-body.txt/v1" {
+		t.Errorf("ID = %q, want %q", c.ID, "bucket/mail-body.txt/v1")
+	}
+
+	// 同じファイルのイベントが再度届いても既存の配信を返すこと
+	again, err := service.Create(context.Background(), "bucket", "mail-body.txt", "v1")
+	if err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+	if again.ID != c.ID {
+		t.Errorf("ID = %q, want %q", again.ID, c.ID)
+	}
+
+	// 別のバージョンは別の配信になること
+	other, err := service.Create(context.Background(), "bucket", "mail-body.txt", "v2")
+	if err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+	if other.ID == c.ID {
+		t.Errorf("ID = %q, want a different campaign", other.ID)
+	}
+
+	// 中止した配信は再度届いたイベントでも中止のままであること
+	if err := service.Fail(context.Background(), c.ID, "invalid template"); err != nil {
+		t.Fatalf("Fail() returned an error: %v", err)
+	}
+	again, err = service.Create(context.Background(), "bucket", "mail-body.txt", "v1")
+	if err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+	if again.Error != "invalid template" {
+		t.Errorf("Error = %q, want %q", again.Error, "invalid template")
+	}
+}
+
+func TestServiceQueue(t *testing.T) {
+	service, c := newTestService()
+	ctx := context.Background()
+	recipients := []Recipient{{Email: "a@example.com"}, {Email: "b@example.com"}}
+
+	queue, err := service.Queue(ctx, c.ID, recipients)
+	if err != nil {
+		t.Fatalf("Queue() returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(queue, recipients) {
+		t.Errorf("Queue() = %v, want %v", queue, recipients)
+	}
+
+	// 再実行時は送信済みの宛先だけを除き、件数を二重に数えないこと
+	send(t, service, c.ID, "a@example.com", "message-1")
+	queue, err = service.Queue(ctx, c.ID, append(recipients, Recipient{Email: "c@example.com"}))
+	if err != nil {
+		t.Fatalf("Queue() returned an error: %v", err)
+	}
+	if want := []Recipient{{Email: "b@example.com"}, {Email: "c@example.com"}}; !reflect.DeepEqual(queue, want) {
+		t.Errorf("Queue() = %v, want %v", queue, want)
+	}
+	assertTotals(t, service, c.ID, map[Status]int64{StatusQueued: 2, StatusSent: 1})
+}
+
+func TestServiceTransition(t *testing.T) {
+	service, c := newTestService()
+	ctx := context.Background()
+	if _, err := service.Queue(ctx, c.ID, []Recipient{{Email: "a@example.com"}, {Email: "b@example.com"}}); err != nil {
+		t.Fatalf("Queue() returned an error: %v", err)
+	}
+
+	// 送信済みへはリースを取得せずに更新できないこと
+	if _, err := service.Transition(ctx, c.ID, "a@example.com", StatusSent); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Transition() error = %v, want %v", err, ErrInvalidTransition)
+	}
+	send(t, service, c.ID, "a@example.com", "message-1")
+
+	// 未送信の宛先はバウンスできないこと
+	if _, err := service.Transition(ctx, c.ID, "b@example.com", StatusBounced); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Transition() error = %v, want %v", err, ErrInvalidTransition)
+	}
+	if _, err := service.Transition(ctx, c.ID, "b@example.com", StatusSkipped); err != nil {
+		t.Fatalf("Transition() returned an error: %v", err)
+	}
+	if _, err := service.Transition(ctx, c.ID, "x@example.com", StatusSent); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Transition() error = %v, want %v", err, ErrNotFound)
+	}
+
+	// バウンス通知はSESのメッセージIDで配信状態を特定すること
+	d, changed, err := service.TransitionByMessageID(ctx, "message-1", StatusBounced)
+	if err != nil || !changed {
+		t.Fatalf("TransitionByMessageID() = %t, %v, want changed", changed, err)
+	}
+	if d.Email != "a@example.com" || d.Status != StatusBounced {
+		t.Errorf("TransitionByMessageID() = %+v, want a@example.com BOUNCED", d)
+	}
+	assertTotals(t, service, c.ID, map[Status]int64{StatusBounced: 1, StatusSkipped: 1})
+}
+
+func TestServiceClaim(t *testing.T) {
+	service, c, advance := newTestServiceWithClock()
+	ctx := context.Background()
+	if _, err := service.Queue(ctx, c.ID, []Recipient{{Email: "a@example.com"}}); err != nil {
+		t.Fatalf("Queue() returned an error: %v", err)
+	}
+
+	d, err := service.Claim(ctx, c.ID, "a@example.com", time.Minute)
+	if err != nil {
+		t.Fatalf("Claim() returned an error: %v", err)
+	}
+	// リースの期限までは重複したメッセージでリースを取得できないこと(二重送信を防ぐ)
+	if _, err := service.Claim(ctx, c.ID, "a@example.com", time.Minute); !errors.Is(err, ErrConflict) {
+		t.Errorf("Claim() error = %v, want %v", err, ErrConflict)
+	}
+	assertTotals(t, service, c.ID, map[Status]int64{StatusSending: 1})
+
+	// 送信に失敗した宛先は再配信で再度リースを取得できること
+	if err := service.Release(ctx, d, "throttled"); err != nil {
+		t.Fatalf("Release() returned an error: %v", err)
+	}
+	assertTotals(t, service, c.ID, map[Status]int64{StatusFailed: 1})
+	if d, err = service.Claim(ctx, c.ID, "a@example.com", time.Minute); err != nil {
+		t.Fatalf("Claim() returned an error: %v", err)
+	}
+
+	// リースの期限が切れた送信中の宛先は他の呼び出しが取得でき、期限切れのリースでは結果を記録できないこと
+	advance(2 * time.Minute)
+	reclaimed, err := service.Claim(ctx, c.ID, "a@example.com", time.Minute)
+	if err != nil {
+		t.Fatalf("Claim() returned an error: %v", err)
+	}
+	if err := service.Complete(ctx, d, "message-1"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Complete() with an expired lease error = %v, want %v", err, ErrConflict)
+	}
+	if err := service.Complete(ctx, reclaimed, "message-2"); err != nil {
+		t.Fatalf("Complete() returned an error: %v", err)
+	}
+
+	// 送信済みの宛先はリースを取得できないこと
+	if _, err := service.Claim(ctx, c.ID, "a@example.com", time.Minute); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Claim() error = %v, want %v", err, ErrInvalidTransition)
+	}
+	sent, err := service.store.GetDelivery(ctx, c.ID, "a@example.com")
+	if err != nil {
+		t.Fatalf("GetDelivery() returned an error: %v", err)
+	}
+	if sent.Status != StatusSent || sent.MessageID != "message-2" || !sent.LeaseExpiresAt.IsZero() || sent.Error != "" {
+		t.Errorf("delivery = %+v, want SENT with message-2 and no lease", sent)
+	}
+	assertTotals(t, service, c.ID, map[Status]int64{StatusSent: 1})
+}
+
+// failingTotalsStore 件数の加算が常に失敗するストア
+type failingTotalsStore struct {
+	*MemoryStore
+}
+
+func (failingTotalsStore) AddTotals(context.Context, string, map[Status]int64) error {
+	return errors.New("throttled")
+}
+
+func TestServiceCompleteIgnoresTotalsFailure(t *testing.T) {
+	service := NewService(failingTotalsStore{MemoryStore: NewMemoryStore()})
+	ctx := context.Background()
+	c, err := service.Create(ctx, "bucket", "mail-body.txt", "v1")
+	if err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+	if _, err := service.Queue(ctx, c.ID, []Recipient{{Email: "a@example.com"}}); err != nil {
+		t.Fatalf("Queue() returned an error: %v", err)
+	}
+
+	// 件数を加算できなくても、配信状態とメッセージIDは記録されること
+	send(t, service, c.ID, "a@example.com", "message-1")
+	d, err := service.store.GetDelivery(ctx, c.ID, "a@example.com")
+	if err != nil {
+		t.Fatalf("GetDelivery() returned an error: %v", err)
+	}
+	if d.Status != StatusSent || d.MessageID != "message-1" {
+		t.Errorf("delivery = %+v, want SENT with message-1", d)
+	}
+}
@@ -0,0 +1,566 @@
+package campaign
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/internal/ddbattr"
+)
+
+// messageIDIndexName SESのメッセージIDで配信状態を検索するためのGSI
+const messageIDIndexName = "message_id-index"
+
+// Store 配信と配信状態の永続化
+type Store interface {
+	// CreateCampaign 配信を作成する。既に存在する場合はErrAlreadyExistsを返す
+	CreateCampaign(ctx context.Context, c *Campaign) error
+	// GetCampaign 配信を取得する。存在しない場合はErrNotFoundを返す
+	GetCampaign(ctx context.Context, campaignID string) (*Campaign, error)
+	// Fail 配信を中止した理由を記録する。存在しない場合はErrNotFoundを返す
+	Fail(ctx context.Context, campaignID, reason string) error
+	// Queue 配信状態が存在しない宛先の配信状態を作成する。既に配信状態が存在した宛先の配信状態を返す
+	Queue(ctx context.Context, campaignID string, deliveries []Delivery) ([]Delivery, error)
+	// GetDelivery 宛先の配信状態を取得する。存在しない場合はErrNotFoundを返す
+	GetDelivery(ctx context.Context, campaignID, email string) (*Delivery, error)
+	// FindByMessageID SESのメッセージIDで配信状態を取得する。存在しない場合はErrNotFoundを返す
+	FindByMessageID(ctx context.Context, messageID string) (*Delivery, error)
+	// Transition 配信状態がfromの場合のみtoに更新する。配信状態がfromでない場合はErrConflictを返す
+	Transition(ctx context.Context, campaignID, email string, from, to Status, at time.Time) error
+	// Claim 配信状態がfrom(送信中の場合はリースの期限がatより前)の場合のみ、
+	// leaseExpiresAtを期限として送信中に更新する。条件を満たさない場合はErrConflictを返す
+	Claim(ctx context.Context, campaignID, email string, from Status, at, leaseExpiresAt time.Time) error
+	// Finish 配信状態が送信中でリースの期限がleaseExpiresAtの(リースを取得した呼び出しの)場合のみ、
+	// dの配信状態・メッセージID・失敗の理由に更新してリースを解放する。条件を満たさない場合はErrConflictを返す
+	Finish(ctx context.Context, d *Delivery, leaseExpiresAt time.Time) error
+	// AddTotals 配信の状態別の件数にdeltasを加算する
+	AddTotals(ctx context.Context, campaignID string, deltas map[Status]int64) error
+}
+
+// MemoryStore テスト・ローカル実行用のインメモリストア
+type MemoryStore struct {
+	mu         sync.Mutex
+	campaigns  map[string]Campaign
+	deliveries map[string]map[string]Delivery
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		campaigns:  make(map[string]Campaign),
+		deliveries: make(map[string]map[string]Delivery),
+	}
+}
+
+// CreateCampaign 配信を作成する
+func (s *MemoryStore) CreateCampaign(_ context.Context, c *Campaign) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.campaigns[c.ID]; ok {
+		return ErrAlreadyExists
+	}
+	stored := *c
+	stored.Totals = make(map[Status]int64)
+	s.campaigns[c.ID] = stored
+	s.deliveries[c.ID] = make(map[string]Delivery)
+	return nil
+}
+
+// GetCampaign 配信を取得する
+func (s *MemoryStore) GetCampaign(_ context.Context, campaignID string) (*Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.campaigns[campaignID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	totals := make(map[Status]int64, len(c.Totals))
+	for status, n := range c.Totals {
+		totals[status] = n
+	}
+	c.Totals = totals
+	return &c, nil
+}
+
+// Fail 配信を中止した理由を記録する
+func (s *MemoryStore) Fail(_ context.Context, campaignID, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.campaigns[campaignID]
+	if !ok {
+		return ErrNotFound
+	}
+	c.Error = reason
+	s.campaigns[campaignID] = c
+	return nil
+}
+
+// Queue 配信状態が存在しない宛先の配信状態を作成する
+func (s *MemoryStore) Queue(_ context.Context, campaignID string, deliveries []Delivery) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.campaigns[campaignID]; !ok {
+		return nil, ErrNotFound
+	}
+	var existing []Delivery
+	for _, d := range deliveries {
+		if e, ok := s.deliveries[campaignID][d.Email]; ok {
+			existing = append(existing, e)
+			continue
+		}
+		s.deliveries[campaignID][d.Email] = d
+	}
+	return existing, nil
+}
+
+// GetDelivery 宛先の配信状態を取得する
+func (s *MemoryStore) GetDelivery(_ context.Context, campaignID, email string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[campaignID][email]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &d, nil
+}
+
+// FindByMessageID SESのメッセージIDで配信状態を取得する
+func (s *MemoryStore) FindByMessageID(_ context.Context, messageID string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, deliveries := range s.deliveries {
+		for _, d := range deliveries {
+			if d.MessageID == messageID {
+				return &d, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Transition 配信状態がfromの場合のみtoに更新する
+func (s *MemoryStore) Transition(_ context.Context, campaignID, email string, from, to Status, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[campaignID][email]
+	if !ok || d.Status != from {
+		return ErrConflict
+	}
+	d.Status = to
+	d.UpdatedAt = at
+	s.deliveries[campaignID][email] = d
+	return nil
+}
+
+// Claim 配信状態がfromの場合のみ送信中に更新する
+func (s *MemoryStore) Claim(_ context.Context, campaignID, email string, from Status, at, leaseExpiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[campaignID][email]
+	if !ok || d.Status != from || (from == StatusSending && !d.LeaseExpiresAt.Before(at)) {
+		return ErrConflict
+	}
+	d.Status = StatusSending
+	d.LeaseExpiresAt = leaseExpiresAt
+	d.UpdatedAt = at
+	s.deliveries[campaignID][email] = d
+	return nil
+}
+
+// Finish リースを取得した送信中の配信状態を送信の結果に更新する
+func (s *MemoryStore) Finish(_ context.Context, d *Delivery, leaseExpiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.deliveries[d.CampaignID][d.Email]
+	if !ok || current.Status != StatusSending || !current.LeaseExpiresAt.Equal(leaseExpiresAt) {
+		return ErrConflict
+	}
+	s.deliveries[d.CampaignID][d.Email] = *d
+	return nil
+}
+
+// AddTotals 配信の状態別の件数にdeltasを加算する
+func (s *MemoryStore) AddTotals(_ context.Context, campaignID string, deltas map[Status]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.campaigns[campaignID]
+	if !ok {
+		return ErrNotFound
+	}
+	for status, n := range deltas {
+		c.Totals[status] += n
+	}
+	return nil
+}
+
+// DynamoDBAPI DynamoDBStoreが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// DynamoDBStore DynamoDBの配信テーブル(キー: campaign_id)と配信状態テーブル(キー: campaign_id, email)を使用するストア。
+// 配信テーブルには配信状態ごとの件数を状態名を小文字にした属性(queued・sentなど)で保持する。
+// 件数は宛先ごとの配信状態の更新とは別に加算し、同時に送信する呼び出しが配信テーブルの同じアイテムでトランザクションを競合させないようにする
+type DynamoDBStore struct {
+	client        DynamoDBAPI
+	campaignTable string
+	deliveryTable string
+}
+
+// NewDynamoDBStore DynamoDBStoreを生成する
+func NewDynamoDBStore(client DynamoDBAPI, campaignTable, deliveryTable string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, campaignTable: campaignTable, deliveryTable: deliveryTable}
+}
+
+// CreateCampaign 配信を条件付きで書き込む
+func (s *DynamoDBStore) CreateCampaign(ctx context.Context, c *Campaign) error {
+	item := map[string]types.AttributeValue{
+		"campaign_id": ddbattr.S(c.ID),
+		"bucket_name": ddbattr.S(c.BucketName),
+		"file_name":   ddbattr.S(c.FileName),
+		"version":     ddbattr.S(c.Version),
+		"created_at":  ddbattr.Time(c.CreatedAt),
+	}
+	for _, status := range Statuses {
+		item[totalAttr(status)] = ddbattr.N(0)
+	}
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.campaignTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(campaign_id)"),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// GetCampaign 配信を取得する
+func (s *DynamoDBStore) GetCampaign(ctx context.Context, campaignID string) (*Campaign, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.campaignTable),
+		Key: map[string]types.AttributeValue{
+			"campaign_id": ddbattr.S(campaignID),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	c := &Campaign{
+		ID:         ddbattr.String(result.Item, "campaign_id"),
+		BucketName: ddbattr.String(result.Item, "bucket_name"),
+		FileName:   ddbattr.String(result.Item, "file_name"),
+		Version:    ddbattr.String(result.Item, "version"),
+		Totals:     make(map[Status]int64, len(Statuses)),
+		Error:      ddbattr.String(result.Item, "error"),
+	}
+	for _, status := range Statuses {
+		if c.Totals[status], err = ddbattr.Int64(result.Item, totalAttr(status)); err != nil {
+			return nil, err
+		}
+	}
+	if c.CreatedAt, err = ddbattr.ParseTime(result.Item, "created_at"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Fail 配信を中止した理由を記録する
+func (s *DynamoDBStore) Fail(ctx context.Context, campaignID, reason string) error {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.campaignTable),
+		Key: map[string]types.AttributeValue{
+			"campaign_id": ddbattr.S(campaignID),
+		},
+		UpdateExpression:    aws.String("SET #error = :reason"),
+		ConditionExpression: aws.String("attribute_exists(campaign_id)"),
+		ExpressionAttributeNames: map[string]string{
+			"#error": "error",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":reason": ddbattr.S(reason),
+		},
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Queue 配信状態の条件付き書き込みをトランザクションで行う。
+// 既に存在する配信状態があった場合はトランザクション全体が取り消されるため、それらを除いて書き込み直す
+func (s *DynamoDBStore) Queue(ctx context.Context, campaignID string, deliveries []Delivery) ([]Delivery, error) {
+	var existing []Delivery
+	for len(deliveries) > 0 {
+		transactItems := make([]types.TransactWriteItem, 0, len(deliveries))
+		for _, d := range deliveries {
+			transactItems = append(transactItems, types.TransactWriteItem{
+				Put: &types.Put{
+					TableName:                           aws.String(s.deliveryTable),
+					Item:                                marshalDelivery(&d),
+					ConditionExpression:                 aws.String("attribute_not_exists(email)"),
+					ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+				},
+			})
+		}
+
+		_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+		var canceled *types.TransactionCanceledException
+		if !errors.As(err, &canceled) {
+			return existing, err
+		}
+
+		// 条件チェックに失敗した(既に存在する)配信状態を除く
+		var retry []Delivery
+		for i, d := range deliveries {
+			if i >= len(canceled.CancellationReasons) || aws.ToString(canceled.CancellationReasons[i].Code) != "ConditionalCheckFailed" {
+				retry = append(retry, d)
+				continue
+			}
+			e, err := unmarshalDelivery(canceled.CancellationReasons[i].Item)
+			if err != nil {
+				return nil, err
+			}
+			existing = append(existing, *e)
+		}
+		if len(retry) == len(deliveries) {
+			return existing, err
+		}
+		deliveries = retry
+	}
+	return existing, nil
+}
+
+// GetDelivery 宛先の配信状態を取得する
+func (s *DynamoDBStore) GetDelivery(ctx context.Context, campaignID, email string) (*Delivery, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.deliveryTable),
+		Key:            deliveryKey(campaignID, email),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	return unmarshalDelivery(result.Item)
+}
+
+// FindByMessageID GSIを使用してSESのメッセージIDで配信状態を取得する
+func (s *DynamoDBStore) FindByMessageID(ctx context.Context, messageID string) (*Delivery, error) {
+	result, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.deliveryTable),
+		IndexName:              aws.String(messageIDIndexName),
+		KeyConditionExpression: aws.String("message_id = :message_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":message_id": ddbattr.S(messageID),
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return nil, ErrNotFound
+	}
+	// GSIは結果整合性のため、最新の配信状態をテーブルから取得し直す
+	d, err := unmarshalDelivery(result.Items[0])
+	if err != nil {
+		return nil, err
+	}
+	return s.GetDelivery(ctx, d.CampaignID, d.Email)
+}
+
+// Transition 配信状態を条件付きで更新する
+func (s *DynamoDBStore) Transition(ctx context.Context, campaignID, email string, from, to Status, at time.Time) error {
+	return s.update(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.deliveryTable),
+		Key:                 deliveryKey(campaignID, email),
+		UpdateExpression:    aws.String("SET #status = :to, updated_at = :at REMOVE lease_expires_at"),
+		ConditionExpression: aws.String("#status = :from"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":from": ddbattr.S(string(from)),
+			":to":   ddbattr.S(string(to)),
+			":at":   ddbattr.Time(at),
+		},
+	})
+}
+
+// Claim 配信状態を送信中に条件付きで更新する
+func (s *DynamoDBStore) Claim(ctx context.Context, campaignID, email string, from Status, at, leaseExpiresAt time.Time) error {
+	condition := "#status = :from"
+	values := map[string]types.AttributeValue{
+		":from":  ddbattr.S(string(from)),
+		":to":    ddbattr.S(string(StatusSending)),
+		":at":    ddbattr.Time(at),
+		":lease": ddbattr.Time(leaseExpiresAt),
+	}
+	if from == StatusSending {
+		// 時刻属性は固定長のため文字列の比較でリースの期限切れを判定できる
+		condition += " AND lease_expires_at < :at"
+	}
+	return s.update(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.deliveryTable),
+		Key:                 deliveryKey(campaignID, email),
+		UpdateExpression:    aws.String("SET #status = :to, updated_at = :at, lease_expires_at = :lease"),
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: values,
+	})
+}
+
+// Finish リースを取得した送信中の配信状態を条件付きで更新する
+func (s *DynamoDBStore) Finish(ctx context.Context, d *Delivery, leaseExpiresAt time.Time) error {
+	update := "SET #status = :to, updated_at = :at"
+	values := map[string]types.AttributeValue{
+		":sending": ddbattr.S(string(StatusSending)),
+		":lease":   ddbattr.Time(leaseExpiresAt),
+		":to":      ddbattr.S(string(d.Status)),
+		":at":      ddbattr.Time(d.UpdatedAt),
+	}
+	// GSIのキーは空文字にできないため、メッセージIDがない場合は属性を書き込まない
+	if d.MessageID != "" {
+		update += ", message_id = :message_id"
+		values[":message_id"] = ddbattr.S(d.MessageID)
+	}
+	remove := " REMOVE lease_expires_at"
+	if d.Error != "" {
+		update += ", #error = :error"
+		values[":error"] = ddbattr.S(d.Error)
+	} else {
+		remove += ", #error"
+	}
+	return s.update(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.deliveryTable),
+		Key:                 deliveryKey(d.CampaignID, d.Email),
+		UpdateExpression:    aws.String(update + remove),
+		ConditionExpression: aws.String("#status = :sending AND lease_expires_at = :lease"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+			"#error":  "error",
+		},
+		ExpressionAttributeValues: values,
+	})
+}
+
+// update 配信状態を条件付きで更新する。条件チェックに失敗した場合はErrConflictを返す
+func (s *DynamoDBStore) update(ctx context.Context, input *dynamodb.UpdateItemInput) error {
+	_, err := s.client.UpdateItem(ctx, input)
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrConflict
+	}
+	return err
+}
+
+// AddTotals 配信の状態別の件数をトランザクションを使用せずに加算する
+func (s *DynamoDBStore) AddTotals(ctx context.Context, campaignID string, deltas map[Status]int64) error {
+	var adds []string
+	names := make(map[string]string, len(deltas))
+	values := make(map[string]types.AttributeValue, len(deltas))
+	for _, status := range Statuses {
+		n, ok := deltas[status]
+		if !ok {
+			continue
+		}
+		attr := totalAttr(status)
+		adds = append(adds, "#"+attr+" :"+attr)
+		names["#"+attr] = attr
+		values[":"+attr] = ddbattr.N(n)
+	}
+	if len(adds) == 0 {
+		return nil
+	}
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.campaignTable),
+		Key: map[string]types.AttributeValue{
+			"campaign_id": ddbattr.S(campaignID),
+		},
+		UpdateExpression:          aws.String("ADD " + strings.Join(adds, ", ")),
+		ConditionExpression:       aws.String("attribute_exists(campaign_id)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// totalAttr 配信テーブルの配信状態ごとの件数の属性名
+func totalAttr(status Status) string {
+	return strings.ToLower(string(status))
+}
+
+// deliveryKey 配信状態テーブルのキー
+func deliveryKey(campaignID, email string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"campaign_id": ddbattr.S(campaignID),
+		"email":       ddbattr.S(email),
+	}
+}
+
+// marshalDelivery 配信状態をDynamoDBのアイテムに変換する
+func marshalDelivery(d *Delivery) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"campaign_id": ddbattr.S(d.CampaignID),
+		"email":       ddbattr.S(d.Email),
+		"user_name":   ddbattr.S(d.UserName),
+		"status":      ddbattr.S(string(d.Status)),
+		"updated_at":  ddbattr.Time(d.UpdatedAt),
+	}
+	// GSIのキーは空文字にできないため、メッセージIDがない場合は属性を書き込まない
+	if d.MessageID != "" {
+		item["message_id"] = ddbattr.S(d.MessageID)
+	}
+	if !d.LeaseExpiresAt.IsZero() {
+		item["lease_expires_at"] = ddbattr.Time(d.LeaseExpiresAt)
+	}
+	if d.Error != "" {
+		item["error"] = ddbattr.S(d.Error)
+	}
+	return item
+}
+
+// unmarshalDelivery DynamoDBのアイテムを配信状態に変換する
+func unmarshalDelivery(item map[string]types.AttributeValue) (*Delivery, error) {
+	d := &Delivery{
+		CampaignID: ddbattr.String(item, "campaign_id"),
+		Email:      ddbattr.String(item, "email"),
+		UserName:   ddbattr.String(item, "user_name"),
+		Status:     Status(ddbattr.String(item, "status")),
+		MessageID:  ddbattr.String(item, "message_id"),
+		Error:      ddbattr.String(item, "error"),
+	}
+	var err error
+	if d.UpdatedAt, err = ddbattr.ParseTime(item, "updated_at"); err != nil {
+		return nil, err
+	}
+	if d.LeaseExpiresAt, err = ddbattr.ParseTime(item, "lease_expires_at"); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,89 @@
+// Package event はSNS・SQSでやり取りするドメインイベントのエンベロープと、その発行・受信を提供する
+package event
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SNSのサブスクリプションフィルターポリシーで使用するメッセージ属性名
+const (
+	// AttributeEventType イベント種別
+	AttributeEventType = "event_type"
+	// AttributeEventVersion イベントのスキーマのバージョン(Number)
+	AttributeEventVersion = "event_version"
+	// AttributeSource イベントの発行元
+	AttributeSource = "source"
+)
+
+// ErrInvalidEnvelope エンベロープが不正
+var ErrInvalidEnvelope = errors.New("invalid event envelope")
+
+// Envelope ドメインイベントのエンベロープ
+type Envelope struct {
+	// ID イベントID
+	ID string `json:"id"`
+	// Type イベント種別
+	Type string `json:"type"`
+	// Version イベントのスキーマのバージョン
+	Version int `json:"version"`
+	// Source イベントの発行元
+	Source string `json:"source"`
+	// Time イベントの発生日時
+	Time time.Time `json:"time"`
+	// CorrelationID 一連のイベントを関連付けるID(Sagaの実行ID等)
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Data イベント種別ごとのデータ
+	Data json.RawMessage `json:"data"`
+}
+
+// New イベントIDと発生日時を設定したエンベロープを生成する
+func New(eventType string, version int, source string, data any) (Envelope, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("イベントデータの変換エラー: %w", err)
+	}
+	return Envelope{
+		ID:      NewID(),
+		Type:    eventType,
+		Version: version,
+		Source:  source,
+		Time:    time.Now().UTC(),
+		Data:    raw,
+	}, nil
+}
+
+// Validate エンベロープの必須項目を検証する
+func (e Envelope) Validate() error {
+	switch {
+	case e.ID == "":
+		return fmt.Errorf("%w: id is required", ErrInvalidEnvelope)
+	case e.Type == "":
+		return fmt.Errorf("%w: type is required", ErrInvalidEnvelope)
+	case e.Version <= 0:
+		return fmt.Errorf("%w: version must be positive", ErrInvalidEnvelope)
+	case len(e.Data) == 0:
+		return fmt.Errorf("%w: data is required", ErrInvalidEnvelope)
+	}
+	return nil
+}
+
+// DecodeData イベントのデータをvに変換する
+func (e Envelope) DecodeData(v any) error {
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		return fmt.Errorf("%w: %s のデータの変換エラー: %w", ErrInvalidEnvelope, e.Type, err)
+	}
+	return nil
+}
+
+// NewID ランダムなイベントID(UUID v4形式)を生成する
+func NewID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
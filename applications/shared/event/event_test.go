@@ -0,0 +1,94 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+func TestFromSQSMessage(t *testing.T) {
+	env, err := New("PaymentProcessed", 1, "payment-service", map[string]string{"order_id": "order-1"})
+	if err != nil {
+		t.Fatalf("New() returned an error: %v", err)
+	}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Marshal() returned an error: %v", err)
+	}
+	notification, err := json.Marshal(events.SNSEntity{Type: "Notification", MessageID: "msg-1", Message: string(raw)})
+	if err != nil {
+		t.Fatalf("Marshal() returned an error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "sns notification", body: string(notification)},
+		{name: "raw message delivery", body: string(raw)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromSQSMessage(events.SQSMessage{Body: tt.body})
+			if err != nil {
+				t.Fatalf("FromSQSMessage() returned an error: %v", err)
+			}
+			if got.ID != env.ID || got.Type != env.Type || string(got.Data) != string(env.Data) {
+				t.Errorf("FromSQSMessage() = %+v, want %+v", got, env)
+			}
+		})
+	}
+}
+
+func TestFromSQSMessageInvalid(t *testing.T) {
+	for _, body := range []string{"not json", `{"type":"PaymentProcessed"}`} {
+		if _, err := FromSQSMessage(events.SQSMessage{Body: body}); !errors.Is(err, ErrInvalidEnvelope) {
+			t.Errorf("FromSQSMessage(%q) error = %v, want %v", body, err, ErrInvalidEnvelope)
+		}
+	}
+}
+
+// fakeSNS 発行の入力を保持するSNSクライアント
+type fakeSNS struct {
+	inputs []*sns.PublishInput
+}
+
+func (f *fakeSNS) Publish(_ context.Context, params *sns.PublishInput, _ ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.inputs = append(f.inputs, params)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestSNSPublisherPublish(t *testing.T) {
+	env, err := New("FileUploaded", 2, "publisher", map[string]string{"file_name": "a.txt"})
+	if err != nil {
+		t.Fatalf("New() returned an error: %v", err)
+	}
+	client := &fakeSNS{}
+	if err := NewSNSPublisher(client, "arn:aws:sns:ap-northeast-1:123456789012:topic").Publish(context.Background(), env); err != nil {
+		t.Fatalf("Publish() returned an error: %v", err)
+	}
+	if len(client.inputs) != 1 {
+		t.Fatalf("Publish called %d times, want 1", len(client.inputs))
+	}
+
+	// フィルターポリシー用のメッセージ属性を設定すること
+	want := map[string]string{AttributeEventType: "FileUploaded", AttributeEventVersion: "2", AttributeSource: "publisher"}
+	for name, value := range want {
+		attr, ok := client.inputs[0].MessageAttributes[name]
+		if !ok || aws.ToString(attr.StringValue) != value {
+			t.Errorf("MessageAttributes[%s] = %v, want %s", name, aws.ToString(attr.StringValue), value)
+		}
+	}
+
+	// 本文のエンベロープはSQSメッセージから取り出せること
+	got, err := FromSQSMessage(events.SQSMessage{Body: aws.ToString(client.inputs[0].Message)})
+	if err != nil || got.ID != env.ID {
+		t.Errorf("FromSQSMessage() = %+v, %v, want id %s", got, err, env.ID)
+	}
+}
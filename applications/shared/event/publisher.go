@@ -0,0 +1,76 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// Publisher イベントの発行
+type Publisher interface {
+	Publish(ctx context.Context, env Envelope) error
+}
+
+// SNSAPI SNSPublisherが使用するSNSクライアントのメソッド
+type SNSAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// SNSPublisher SNSトピックにイベントを発行する
+type SNSPublisher struct {
+	client   SNSAPI
+	topicArn string
+}
+
+// NewSNSPublisher SNSPublisherを生成する
+func NewSNSPublisher(client SNSAPI, topicArn string) *SNSPublisher {
+	return &SNSPublisher{client: client, topicArn: topicArn}
+}
+
+// Publish エンベロープをJSONでSNSトピックに発行する。イベント種別・バージョン・発行元はフィルターポリシー用のメッセージ属性にも設定する
+func (p *SNSPublisher) Publish(ctx context.Context, env Envelope) error {
+	message, err := json.Marshal(env)
+	if err != nil {
+		return fmt.Errorf("イベントの変換エラー: %w", err)
+	}
+	_, err = p.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(p.topicArn),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			AttributeEventType:    {DataType: aws.String("String"), StringValue: aws.String(env.Type)},
+			AttributeEventVersion: {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(env.Version))},
+			AttributeSource:       {DataType: aws.String("String"), StringValue: aws.String(env.Source)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("イベントの発行エラー: %w", err)
+	}
+	return nil
+}
+
+// MemoryPublisher テスト・ローカル実行用に発行したイベントを保持する
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []Envelope
+}
+
+// Publish イベントを保持する
+func (p *MemoryPublisher) Publish(_ context.Context, env Envelope) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, env)
+	return nil
+}
+
+// Events 発行されたイベントを発行順に返す
+func (p *MemoryPublisher) Events() []Envelope {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Envelope(nil), p.events...)
+}
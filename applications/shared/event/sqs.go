@@ -0,0 +1,38 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// FromSQSMessage SQSメッセージからエンベロープを取り出す。
+// SNSから配信されたメッセージはSNSの通知を展開し、raw message delivery の場合は本文をそのまま使用する
+func FromSQSMessage(msg events.SQSMessage) (Envelope, error) {
+	body := msg.Body
+
+	var notification events.SNSEntity
+	if err := json.Unmarshal([]byte(body), &notification); err == nil && notification.Type == "Notification" {
+		body = notification.Message
+	}
+
+	var env Envelope
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		return Envelope{}, fmt.Errorf("%w: %w", ErrInvalidEnvelope, err)
+	}
+	if err := env.Validate(); err != nil {
+		return Envelope{}, err
+	}
+	return env, nil
+}
+
+// ReceiveCount SQSメッセージの受信回数を返す。1回目の受信は1
+func ReceiveCount(msg events.SQSMessage) int {
+	n, err := strconv.Atoi(msg.Attributes["ApproximateReceiveCount"])
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
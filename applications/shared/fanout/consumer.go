@@ -0,0 +1,82 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/sqsbatch"
+)
+
+// DedupTTL 処理済みのイベントIDを保持する期間。SQSのメッセージ保持期間の最大値(14日)に合わせ、再配信されうる間は重複を検出する
+const DedupTTL = 14 * 24 * time.Hour
+
+// ErrUndecodable メッセージからイベントを取り出せない(エンベロープ・データが不正、または未対応のバージョン)
+var ErrUndecodable = errors.New("undecodable fan-out message")
+
+// Handler イベント種別ごとの処理
+type Handler func(ctx context.Context, env event.Envelope) error
+
+// On スキーマに変換したデータを受け取る処理をHandlerにする
+func On[T Data](fn func(ctx context.Context, env event.Envelope, data T) error) Handler {
+	return func(ctx context.Context, env event.Envelope) error {
+		data, err := Decode[T](env)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrUndecodable, err)
+		}
+		return fn(ctx, env, data)
+	}
+}
+
+// Consumer SQSメッセージからイベントを取り出し、イベント種別ごとのハンドラーに振り分ける
+type Consumer struct {
+	name     string
+	handlers map[string]Handler
+	// Dedup 処理済みのイベントIDの記録。コンシューマー名とイベントIDの組で記録するため、
+	// 同じイベントを受信する他のコンシューマーとは独立して重複を判定する。nilの場合は重複を判定しない
+	Dedup *idempotency.Guard
+}
+
+// NewConsumer Consumerを生成する。nameはログに出力し、重複の判定の範囲とするコンシューマー名
+func NewConsumer(name string, handlers map[string]Handler) *Consumer {
+	return &Consumer{name: name, handlers: handlers}
+}
+
+// HandleMessage SNSの通知(raw message deliveryを含む)からエンベロープを取り出し、イベント種別のハンドラーを実行する。
+// 取り出せないメッセージはErrUndecodableを返す。ハンドラーのないイベント種別は何もしない
+func (c *Consumer) HandleMessage(ctx context.Context, msg events.SQSMessage) error {
+	env, err := event.FromSQSMessage(msg)
+	if err != nil {
+		log.Printf("イベントを取り出せないメッセージを受信しました: consumer=%s, message_id=%s, body=%q, error=%v", c.name, msg.MessageId, msg.Body, err)
+		return fmt.Errorf("%w: message_id=%s: %w", ErrUndecodable, msg.MessageId, err)
+	}
+
+	handler, ok := c.handlers[env.Type]
+	if !ok {
+		log.Printf("対象外のイベントのためスキップ: consumer=%s, type=%s, id=%s", c.name, env.Type, env.ID)
+		return nil
+	}
+	// SNS・SQSは少なくとも1回の配信のため、処理済みのイベントIDは処理しない。失敗した場合は記録を残さずに再配信で再実行する
+	_, err = idempotency.Do(ctx, c.Dedup, c.name, env.ID, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, handler(ctx, env)
+	})
+	if err != nil {
+		if errors.Is(err, ErrUndecodable) {
+			log.Printf("イベントデータを変換できないメッセージを受信しました: consumer=%s, message_id=%s, type=%s, id=%s, version=%d, error=%v", c.name, msg.MessageId, env.Type, env.ID, env.Version, err)
+		}
+		return fmt.Errorf("イベントの処理エラー: type=%s, id=%s: %w", env.Type, env.ID, err)
+	}
+
+	log.Printf("イベントの処理完了: consumer=%s, type=%s, id=%s", c.name, env.Type, env.ID)
+	return nil
+}
+
+// Handle SQSイベントの各メッセージを処理し、失敗したメッセージだけをSQSの再配信(最終的にデッドレターキュー)に委ねる部分バッチレスポンスを返す
+func (c *Consumer) Handle(ctx context.Context, sqsEvent events.SQSEvent) events.SQSEventResponse {
+	return sqsbatch.Process(ctx, sqsEvent, c.HandleMessage)
+}
@@ -0,0 +1,86 @@
+// Package fanout はファンアウト用のSNSトピックで配信するイベントのスキーマと、
+// SQS経由で受信したイベントをイベント種別ごとのハンドラーに振り分けるコンシューマーを提供する
+package fanout
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+)
+
+// イベント種別
+const (
+	// EventUserRegistered ユーザー登録
+	EventUserRegistered = "UserRegistered"
+	// EventFileUploaded ファイルのアップロード
+	EventFileUploaded = "FileUploaded"
+)
+
+// DataVersion イベントデータのスキーマのバージョン
+const DataVersion = 1
+
+var (
+	// ErrInvalidData イベントデータが不正
+	ErrInvalidData = errors.New("invalid fan-out event data")
+	// ErrUnsupportedVersion 対応していないスキーマのバージョン
+	ErrUnsupportedVersion = errors.New("unsupported fan-out event version")
+)
+
+// Data イベントデータのスキーマ
+type Data interface {
+	// Validate 必須項目を検証する
+	Validate() error
+}
+
+// UserRegistered ユーザー登録イベントのデータ
+type UserRegistered struct {
+	UserID   int64  `json:"user_id"`
+	UserName string `json:"user_name"`
+	Email    string `json:"email"`
+}
+
+// Validate 必須項目を検証する
+func (d UserRegistered) Validate() error {
+	switch {
+	case d.UserID <= 0:
+		return fmt.Errorf("%w: user_id must be positive", ErrInvalidData)
+	case d.UserName == "":
+		return fmt.Errorf("%w: user_name is required", ErrInvalidData)
+	case d.Email == "":
+		return fmt.Errorf("%w: email is required", ErrInvalidData)
+	}
+	return nil
+}
+
+// FileUploaded ファイルのアップロードイベントのデータ
+type FileUploaded struct {
+	BucketName string `json:"bucket_name"`
+	FileName   string `json:"file_name"`
+}
+
+// Validate 必須項目を検証する
+func (d FileUploaded) Validate() error {
+	switch {
+	case d.BucketName == "":
+		return fmt.Errorf("%w: bucket_name is required", ErrInvalidData)
+	case d.FileName == "":
+		return fmt.Errorf("%w: file_name is required", ErrInvalidData)
+	}
+	return nil
+}
+
+// Decode エンベロープのバージョンを確認し、データをスキーマに変換して検証する
+func Decode[T Data](env event.Envelope) (T, error) {
+	var data T
+	if env.Version != DataVersion {
+		return data, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, env.Type, env.Version)
+	}
+	if err := env.DecodeData(&data); err != nil {
+		return data, err
+	}
+	if err := data.Validate(); err != nil {
+		return data, err
+	}
+	return data, nil
+}
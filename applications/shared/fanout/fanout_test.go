@@ -0,0 +1,236 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+)
+
+// newMessage エンベロープをSQSメッセージにする。rawがfalseの場合はSNSの通知で包む
+func newMessage(t *testing.T, env event.Envelope, raw bool) events.SQSMessage {
+	t.Helper()
+	body, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("Marshal() returned an error: %v", err)
+	}
+	if !raw {
+		body, err = json.Marshal(events.SNSEntity{Type: "Notification", MessageID: "sns-1", Message: string(body)})
+		if err != nil {
+			t.Fatalf("Marshal() returned an error: %v", err)
+		}
+	}
+	return events.SQSMessage{MessageId: "msg-" + env.ID, Body: string(body)}
+}
+
+func newEnvelope(t *testing.T, eventType string, version int, data any) event.Envelope {
+	t.Helper()
+	env, err := event.New(eventType, version, "test", data)
+	if err != nil {
+		t.Fatalf("New() returned an error: %v", err)
+	}
+	return env
+}
+
+func TestConsumerHandleMessage(t *testing.T) {
+	var uploaded []FileUploaded
+	consumer := NewConsumer("test", map[string]Handler{
+		EventFileUploaded: On(func(_ context.Context, _ event.Envelope, data FileUploaded) error {
+			uploaded = append(uploaded, data)
+			return nil
+		}),
+	})
+	file := FileUploaded{BucketName: "bucket", FileName: "mail-body.txt"}
+
+	tests := []struct {
+		name    string
+		msg     events.SQSMessage
+		wantErr error
+		want    int
+	}{
+		{name: "sns notification", msg: newMessage(t, newEnvelope(t, EventFileUploaded, DataVersion, file), false), want: 1},
+		{name: "raw message delivery", msg: newMessage(t, newEnvelope(t, EventFileUploaded, DataVersion, file), true), want: 1},
+		{name: "not subscribed", msg: newMessage(t, newEnvelope(t, EventUserRegistered, DataVersion, UserRegistered{}), false), want: 0},
+		{name: "not json", msg: events.SQSMessage{MessageId: "msg-1", Body: "not json"}, wantErr: ErrUndecodable},
+		{name: "unsupported version", msg: newMessage(t, newEnvelope(t, EventFileUploaded, DataVersion+1, file), false), wantErr: ErrUnsupportedVersion},
+		{name: "invalid data", msg: newMessage(t, newEnvelope(t, EventFileUploaded, DataVersion, FileUploaded{BucketName: "bucket"}), false), wantErr: ErrInvalidData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploaded = nil
+			err := consumer.HandleMessage(context.Background(), tt.msg)
+			if tt.wantErr != nil {
+				// 取り出せないメッセージは全てErrUndecodableとして報告すること
+				if !errors.Is(err, tt.wantErr) || !errors.Is(err, ErrUndecodable) {
+					t.Fatalf("HandleMessage() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HandleMessage() returned an error: %v", err)
+			}
+			if len(uploaded) != tt.want {
+				t.Fatalf("handled %d events, want %d", len(uploaded), tt.want)
+			}
+			if tt.want > 0 && uploaded[0] != file {
+				t.Errorf("data = %+v, want %+v", uploaded[0], file)
+			}
+		})
+	}
+}
+
+func TestConsumerHandleMessageHandlerError(t *testing.T) {
+	wantErr := errors.New("boom")
+	consumer := NewConsumer("test", map[string]Handler{
+		EventUserRegistered: On(func(context.Context, event.Envelope, UserRegistered) error { return wantErr }),
+	})
+	user := UserRegistered{UserID: 1, UserName: "user", Email: "user@example.com"}
+
+	err := consumer.HandleMessage(context.Background(), newMessage(t, newEnvelope(t, EventUserRegistered, DataVersion, user), false))
+	if !errors.Is(err, wantErr) || errors.Is(err, ErrUndecodable) {
+		t.Errorf("HandleMessage() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestConsumerHandlePartialBatchFailure(t *testing.T) {
+	var handled []string
+	consumer := NewConsumer("test", map[string]Handler{
+		EventFileUploaded: On(func(_ context.Context, _ event.Envelope, data FileUploaded) error {
+			handled = append(handled, data.FileName)
+			return nil
+		}),
+	})
+	ok := newMessage(t, newEnvelope(t, EventFileUploaded, DataVersion, FileUploaded{BucketName: "bucket", FileName: "a.txt"}), false)
+	undecodable := events.SQSMessage{MessageId: "msg-undecodable", Body: "not json"}
+	invalid := newMessage(t, newEnvelope(t, EventFileUploaded, DataVersion, FileUploaded{FileName: "b.txt"}), true)
+	raw := newMessage(t, newEnvelope(t, EventFileUploaded, DataVersion, FileUploaded{BucketName: "bucket", FileName: "c.txt"}), true)
+
+	resp := consumer.Handle(context.Background(), events.SQSEvent{Records: []events.SQSMessage{ok, undecodable, invalid, raw}})
+
+	// 取り出せないメッセージだけを失敗として報告し、他のメッセージは処理すること
+	want := []events.SQSBatchItemFailure{{ItemIdentifier: undecodable.MessageId}, {ItemIdentifier: invalid.MessageId}}
+	if !reflect.DeepEqual(resp.BatchItemFailures, want) {
+		t.Errorf("BatchItemFailures = %v, want %v", resp.BatchItemFailures, want)
+	}
+	if !reflect.DeepEqual(handled, []string{"a.txt", "c.txt"}) {
+		t.Errorf("handled = %v, want [a.txt c.txt]", handled)
+	}
+}
+
+func TestPublisherPublish(t *testing.T) {
+	memory := &event.MemoryPublisher{}
+	publisher := NewPublisher(memory, "test-publisher")
+
+	env, err := publisher.Publish(context.Background(), PublishInput{
+		Type:          EventUserRegistered,
+		CorrelationID: "request-1",
+		Data:          json.RawMessage(`{"user_id": 1, "user_name": "user", "email": "user@example.com"}`),
+	})
+	if err != nil {
+		t.Fatalf("Publish() returned an error: %v", err)
+	}
+	if env.ID == "" || env.Time.IsZero() || env.Source != "test-publisher" || env.Version != DataVersion || env.CorrelationID != "request-1" {
+		t.Errorf("Publish() = %+v, want stamped envelope", env)
+	}
+
+	// 発行したイベントをコンシューマーが同じスキーマで受け取れること
+	var got UserRegistered
+	consumer := NewConsumer("test", map[string]Handler{
+		EventUserRegistered: On(func(_ context.Context, _ event.Envelope, data UserRegistered) error {
+			got = data
+			return nil
+		}),
+	})
+	published := memory.Events()
+	if len(published) != 1 {
+		t.Fatalf("published %d events, want 1", len(published))
+	}
+	if err := consumer.HandleMessage(context.Background(), newMessage(t, published[0], false)); err != nil {
+		t.Fatalf("HandleMessage() returned an error: %v", err)
+	}
+	if want := (UserRegistered{UserID: 1, UserName: "user", Email: "user@example.com"}); got != want {
+		t.Errorf("data = %+v, want %+v", got, want)
+	}
+}
+
+func TestPublisherPublishInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      PublishInput
+		wantErr error
+	}{
+		{name: "unknown type", in: PublishInput{Type: "Unknown", Data: json.RawMessage(`{}`)}, wantErr: ErrUnknownEventType},
+		{name: "missing data", in: PublishInput{Type: EventFileUploaded}, wantErr: ErrInvalidData},
+		{name: "unknown field", in: PublishInput{Type: EventFileUploaded, Data: json.RawMessage(`{"bucket_name": "b", "file_name": "f", "extra": 1}`)}, wantErr: ErrInvalidData},
+		{name: "wrong type", in: PublishInput{Type: EventUserRegistered, Data: json.RawMessage(`{"user_id": "1", "user_name": "u", "email": "e"}`)}, wantErr: ErrInvalidData},
+		{name: "required field", in: PublishInput{Type: EventFileUploaded, Data: json.RawMessage(`{"bucket_name": "b"}`)}, wantErr: ErrInvalidData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memory := &event.MemoryPublisher{}
+			if _, err := NewPublisher(memory, "test").Publish(context.Background(), tt.in); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Publish() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(memory.Events()) != 0 {
+				t.Errorf("published %d events, want 0", len(memory.Events()))
+			}
+		})
+	}
+}
+
+func TestConsumerDedup(t *testing.T) {
+	// 同じストアを共有しても、コンシューマーごとに独立して重複を判定すること
+	store := idempotency.NewMemoryStore()
+	counts := make(map[string]int)
+	newConsumer := func(name string, fail *bool) *Consumer {
+		c := NewConsumer(name, map[string]Handler{
+			EventFileUploaded: On(func(context.Context, event.Envelope, FileUploaded) error {
+				if fail != nil && *fail {
+					return errors.New("failed")
+				}
+				counts[name]++
+				return nil
+			}),
+		})
+		c.Dedup = idempotency.NewGuard(store, DedupTTL)
+		return c
+	}
+	fail := true
+	consumer1 := newConsumer("consumer-1", nil)
+	consumer2 := newConsumer("consumer-2", &fail)
+
+	env := newEnvelope(t, EventFileUploaded, DataVersion, FileUploaded{BucketName: "bucket", FileName: "a.txt"})
+	// SNSの再配信では本文が同じでもSQSのメッセージIDは異なる
+	first, duplicate := newMessage(t, env, false), newMessage(t, env, true)
+	duplicate.MessageId = "msg-duplicate"
+
+	for _, msg := range []events.SQSMessage{first, duplicate} {
+		if err := consumer1.HandleMessage(context.Background(), msg); err != nil {
+			t.Fatalf("consumer-1 HandleMessage() returned an error: %v", err)
+		}
+	}
+	if counts["consumer-1"] != 1 {
+		t.Errorf("consumer-1 handled %d times, want 1", counts["consumer-1"])
+	}
+
+	// 失敗した場合は処理済みとせず、再配信で処理すること
+	if err := consumer2.HandleMessage(context.Background(), first); err == nil {
+		t.Fatal("consumer-2 HandleMessage() returned no error")
+	}
+	fail = false
+	for _, msg := range []events.SQSMessage{first, duplicate} {
+		if err := consumer2.HandleMessage(context.Background(), msg); err != nil {
+			t.Fatalf("consumer-2 HandleMessage() returned an error: %v", err)
+		}
+	}
+	if counts["consumer-2"] != 1 {
+		t.Errorf("consumer-2 handled %d times, want 1", counts["consumer-2"])
+	}
+}
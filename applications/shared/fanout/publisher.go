@@ -0,0 +1,69 @@
+package fanout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+)
+
+// ErrUnknownEventType スキーマが定義されていないイベント種別
+var ErrUnknownEventType = errors.New("unknown fan-out event type")
+
+// schemas イベント種別ごとのデータのスキーマ
+var schemas = map[string]func() Data{
+	EventUserRegistered: func() Data { return &UserRegistered{} },
+	EventFileUploaded:   func() Data { return &FileUploaded{} },
+}
+
+// PublishInput 発行するイベント。イベントID・発生日時・発行元・バージョンは発行時に設定する
+type PublishInput struct {
+	Type          string          `json:"type"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// Publisher イベントをスキーマで検証してファンアウト用のSNSトピックに発行する
+type Publisher struct {
+	publisher event.Publisher
+	source    string
+}
+
+// NewPublisher Publisherを生成する。sourceはエンベロープに設定する発行元
+func NewPublisher(publisher event.Publisher, source string) *Publisher {
+	return &Publisher{publisher: publisher, source: source}
+}
+
+// Publish データをイベント種別のスキーマに変換して検証し、エンベロープに包んで発行する。
+// スキーマにない項目を含むデータは不正とする
+func (p *Publisher) Publish(ctx context.Context, in PublishInput) (event.Envelope, error) {
+	newData, ok := schemas[in.Type]
+	if !ok {
+		return event.Envelope{}, fmt.Errorf("%w: %q", ErrUnknownEventType, in.Type)
+	}
+	data := newData()
+	decoder := json.NewDecoder(bytes.NewReader(in.Data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(data); err != nil {
+		return event.Envelope{}, fmt.Errorf("%w: %s: %w", ErrInvalidData, in.Type, err)
+	}
+	if err := data.Validate(); err != nil {
+		return event.Envelope{}, err
+	}
+
+	env, err := event.New(in.Type, DataVersion, p.source, data)
+	if err != nil {
+		return event.Envelope{}, err
+	}
+	env.CorrelationID = in.CorrelationID
+	if err := p.publisher.Publish(ctx, env); err != nil {
+		return event.Envelope{}, err
+	}
+
+	log.Printf("イベントの発行完了: type=%s, id=%s", env.Type, env.ID)
+	return env, nil
+}
@@ -0,0 +1,137 @@
+// Package idempotency はステップ名と冪等キーの組で処理の重複実行を防ぎ、
+// 重複した呼び出しには最初の実行結果を返す仕組みを提供する
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Status 冪等キーの処理状態
+type Status string
+
+const (
+	StatusInProgress Status = "IN_PROGRESS"
+	StatusCompleted  Status = "COMPLETED"
+)
+
+const (
+	// DefaultTTL 完了した処理の応答を保持する期間の既定値
+	DefaultTTL = 24 * time.Hour
+	// DefaultLeaseDuration 実行中の記録の有効期間の既定値(Lambdaの最大実行時間)。
+	// 実行中にLambdaが異常終了した場合も、期間を過ぎれば再実行できる
+	DefaultLeaseDuration = 15 * time.Minute
+)
+
+var (
+	// ErrAlreadyExists 有効な記録が既に存在する
+	ErrAlreadyExists = errors.New("冪等キーの記録が既に存在します")
+	// ErrInProgress 同じ冪等キーの処理が実行中
+	ErrInProgress = errors.New("同じ冪等キーの処理が実行中です")
+	// ErrLeaseLost 実行中の有効期限を過ぎ、記録が他の呼び出しに引き継がれた
+	ErrLeaseLost = errors.New("冪等キーの記録が他の呼び出しに引き継がれました")
+)
+
+// Record 冪等キーの処理状態と応答の記録
+type Record struct {
+	Step string
+	Key  string
+	// Status 処理状態
+	Status Status
+	// Response 完了した処理の応答(JSON)
+	Response json.RawMessage
+	// LeaseExpiresAt 実行中の記録の有効期限
+	LeaseExpiresAt time.Time
+	// ExpiresAt 記録の有効期限(DynamoDBのTTL)
+	ExpiresAt time.Time
+}
+
+// active 記録が有効(処理済みまたは実行中)かどうかを返す
+func (r *Record) active(now time.Time) bool {
+	if !now.Before(r.ExpiresAt) {
+		return false
+	}
+	return r.Status == StatusCompleted || now.Before(r.LeaseExpiresAt)
+}
+
+// Guard 冪等キーによる重複実行の防止
+type Guard struct {
+	store Store
+	ttl   time.Duration
+	lease time.Duration
+	now   func() time.Time
+}
+
+// NewGuard Guardを生成する。ttlが0以下の場合は既定値
+func NewGuard(store Store, ttl time.Duration) *Guard {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Guard{store: store, ttl: ttl, lease: DefaultLeaseDuration, now: time.Now}
+}
+
+// Do ステップ名と冪等キーの組で一度だけfnを実行する。
+// 完了済みの場合はfnを実行せずに保存済みの応答を返し、実行中の場合はErrInProgressを返す。
+// fnが失敗した場合は記録を削除し、リトライで再実行できるようにする。
+// 実行中の有効期限を過ぎて他の呼び出しに引き継がれた記録は削除・上書きしない。gがnilの場合は常にfnを実行する
+func Do[T any](ctx context.Context, g *Guard, step, key string, fn func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if g == nil {
+		return fn(ctx)
+	}
+
+	// Lambdaの実行期限を過ぎた実行中の記録は異常終了とみなせるため、期限を実行中の有効期限とする
+	now := g.now()
+	leaseExpiresAt := now.Add(g.lease)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(leaseExpiresAt) {
+		leaseExpiresAt = deadline
+	}
+	rec := Record{
+		Step:           step,
+		Key:            key,
+		Status:         StatusInProgress,
+		LeaseExpiresAt: leaseExpiresAt,
+		ExpiresAt:      now.Add(g.ttl),
+	}
+	existing, err := g.store.Begin(ctx, rec, now)
+	if errors.Is(err, ErrAlreadyExists) {
+		if existing.Status != StatusCompleted {
+			return zero, fmt.Errorf("%w: step=%s, key=%s", ErrInProgress, step, key)
+		}
+		var out T
+		if err := json.Unmarshal(existing.Response, &out); err != nil {
+			return zero, fmt.Errorf("保存済みの応答の変換エラー: %w", err)
+		}
+		log.Printf("重複した呼び出しのため保存済みの応答を返します: step=%s, key=%s", step, key)
+		return out, nil
+	}
+	if err != nil {
+		return zero, fmt.Errorf("冪等キーの登録エラー: %w", err)
+	}
+
+	out, err := fn(ctx)
+	if err != nil {
+		if delErr := g.store.Delete(context.WithoutCancel(ctx), step, key, leaseExpiresAt); delErr != nil {
+			log.Printf("冪等キーの記録の削除に失敗しました: step=%s, key=%s, error=%v", step, key, delErr)
+		}
+		return out, err
+	}
+
+	response, err := json.Marshal(out)
+	if err != nil {
+		return zero, fmt.Errorf("応答の変換エラー: %w", err)
+	}
+	rec.Status = StatusCompleted
+	rec.Response = response
+	rec.LeaseExpiresAt = time.Time{}
+	rec.ExpiresAt = g.now().Add(g.ttl)
+	if err := g.store.Complete(context.WithoutCancel(ctx), rec, leaseExpiresAt); err != nil {
+		// 処理自体は完了しているため応答を返す。記録は実行中の有効期限を過ぎると再実行可能になる
+		log.Printf("冪等キーの完了の記録に失敗しました: step=%s, key=%s, error=%v", step, key, err)
+	}
+	return out, nil
+}
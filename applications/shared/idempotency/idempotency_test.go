@@ -0,0 +1,167 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type response struct {
+	ID    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+// newTestGuard 現在時刻を差し替えられるGuardを生成する
+func newTestGuard(now *time.Time) *Guard {
+	g := NewGuard(NewMemoryStore(), time.Hour)
+	g.now = func() time.Time { return *now }
+	return g
+}
+
+func TestDoReturnsCachedResponse(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+
+	calls := 0
+	fn := func(context.Context) (*response, error) {
+		calls++
+		return &response{ID: "pay-1", Count: calls}, nil
+	}
+
+	first, err := Do(context.Background(), g, "process-payment", "order-1", fn)
+	if err != nil {
+		t.Fatalf("Do() returned an error: %v", err)
+	}
+	second, err := Do(context.Background(), g, "process-payment", "order-1", fn)
+	if err != nil {
+		t.Fatalf("Do() returned an error: %v", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("calls = %d, want %d", calls, 1)
+	}
+	if *second != *first {
+		t.Errorf("second response = %+v, want %+v", second, first)
+	}
+
+	// ステップが異なれば別の処理として実行されること
+	if _, err := Do(context.Background(), g, "award-points", "order-1", fn); err != nil {
+		t.Fatalf("Do() returned an error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want %d", calls, 2)
+	}
+
+	// 有効期限を過ぎた場合は再実行されること
+	now = now.Add(2 * time.Hour)
+	if _, err := Do(context.Background(), g, "process-payment", "order-1", fn); err != nil {
+		t.Fatalf("Do() returned an error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want %d", calls, 3)
+	}
+}
+
+func TestDoRejectsConcurrentInvocation(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+
+	var inner error
+	_, err := Do(context.Background(), g, "process-payment", "order-1", func(ctx context.Context) (*response, error) {
+		// 実行中に同じ冪等キーで呼び出す
+		_, inner = Do(ctx, g, "process-payment", "order-1", func(context.Context) (*response, error) {
+			t.Error("duplicate invocation was executed")
+			return nil, nil
+		})
+		return &response{ID: "pay-1"}, nil
+	})
+	if err != nil {
+		t.Fatalf("Do() returned an error: %v", err)
+	}
+	if !errors.Is(inner, ErrInProgress) {
+		t.Errorf("inner Do() error = %v, want %v", inner, ErrInProgress)
+	}
+}
+
+func TestDoRetriesAfterLeaseExpires(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+
+	// 実行中のままLambdaが異常終了した状態
+	if _, err := g.store.Begin(context.Background(), Record{
+		Step:           "process-payment",
+		Key:            "order-1",
+		Status:         StatusInProgress,
+		LeaseExpiresAt: now.Add(DefaultLeaseDuration),
+		ExpiresAt:      now.Add(time.Hour),
+	}, now); err != nil {
+		t.Fatalf("Begin() returned an error: %v", err)
+	}
+
+	now = now.Add(DefaultLeaseDuration)
+	got, err := Do(context.Background(), g, "process-payment", "order-1", func(context.Context) (*response, error) {
+		return &response{ID: "pay-1"}, nil
+	})
+	if err != nil {
+		t.Fatalf("Do() returned an error: %v", err)
+	}
+	if got.ID != "pay-1" {
+		t.Errorf("ID = %q, want %q", got.ID, "pay-1")
+	}
+}
+
+func TestDoReleasesKeyOnFailure(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+	errFailed := errors.New("failed")
+
+	if _, err := Do(context.Background(), g, "process-payment", "order-1", func(context.Context) (*response, error) {
+		return nil, errFailed
+	}); !errors.Is(err, errFailed) {
+		t.Fatalf("Do() error = %v, want %v", err, errFailed)
+	}
+
+	// 失敗した処理はリトライで再実行されること
+	calls := 0
+	if _, err := Do(context.Background(), g, "process-payment", "order-1", func(context.Context) (*response, error) {
+		calls++
+		return &response{ID: "pay-1"}, nil
+	}); err != nil {
+		t.Fatalf("Do() returned an error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want %d", calls, 1)
+	}
+}
+
+func TestDoKeepsRecordTakenOverAfterLeaseExpires(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := newTestGuard(&now)
+	errFailed := errors.New("failed")
+
+	if _, err := Do(context.Background(), g, "process-payment", "order-1", func(ctx context.Context) (*response, error) {
+		// 実行中の有効期限を過ぎ、他の呼び出しが記録を引き継いで実行中の状態
+		now = now.Add(DefaultLeaseDuration)
+		if _, err := g.store.Begin(ctx, Record{
+			Step:           "process-payment",
+			Key:            "order-1",
+			Status:         StatusInProgress,
+			LeaseExpiresAt: now.Add(DefaultLeaseDuration),
+			ExpiresAt:      now.Add(time.Hour),
+		}, now); err != nil {
+			t.Fatalf("Begin() returned an error: %v", err)
+		}
+		return nil, errFailed
+	}); !errors.Is(err, errFailed) {
+		t.Fatalf("Do() error = %v, want %v", err, errFailed)
+	}
+
+	// 引き継いだ呼び出しの記録は削除されず、3つ目の呼び出しは実行されないこと
+	if _, err := Do(context.Background(), g, "process-payment", "order-1", func(context.Context) (*response, error) {
+		t.Error("invocation was executed while another one was in progress")
+		return nil, nil
+	}); !errors.Is(err, ErrInProgress) {
+		t.Errorf("Do() error = %v, want %v", err, ErrInProgress)
+	}
+}
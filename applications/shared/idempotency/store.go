@@ -0,0 +1,219 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/internal/ddbattr"
+)
+
+// Store 冪等キーの記録の永続化
+type Store interface {
+	// Begin 有効な記録がない場合のみ実行中の記録を作成する。
+	// 有効な記録が存在する場合はその記録とErrAlreadyExistsを返す
+	Begin(ctx context.Context, rec Record, now time.Time) (*Record, error)
+	// Complete 実行中で有効期限がleaseExpiresAtの(Beginした呼び出しの)記録の場合のみ、完了した記録(応答を含む)を保存する。
+	// 実行中の有効期限を過ぎて他の呼び出しに引き継がれた場合はErrLeaseLostを返す
+	Complete(ctx context.Context, rec Record, leaseExpiresAt time.Time) error
+	// Delete 実行中で有効期限がleaseExpiresAtの(Beginした呼び出しの)記録の場合のみ削除する。
+	// 実行中の有効期限を過ぎて他の呼び出しに引き継がれた場合はErrLeaseLostを返す
+	Delete(ctx context.Context, step, key string, leaseExpiresAt time.Time) error
+}
+
+// recordID ステップ名と冪等キーから記録のIDを生成する
+func recordID(step, key string) string {
+	return step + "#" + key
+}
+
+// MemoryStore テスト・ローカル実行用のインメモリストア
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+// Begin 有効な記録がない場合のみ実行中の記録を作成する
+func (s *MemoryStore) Begin(_ context.Context, rec Record, now time.Time) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := recordID(rec.Step, rec.Key)
+	if existing, ok := s.records[id]; ok && existing.active(now) {
+		return &existing, ErrAlreadyExists
+	}
+	s.records[id] = rec
+	return nil, nil
+}
+
+// Complete Beginした呼び出しの実行中の記録の場合のみ完了した記録を保存する
+func (s *MemoryStore) Complete(_ context.Context, rec Record, leaseExpiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := recordID(rec.Step, rec.Key)
+	if !s.owned(id, leaseExpiresAt) {
+		return ErrLeaseLost
+	}
+	s.records[id] = rec
+	return nil
+}
+
+// Delete Beginした呼び出しの実行中の記録の場合のみ削除する
+func (s *MemoryStore) Delete(_ context.Context, step, key string, leaseExpiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := recordID(step, key)
+	if !s.owned(id, leaseExpiresAt) {
+		return ErrLeaseLost
+	}
+	delete(s.records, id)
+	return nil
+}
+
+// owned 記録が実行中で有効期限がleaseExpiresAtかどうかを返す。呼び出し元でロックを取得していること
+func (s *MemoryStore) owned(id string, leaseExpiresAt time.Time) bool {
+	existing, ok := s.records[id]
+	return ok && existing.Status == StatusInProgress && existing.LeaseExpiresAt.Equal(leaseExpiresAt)
+}
+
+// DynamoDBAPI DynamoDBStoreが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// DynamoDBStore DynamoDBのidempotencyテーブルを使用するストア。
+// expires_at属性をTTLに設定し、期限切れの記録を自動削除する
+type DynamoDBStore struct {
+	client    DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBStore DynamoDBStoreを生成する
+func NewDynamoDBStore(client DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName}
+}
+
+// Begin 記録がない・期限切れ・実行中の有効期限切れの場合のみ実行中の記録を書き込む
+func (s *DynamoDBStore) Begin(ctx context.Context, rec Record, now time.Time) (*Record, error) {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      marshalRecord(rec),
+		// TTLによる削除は即時ではないため、有効期限も条件で判定する
+		ConditionExpression: aws.String("attribute_not_exists(id) OR expires_at <= :now OR (#status = :in_progress AND lease_expires_at <= :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":         ddbattr.Epoch(now),
+			":in_progress": ddbattr.S(string(StatusInProgress)),
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		existing, err := unmarshalRecord(ccf.Item)
+		if err != nil {
+			return nil, err
+		}
+		return existing, ErrAlreadyExists
+	}
+	return nil, err
+}
+
+// Complete Beginした呼び出しの実行中の記録の場合のみ完了した記録を書き込む
+func (s *DynamoDBStore) Complete(ctx context.Context, rec Record, leaseExpiresAt time.Time) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(s.tableName),
+		Item:                      marshalRecord(rec),
+		ConditionExpression:       aws.String(ownedCondition),
+		ExpressionAttributeNames:  ownedNames(),
+		ExpressionAttributeValues: ownedValues(leaseExpiresAt),
+	})
+	return leaseLost(err)
+}
+
+// Delete Beginした呼び出しの実行中の記録の場合のみ削除する
+func (s *DynamoDBStore) Delete(ctx context.Context, step, key string, leaseExpiresAt time.Time) error {
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": ddbattr.S(recordID(step, key)),
+		},
+		ConditionExpression:       aws.String(ownedCondition),
+		ExpressionAttributeNames:  ownedNames(),
+		ExpressionAttributeValues: ownedValues(leaseExpiresAt),
+	})
+	return leaseLost(err)
+}
+
+// ownedCondition 記録がBeginした呼び出しの実行中の記録であることの条件
+const ownedCondition = "#status = :in_progress AND lease_expires_at = :lease"
+
+// ownedNames ownedConditionの属性名
+func ownedNames() map[string]string {
+	return map[string]string{"#status": "status"}
+}
+
+// ownedValues ownedConditionの属性値
+func ownedValues(leaseExpiresAt time.Time) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		":in_progress": ddbattr.S(string(StatusInProgress)),
+		":lease":       ddbattr.Epoch(leaseExpiresAt),
+	}
+}
+
+// leaseLost 条件付き書き込みの条件を満たさなかった場合にErrLeaseLostに変換する
+func leaseLost(err error) error {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// marshalRecord 記録をDynamoDBのアイテムに変換する
+func marshalRecord(rec Record) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"id":              ddbattr.S(recordID(rec.Step, rec.Key)),
+		"step":            ddbattr.S(rec.Step),
+		"idempotency_key": ddbattr.S(rec.Key),
+		"status":          ddbattr.S(string(rec.Status)),
+		"expires_at":      ddbattr.Epoch(rec.ExpiresAt),
+	}
+	if !rec.LeaseExpiresAt.IsZero() {
+		item["lease_expires_at"] = ddbattr.Epoch(rec.LeaseExpiresAt)
+	}
+	if len(rec.Response) > 0 {
+		item["response"] = ddbattr.S(string(rec.Response))
+	}
+	return item
+}
+
+// unmarshalRecord DynamoDBのアイテムを記録に変換する
+func unmarshalRecord(item map[string]types.AttributeValue) (*Record, error) {
+	rec := &Record{
+		Step:   ddbattr.String(item, "step"),
+		Key:    ddbattr.String(item, "idempotency_key"),
+		Status: Status(ddbattr.String(item, "status")),
+	}
+	if response := ddbattr.String(item, "response"); response != "" {
+		rec.Response = []byte(response)
+	}
+	var err error
+	if rec.LeaseExpiresAt, err = ddbattr.ParseEpoch(item, "lease_expires_at"); err != nil {
+		return nil, err
+	}
+	if rec.ExpiresAt, err = ddbattr.ParseEpoch(item, "expires_at"); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
@@ -0,0 +1,84 @@
+// Package ddbattr はDynamoDBアイテムの属性値を読み書きするための補助関数を提供する
+package ddbattr
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// timeLayout 時刻属性の形式。ソートキーとして文字列比較できるよう固定長にする
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// S 文字列属性値を生成する
+func S(v string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: v}
+}
+
+// N 数値属性値を生成する
+func N(v int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)}
+}
+
+// Time 時刻をRFC3339形式(UTC・固定長)の文字列属性値として生成する
+func Time(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: FormatTime(t)}
+}
+
+// FormatTime 時刻をRFC3339形式(UTC・固定長)の文字列に変換する
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// String 文字列属性の値を取得する。存在しない場合は空文字
+func String(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+// Int64 数値属性の値を取得する。存在しない場合は0
+func Int64(item map[string]types.AttributeValue, name string) (int64, error) {
+	v, ok := item[name].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v.Value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%sの変換エラー: %w", name, err)
+	}
+	return n, nil
+}
+
+// ParseTime RFC3339形式の文字列属性を時刻として取得する。存在しない場合はゼロ値
+func ParseTime(item map[string]types.AttributeValue, name string) (time.Time, error) {
+	s := String(item, name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%sの変換エラー: %w", name, err)
+	}
+	return t, nil
+}
+
+// Epoch 時刻をUNIX時間(秒)の数値属性値として生成する。DynamoDBのTTL属性に使用する
+func Epoch(t time.Time) types.AttributeValue {
+	return N(t.Unix())
+}
+
+// ParseEpoch UNIX時間(秒)の数値属性を時刻として取得する。存在しない場合はゼロ値
+func ParseEpoch(item map[string]types.AttributeValue, name string) (time.Time, error) {
+	if _, ok := item[name]; !ok {
+		return time.Time{}, nil
+	}
+	sec, err := Int64(item, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
@@ -0,0 +1,236 @@
+// Package inventory はSagaの在庫引当(reserve-inventory)・引当確定(confirm-inventory)と
+// 補償処理(release-inventory)で使用する在庫と引当を提供する
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+)
+
+// Status 引当のステータス
+type Status string
+
+const (
+	// StatusReserved 引当済み(未確定)。有効期限を過ぎると自動的に解放される
+	StatusReserved Status = "RESERVED"
+	// StatusConfirmed Sagaの完了により確定済み
+	StatusConfirmed Status = "CONFIRMED"
+	// StatusReleased 補償処理または有効期限切れにより解放済み
+	StatusReleased Status = "RELEASED"
+)
+
+// DefaultReservationTTL 引当の有効期限の既定値
+const DefaultReservationTTL = 30 * time.Minute
+
+// maxReservationItems 1つの引当で扱えるSKUの数(DynamoDBトランザクションの上限100件から引当レコードの1件を除く)
+const maxReservationItems = 99
+
+var (
+	// ErrInvalidInput 入力値が不正
+	ErrInvalidInput = errors.New("invalid inventory input")
+	// ErrInsufficientStock 在庫不足
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrNotFound 引当が存在しない
+	ErrNotFound = errors.New("reservation not found")
+	// ErrAlreadyExists 引当が既に存在する
+	ErrAlreadyExists = errors.New("reservation already exists")
+	// ErrReservationExpired 引当が有効期限切れまたは解放済みのため確定できない
+	ErrReservationExpired = errors.New("reservation expired")
+)
+
+// ReserveInventoryInput reserve-inventoryの入力。process-paymentと同じSagaの入力から明細を使用する
+type ReserveInventoryInput struct {
+	OrderID string              `json:"order_id"`
+	UserID  string              `json:"user_id"`
+	Items   []purchase.LineItem `json:"items"`
+}
+
+// Validate 入力値を検証する
+func (in ReserveInventoryInput) Validate() error {
+	switch {
+	case in.OrderID == "":
+		return fmt.Errorf("%w: order_id is required", ErrInvalidInput)
+	case in.UserID == "":
+		return fmt.Errorf("%w: user_id is required", ErrInvalidInput)
+	case len(in.Items) == 0:
+		return fmt.Errorf("%w: items is required", ErrInvalidInput)
+	}
+	for _, item := range in.Items {
+		if item.SKU == "" || item.Quantity <= 0 {
+			return fmt.Errorf("%w: invalid line item %+v", ErrInvalidInput, item)
+		}
+	}
+	return nil
+}
+
+// ConfirmInventoryInput confirm-inventoryの入力
+type ConfirmInventoryInput struct {
+	OrderID string `json:"order_id"`
+}
+
+// ReleaseInventoryInput release-inventoryの入力
+type ReleaseInventoryInput struct {
+	OrderID string `json:"order_id"`
+}
+
+// ReleaseInventoryOutput release-inventoryの出力
+type ReleaseInventoryOutput struct {
+	OrderID string `json:"order_id"`
+	// Released 今回の呼び出しで解放したかどうか
+	Released bool `json:"released"`
+}
+
+// Item 引当明細
+type Item struct {
+	SKU      string `json:"sku"`
+	Quantity int64  `json:"quantity"`
+}
+
+// Reservation 注文の在庫引当
+type Reservation struct {
+	OrderID string `json:"order_id"`
+	UserID  string `json:"user_id"`
+	Items   []Item `json:"items"`
+	Status  Status `json:"status"`
+	// ReservedUntil 引当の有効期限。確定されないまま過ぎると自動的に解放される
+	ReservedUntil time.Time `json:"reserved_until"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Service 在庫引当の操作
+type Service struct {
+	store Store
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewService 在庫引当の操作を生成する。ttlは引当の有効期限で、0以下の場合は既定値
+func NewService(store Store, ttl time.Duration) *Service {
+	if ttl <= 0 {
+		ttl = DefaultReservationTTL
+	}
+	return &Service{store: store, ttl: ttl, now: time.Now}
+}
+
+// Reserve 明細のSKUごとに在庫を減らし、有効期限付きの引当を作成する。
+// 在庫の減算と引当の作成は原子的に行い、1つでも在庫が不足するSKUがあれば何も引き当てない
+func (s *Service) Reserve(ctx context.Context, in ReserveInventoryInput) (*Reservation, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+
+	// 同じSKUの明細は数量をまとめる
+	quantities := make(map[string]int64)
+	for _, item := range in.Items {
+		quantities[item.SKU] += item.Quantity
+	}
+	if len(quantities) > maxReservationItems {
+		return nil, fmt.Errorf("%w: too many skus (max %d)", ErrInvalidInput, maxReservationItems)
+	}
+	items := make([]Item, 0, len(quantities))
+	for sku, quantity := range quantities {
+		items = append(items, Item{SKU: sku, Quantity: quantity})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].SKU < items[j].SKU })
+
+	now := s.now()
+	r := &Reservation{
+		OrderID:       in.OrderID,
+		UserID:        in.UserID,
+		Items:         items,
+		Status:        StatusReserved,
+		ReservedUntil: now.Add(s.ttl),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	err := s.store.Reserve(ctx, r)
+	if errors.Is(err, ErrAlreadyExists) {
+		// リトライ時は既存の引当を返す
+		log.Printf("在庫引当済みのためスキップ: order_id=%s", in.OrderID)
+		return s.store.Get(ctx, in.OrderID)
+	}
+	if errors.Is(err, ErrInsufficientStock) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("在庫引当エラー: %w", err)
+	}
+
+	log.Printf("在庫引当完了: order_id=%s, reserved_until=%s", r.OrderID, r.ReservedUntil.Format(time.RFC3339))
+	return r, nil
+}
+
+// Confirm Sagaの完了時に引当を確定する。確定済みの場合は何もしない。
+// 有効期限切れ・解放済みの場合はErrReservationExpiredを返す
+func (s *Service) Confirm(ctx context.Context, in ConfirmInventoryInput) (*Reservation, error) {
+	if in.OrderID == "" {
+		return nil, fmt.Errorf("%w: order_id is required", ErrInvalidInput)
+	}
+
+	r, err := s.store.Confirm(ctx, in.OrderID, s.now())
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrReservationExpired) {
+		return nil, fmt.Errorf("%w: order_id=%s", err, in.OrderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("在庫引当確定エラー: %w", err)
+	}
+
+	log.Printf("在庫引当確定: order_id=%s", in.OrderID)
+	return r, nil
+}
+
+// Release 補償処理として引当を解放し、在庫を戻す。
+// 何度呼び出しても結果は同じになり、引当が存在しない・解放済み・確定済みの場合は何もしない
+func (s *Service) Release(ctx context.Context, in ReleaseInventoryInput) (*ReleaseInventoryOutput, error) {
+	if in.OrderID == "" {
+		return nil, fmt.Errorf("%w: order_id is required", ErrInvalidInput)
+	}
+
+	out := &ReleaseInventoryOutput{OrderID: in.OrderID}
+
+	released, err := s.store.Release(ctx, in.OrderID, s.now())
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("在庫引当が存在しないため解放不要: order_id=%s", in.OrderID)
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("在庫引当解放エラー: %w", err)
+	}
+	out.Released = released
+
+	log.Printf("在庫引当解放完了: order_id=%s, released=%t", in.OrderID, released)
+	return out, nil
+}
+
+// ReleaseExpired 有効期限を過ぎた未確定の引当を解放し、解放した件数を返す
+func (s *Service) ReleaseExpired(ctx context.Context) (int, error) {
+	orderIDs, err := s.store.ListExpired(ctx, s.now())
+	if err != nil {
+		return 0, fmt.Errorf("期限切れの在庫引当の取得エラー: %w", err)
+	}
+
+	released := 0
+	for _, orderID := range orderIDs {
+		out, err := s.Release(ctx, ReleaseInventoryInput{OrderID: orderID})
+		if err != nil {
+			return released, err
+		}
+		if out.Released {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// Available SKUの引当可能な在庫数を取得する
+func (s *Service) Available(ctx context.Context, sku string) (int64, error) {
+	return s.store.Available(ctx, sku)
+}
@@ -0,0 +1,153 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+)
+
+func newTestService(now *time.Time) (*Service, *MemoryStore) {
+	store := NewMemoryStore()
+	store.SetStock("sku-1", 5)
+	store.SetStock("sku-2", 1)
+	service := NewService(store, 10*time.Minute)
+	service.now = func() time.Time { return *now }
+	return service, store
+}
+
+func newTestInput() ReserveInventoryInput {
+	return ReserveInventoryInput{
+		OrderID: "order-1",
+		UserID:  "user-1",
+		Items: []purchase.LineItem{
+			{SKU: "sku-1", Quantity: 2},
+			{SKU: "sku-2", Quantity: 1},
+			{SKU: "sku-1", Quantity: 1},
+		},
+	}
+}
+
+func assertAvailable(t *testing.T, s *Service, want map[string]int64) {
+	t.Helper()
+	for sku, quantity := range want {
+		got, err := s.Available(context.Background(), sku)
+		if err != nil {
+			t.Fatalf("Available() returned an error: %v", err)
+		}
+		if got != quantity {
+			t.Errorf("Available(%s) = %d, want %d", sku, got, quantity)
+		}
+	}
+}
+
+func TestServiceReserve(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	service, _ := newTestService(&now)
+
+	r, err := service.Reserve(context.Background(), newTestInput())
+	if err != nil {
+		t.Fatalf("Reserve() returned an error: %v", err)
+	}
+	if r.Status != StatusReserved || !r.ReservedUntil.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("Reserve() = %+v, want RESERVED until %s", r, now.Add(10*time.Minute))
+	}
+	// 同じSKUの明細はまとめて引き当てること
+	assertAvailable(t, service, map[string]int64{"sku-1": 2, "sku-2": 0})
+
+	// リトライしても二重に引き当てないこと
+	if _, err := service.Reserve(context.Background(), newTestInput()); err != nil {
+		t.Fatalf("Reserve() returned an error: %v", err)
+	}
+	assertAvailable(t, service, map[string]int64{"sku-1": 2, "sku-2": 0})
+}
+
+func TestServiceReserveInsufficientStock(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	service, _ := newTestService(&now)
+
+	in := newTestInput()
+	in.Items = append(in.Items, purchase.LineItem{SKU: "sku-2", Quantity: 1})
+	if _, err := service.Reserve(context.Background(), in); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("Reserve() error = %v, want %v", err, ErrInsufficientStock)
+	}
+	// 一部のSKUだけ引き当てないこと
+	assertAvailable(t, service, map[string]int64{"sku-1": 5, "sku-2": 1})
+}
+
+func TestServiceRelease(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	service, _ := newTestService(&now)
+
+	// 引当がない場合も成功すること
+	out, err := service.Release(context.Background(), ReleaseInventoryInput{OrderID: "order-1"})
+	if err != nil || out.Released {
+		t.Fatalf("Release() = %+v, %v, want not released", out, err)
+	}
+
+	if _, err := service.Reserve(context.Background(), newTestInput()); err != nil {
+		t.Fatalf("Reserve() returned an error: %v", err)
+	}
+	for i, want := range []bool{true, false} {
+		out, err := service.Release(context.Background(), ReleaseInventoryInput{OrderID: "order-1"})
+		if err != nil {
+			t.Fatalf("Release() returned an error: %v", err)
+		}
+		if out.Released != want {
+			t.Errorf("call %d: Released = %t, want %t", i+1, out.Released, want)
+		}
+	}
+	assertAvailable(t, service, map[string]int64{"sku-1": 5, "sku-2": 1})
+}
+
+func TestServiceConfirm(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	service, _ := newTestService(&now)
+
+	if _, err := service.Reserve(context.Background(), newTestInput()); err != nil {
+		t.Fatalf("Reserve() returned an error: %v", err)
+	}
+	for range 2 {
+		r, err := service.Confirm(context.Background(), ConfirmInventoryInput{OrderID: "order-1"})
+		if err != nil {
+			t.Fatalf("Confirm() returned an error: %v", err)
+		}
+		if r.Status != StatusConfirmed {
+			t.Errorf("Status = %s, want %s", r.Status, StatusConfirmed)
+		}
+	}
+
+	// 確定済みの引当は補償処理でも解放しないこと
+	out, err := service.Release(context.Background(), ReleaseInventoryInput{OrderID: "order-1"})
+	if err != nil || out.Released {
+		t.Errorf("Release() = %+v, %v, want not released", out, err)
+	}
+	assertAvailable(t, service, map[string]int64{"sku-1": 2, "sku-2": 0})
+}
+
+func TestServiceReleaseExpired(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	service, _ := newTestService(&now)
+
+	if _, err := service.Reserve(context.Background(), newTestInput()); err != nil {
+		t.Fatalf("Reserve() returned an error: %v", err)
+	}
+
+	// 有効期限内は解放しないこと
+	if released, err := service.ReleaseExpired(context.Background()); err != nil || released != 0 {
+		t.Fatalf("ReleaseExpired() = %d, %v, want 0", released, err)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if released, err := service.ReleaseExpired(context.Background()); err != nil || released != 1 {
+		t.Fatalf("ReleaseExpired() = %d, %v, want 1", released, err)
+	}
+	assertAvailable(t, service, map[string]int64{"sku-1": 5, "sku-2": 1})
+
+	// 期限切れで解放された引当は確定できないこと
+	if _, err := service.Confirm(context.Background(), ConfirmInventoryInput{OrderID: "order-1"}); !errors.Is(err, ErrReservationExpired) {
+		t.Errorf("Confirm() error = %v, want %v", err, ErrReservationExpired)
+	}
+}
@@ -0,0 +1,436 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/internal/ddbattr"
+)
+
+// statusIndexName ステータスと有効期限で引当を検索するためのGSI
+const statusIndexName = "status-reserved_until-index"
+
+// releasedRetention 解放済みの引当を保持する期間。過ぎるとDynamoDBのTTLで削除される
+const releasedRetention = 7 * 24 * time.Hour
+
+// Store 在庫と引当の永続化
+type Store interface {
+	// Reserve 在庫の減算と引当の作成を原子的に行う。
+	// 引当が既に存在する場合はErrAlreadyExists、在庫が不足する場合はErrInsufficientStockを返す
+	Reserve(ctx context.Context, r *Reservation) error
+	// Get 引当を取得する。存在しない場合はErrNotFoundを返す
+	Get(ctx context.Context, orderID string) (*Reservation, error)
+	// Confirm 有効期限内の未確定の引当を確定する。確定済みの場合はそのまま返す。
+	// 存在しない場合はErrNotFound、有効期限切れ・解放済みの場合はErrReservationExpiredを返す
+	Confirm(ctx context.Context, orderID string, at time.Time) (*Reservation, error)
+	// Release 未確定の引当の解放と在庫の加算を原子的に行う。今回解放した場合はtrue、解放済み・確定済みの場合はfalseを返す。
+	// 存在しない場合はErrNotFoundを返す
+	Release(ctx context.Context, orderID string, at time.Time) (bool, error)
+	// ListExpired 有効期限を過ぎた未確定の引当の注文IDを取得する
+	ListExpired(ctx context.Context, now time.Time) ([]string, error)
+	// Available SKUの引当可能な在庫数を取得する。在庫が登録されていない場合は0
+	Available(ctx context.Context, sku string) (int64, error)
+}
+
+// MemoryStore テスト・ローカル実行用のインメモリストア
+type MemoryStore struct {
+	mu           sync.Mutex
+	stocks       map[string]int64
+	reservations map[string]Reservation
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{stocks: make(map[string]int64), reservations: make(map[string]Reservation)}
+}
+
+// SetStock SKUの在庫数を設定する
+func (s *MemoryStore) SetStock(sku string, quantity int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stocks[sku] = quantity
+}
+
+// Reserve 在庫の減算と引当の作成を行う
+func (s *MemoryStore) Reserve(_ context.Context, r *Reservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reservations[r.OrderID]; ok {
+		return ErrAlreadyExists
+	}
+	for _, item := range r.Items {
+		if s.stocks[item.SKU] < item.Quantity {
+			return fmt.Errorf("%w: sku=%s", ErrInsufficientStock, item.SKU)
+		}
+	}
+	for _, item := range r.Items {
+		s.stocks[item.SKU] -= item.Quantity
+	}
+	s.reservations[r.OrderID] = *r
+	return nil
+}
+
+// Get 引当を取得する
+func (s *MemoryStore) Get(_ context.Context, orderID string) (*Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reservations[orderID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &r, nil
+}
+
+// Confirm 有効期限内の未確定の引当を確定する
+func (s *MemoryStore) Confirm(_ context.Context, orderID string, at time.Time) (*Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reservations[orderID]
+	switch {
+	case !ok:
+		return nil, ErrNotFound
+	case r.Status == StatusConfirmed:
+		return &r, nil
+	case r.Status != StatusReserved || !at.Before(r.ReservedUntil):
+		return nil, ErrReservationExpired
+	}
+	r.Status = StatusConfirmed
+	r.UpdatedAt = at
+	s.reservations[orderID] = r
+	return &r, nil
+}
+
+// Release 未確定の引当を解放して在庫を戻す
+func (s *MemoryStore) Release(_ context.Context, orderID string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reservations[orderID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if r.Status != StatusReserved {
+		return false, nil
+	}
+	for _, item := range r.Items {
+		s.stocks[item.SKU] += item.Quantity
+	}
+	r.Status = StatusReleased
+	r.UpdatedAt = at
+	s.reservations[orderID] = r
+	return true, nil
+}
+
+// ListExpired 有効期限を過ぎた未確定の引当の注文IDを取得する
+func (s *MemoryStore) ListExpired(_ context.Context, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var orderIDs []string
+	for _, r := range s.reservations {
+		if r.Status == StatusReserved && !now.Before(r.ReservedUntil) {
+			orderIDs = append(orderIDs, r.OrderID)
+		}
+	}
+	sort.Strings(orderIDs)
+	return orderIDs, nil
+}
+
+// Available SKUの引当可能な在庫数を取得する
+func (s *MemoryStore) Available(_ context.Context, sku string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stocks[sku], nil
+}
+
+// DynamoDBAPI DynamoDBStoreが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// DynamoDBStore DynamoDBの在庫テーブル(キー: sku)と引当テーブル(キー: order_id)を使用するストア
+type DynamoDBStore struct {
+	client           DynamoDBAPI
+	stockTable       string
+	reservationTable string
+}
+
+// NewDynamoDBStore DynamoDBStoreを生成する
+func NewDynamoDBStore(client DynamoDBAPI, stockTable, reservationTable string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, stockTable: stockTable, reservationTable: reservationTable}
+}
+
+// Reserve 引当の条件付き書き込みとSKUごとの在庫の条件付き減算をトランザクションで行う
+func (s *DynamoDBStore) Reserve(ctx context.Context, r *Reservation) error {
+	transactItems := []types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				TableName:           aws.String(s.reservationTable),
+				Item:                marshalReservation(r),
+				ConditionExpression: aws.String("attribute_not_exists(order_id)"),
+			},
+		},
+	}
+	for _, item := range r.Items {
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(s.stockTable),
+				Key: map[string]types.AttributeValue{
+					"sku": ddbattr.S(item.SKU),
+				},
+				UpdateExpression:    aws.String("SET available = available - :quantity, updated_at = :at"),
+				ConditionExpression: aws.String("available >= :quantity"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":quantity": ddbattr.N(item.Quantity),
+					":at":       ddbattr.Time(r.CreatedAt),
+				},
+			},
+		})
+	}
+
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+
+	// 条件チェックに失敗した操作の位置で、引当済みか在庫不足(在庫未登録を含む)かを判定する
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for i, reason := range canceled.CancellationReasons {
+			if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			}
+			if i == 0 {
+				return ErrAlreadyExists
+			}
+			return fmt.Errorf("%w: sku=%s", ErrInsufficientStock, r.Items[i-1].SKU)
+		}
+	}
+	return err
+}
+
+// Get 引当を取得する
+func (s *DynamoDBStore) Get(ctx context.Context, orderID string) (*Reservation, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.reservationTable),
+		Key: map[string]types.AttributeValue{
+			"order_id": ddbattr.S(orderID),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	return unmarshalReservation(result.Item)
+}
+
+// Confirm 有効期限内の未確定の場合のみステータスをCONFIRMEDに更新する
+func (s *DynamoDBStore) Confirm(ctx context.Context, orderID string, at time.Time) (*Reservation, error) {
+	result, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.reservationTable),
+		Key: map[string]types.AttributeValue{
+			"order_id": ddbattr.S(orderID),
+		},
+		UpdateExpression:    aws.String("SET #status = :confirmed, updated_at = :at"),
+		ConditionExpression: aws.String("#status = :reserved AND reserved_until > :at"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":confirmed": ddbattr.S(string(StatusConfirmed)),
+			":reserved":  ddbattr.S(string(StatusReserved)),
+			":at":        ddbattr.Time(at),
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	// 条件を満たさない場合、アイテムの有無とステータスで未作成・確定済み・期限切れを判定する
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		if len(ccf.Item) == 0 {
+			return nil, ErrNotFound
+		}
+		existing, err := unmarshalReservation(ccf.Item)
+		if err != nil {
+			return nil, err
+		}
+		if existing.Status == StatusConfirmed {
+			return existing, nil
+		}
+		return nil, ErrReservationExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalReservation(result.Attributes)
+}
+
+// Release 未確定の場合のみ引当をRELEASEDに更新し、SKUごとの在庫の加算とあわせてトランザクションで行う
+func (s *DynamoDBStore) Release(ctx context.Context, orderID string, at time.Time) (bool, error) {
+	r, err := s.Get(ctx, orderID)
+	if err != nil {
+		return false, err
+	}
+	if r.Status != StatusReserved {
+		return false, nil
+	}
+
+	transactItems := []types.TransactWriteItem{
+		{
+			Update: &types.Update{
+				TableName: aws.String(s.reservationTable),
+				Key: map[string]types.AttributeValue{
+					"order_id": ddbattr.S(orderID),
+				},
+				UpdateExpression:    aws.String("SET #status = :released, updated_at = :at, expires_at = :expires_at"),
+				ConditionExpression: aws.String("#status = :reserved"),
+				ExpressionAttributeNames: map[string]string{
+					"#status": "status",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":released":   ddbattr.S(string(StatusReleased)),
+					":reserved":   ddbattr.S(string(StatusReserved)),
+					":at":         ddbattr.Time(at),
+					":expires_at": ddbattr.Epoch(at.Add(releasedRetention)),
+				},
+			},
+		},
+	}
+	for _, item := range r.Items {
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName: aws.String(s.stockTable),
+				Key: map[string]types.AttributeValue{
+					"sku": ddbattr.S(item.SKU),
+				},
+				UpdateExpression: aws.String("ADD available :quantity SET updated_at = :at"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":quantity": ddbattr.N(item.Quantity),
+					":at":       ddbattr.Time(at),
+				},
+			},
+		})
+	}
+
+	_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+
+	// 取得後に別の呼び出しで解放・確定された場合
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ListExpired GSIを使用して有効期限を過ぎた未確定の引当の注文IDを取得する
+func (s *DynamoDBStore) ListExpired(ctx context.Context, now time.Time) ([]string, error) {
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.reservationTable),
+		IndexName:              aws.String(statusIndexName),
+		KeyConditionExpression: aws.String("#status = :reserved AND reserved_until <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":reserved": ddbattr.S(string(StatusReserved)),
+			":now":      ddbattr.Time(now),
+		},
+	})
+
+	var orderIDs []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			orderIDs = append(orderIDs, ddbattr.String(item, "order_id"))
+		}
+	}
+	return orderIDs, nil
+}
+
+// Available SKUの引当可能な在庫数を取得する
+func (s *DynamoDBStore) Available(ctx context.Context, sku string) (int64, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.stockTable),
+		Key: map[string]types.AttributeValue{
+			"sku": ddbattr.S(sku),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ddbattr.Int64(result.Item, "available")
+}
+
+// marshalReservation 引当をDynamoDBのアイテムに変換する
+func marshalReservation(r *Reservation) map[string]types.AttributeValue {
+	items := make([]types.AttributeValue, 0, len(r.Items))
+	for _, item := range r.Items {
+		items = append(items, &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"sku":      ddbattr.S(item.SKU),
+			"quantity": ddbattr.N(item.Quantity),
+		}})
+	}
+
+	return map[string]types.AttributeValue{
+		"order_id":       ddbattr.S(r.OrderID),
+		"user_id":        ddbattr.S(r.UserID),
+		"items":          &types.AttributeValueMemberL{Value: items},
+		"status":         ddbattr.S(string(r.Status)),
+		"reserved_until": ddbattr.Time(r.ReservedUntil),
+		"created_at":     ddbattr.Time(r.CreatedAt),
+		"updated_at":     ddbattr.Time(r.UpdatedAt),
+	}
+}
+
+// unmarshalReservation DynamoDBのアイテムを引当に変換する
+func unmarshalReservation(av map[string]types.AttributeValue) (*Reservation, error) {
+	r := &Reservation{
+		OrderID: ddbattr.String(av, "order_id"),
+		UserID:  ddbattr.String(av, "user_id"),
+		Status:  Status(ddbattr.String(av, "status")),
+	}
+
+	if list, ok := av["items"].(*types.AttributeValueMemberL); ok {
+		for _, v := range list.Value {
+			m, ok := v.(*types.AttributeValueMemberM)
+			if !ok {
+				continue
+			}
+			item := Item{SKU: ddbattr.String(m.Value, "sku")}
+			var err error
+			if item.Quantity, err = ddbattr.Int64(m.Value, "quantity"); err != nil {
+				return nil, err
+			}
+			r.Items = append(r.Items, item)
+		}
+	}
+
+	var err error
+	if r.ReservedUntil, err = ddbattr.ParseTime(av, "reserved_until"); err != nil {
+		return nil, err
+	}
+	if r.CreatedAt, err = ddbattr.ParseTime(av, "created_at"); err != nil {
+		return nil, err
+	}
+	if r.UpdatedAt, err = ddbattr.ParseTime(av, "updated_at"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,284 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+)
+
+// base64LineLength base64でエンコードした添付ファイルの1行の文字数(RFC 2045の上限76文字)
+const base64LineLength = 76
+
+// Email 送信するメール
+type Email struct {
+	// From 送信者のメールアドレス
+	From string
+	// FromName 送信者の表示名。空の場合はメールアドレスのみ
+	FromName string
+	To       []string
+	// ReplyTo 返信先のメールアドレス。空の場合はReply-Toヘッダーを付けない
+	ReplyTo []string
+	Subject string
+	// Text テキストパート
+	Text string
+	// HTML HTMLパート。テキストパートと両方ある場合はmultipart/alternativeにする
+	HTML string
+	// Attachments 添付ファイル
+	Attachments []Attachment
+	// Headers List-Unsubscribeなどの追加のヘッダー(ASCIIのみ)。From・Subjectなどの組み立てるヘッダーは指定できない
+	Headers map[string]string
+}
+
+// Attachment 添付ファイル
+type Attachment struct {
+	// Filename ファイル名(日本語の場合はRFC 2231でエンコードする)
+	Filename string
+	// ContentType MIMEタイプ。空の場合はapplication/octet-stream
+	ContentType string
+	Data        []byte
+}
+
+// Composer MIME形式のメールの組み立て
+type Composer struct {
+	now      func() time.Time
+	boundary func() string
+}
+
+// NewComposer Composerを生成する
+func NewComposer() *Composer {
+	return &Composer{now: time.Now, boundary: randomBoundary}
+}
+
+// randomBoundary マルチパートの境界文字列を生成する
+func randomBoundary() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// Compose メールをMIME形式(RFC 5322)に組み立てる。
+// 本文はテキストパート・HTMLパートのいずれか(両方ある場合はmultipart/alternative)、
+// 添付ファイルがある場合は本文と添付ファイルをmultipart/mixedにまとめる
+func (c *Composer) Compose(e *Email) ([]byte, error) {
+	if e.From == "" || len(e.To) == 0 {
+		return nil, errors.New("送信者と宛先は必須です")
+	}
+	if e.Text == "" && e.HTML == "" {
+		return nil, errors.New("本文(テキストパートまたはHTMLパート)は必須です")
+	}
+	if strings.ContainsAny(e.Subject, "\r\n") {
+		return nil, errors.New("件名に改行が含まれています")
+	}
+
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	from := &mail.Address{Name: e.FromName, Address: e.From}
+	header.Set("From", from.String())
+	to, err := formatAddressList(e.To)
+	if err != nil {
+		return nil, fmt.Errorf("宛先が不正です: %w", err)
+	}
+	header.Set("To", to)
+	if len(e.ReplyTo) > 0 {
+		replyTo, err := formatAddressList(e.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("返信先が不正です: %w", err)
+		}
+		header.Set("Reply-To", replyTo)
+	}
+	// 日本語の件名はRFC 2047のBエンコーディングでエンコードする(ASCIIのみの場合はそのまま)
+	header.Set("Subject", mime.BEncoding.Encode("UTF-8", e.Subject))
+	header.Set("Date", c.now().Format(time.RFC1123Z))
+	header.Set("MIME-Version", "1.0")
+	for name, value := range e.Headers {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if isComposedHeader(name) || strings.ContainsAny(name, ": \r\n") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("追加のヘッダーが不正です: %s", name)
+		}
+		header.Set(name, value)
+	}
+
+	if len(e.Attachments) == 0 {
+		content, err := c.body(header, e)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader(&buf, header)
+		buf.Write(content)
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	if err := mixed.SetBoundary(c.boundary()); err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()}))
+	writeHeader(&buf, header)
+
+	bodyHeader := textproto.MIMEHeader{}
+	content, err := c.body(bodyHeader, e)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePart(mixed, bodyHeader, content); err != nil {
+		return nil, err
+	}
+	for _, a := range e.Attachments {
+		if err := writeAttachment(mixed, a); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// body 本文のContent-Typeなどをheaderに設定し、エンコードした本文を返す
+func (c *Composer) body(header textproto.MIMEHeader, e *Email) ([]byte, error) {
+	var content bytes.Buffer
+	switch {
+	case e.Text != "" && e.HTML != "":
+		alternative := multipart.NewWriter(&content)
+		if err := alternative.SetBoundary(c.boundary()); err != nil {
+			return nil, err
+		}
+		header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+		// 受信者のメールソフトは後のパートを優先して表示するため、HTMLパートを後にする
+		for _, part := range []struct{ contentType, data string }{{"text/plain", e.Text}, {"text/html", e.HTML}} {
+			partHeader := textproto.MIMEHeader{}
+			data, err := encodeText(partHeader, part.contentType, part.data)
+			if err != nil {
+				return nil, err
+			}
+			if err := writePart(alternative, partHeader, data); err != nil {
+				return nil, err
+			}
+		}
+		if err := alternative.Close(); err != nil {
+			return nil, err
+		}
+		return content.Bytes(), nil
+	case e.HTML != "":
+		return encodeText(header, "text/html", e.HTML)
+	default:
+		return encodeText(header, "text/plain", e.Text)
+	}
+}
+
+// encodeText テキストをUTF-8のquoted-printableでエンコードし、パートのヘッダーを設定する
+func encodeText(header textproto.MIMEHeader, contentType, text string) ([]byte, error) {
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"charset": "UTF-8"}))
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	var buf bytes.Buffer
+	qp := quotedprintable.NewWriter(&buf)
+	// 改行はCRLFに統一する
+	if _, err := io.WriteString(qp, strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAttachment 添付ファイルをbase64でエンコードしてパートとして書き込む
+func writeAttachment(w *multipart.Writer, a Attachment) error {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	header.Set("Content-Transfer-Encoding", "base64")
+
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	var data bytes.Buffer
+	for len(encoded) > base64LineLength {
+		data.WriteString(encoded[:base64LineLength] + "\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	data.WriteString(encoded)
+	return writePart(w, header, data.Bytes())
+}
+
+// writePart マルチパートにパートを書き込む
+func writePart(w *multipart.Writer, header textproto.MIMEHeader, data []byte) error {
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(data)
+	return err
+}
+
+var (
+	// headerOrder ヘッダーを書き込む順序(追加のヘッダーはこの後)
+	headerOrder = []string{"From", "To", "Reply-To", "Subject", "Date", "MIME-Version"}
+	// contentHeaders 本文のヘッダー(最後に書き込む)
+	contentHeaders = []string{"Content-Type", "Content-Transfer-Encoding"}
+)
+
+// isComposedHeader Composeが組み立てるヘッダーか
+func isComposedHeader(name string) bool {
+	canonical := func(n string) bool {
+		return textproto.CanonicalMIMEHeaderKey(n) == textproto.CanonicalMIMEHeaderKey(name)
+	}
+	return slices.ContainsFunc(headerOrder, canonical) || slices.ContainsFunc(contentHeaders, canonical)
+}
+
+// writeHeader メールのヘッダーを書き込み、空行で本文と区切る
+func writeHeader(w *bytes.Buffer, header textproto.MIMEHeader) {
+	write := func(name string) {
+		if v := header.Get(name); v != "" {
+			fmt.Fprintf(w, "%s: %s\r\n", name, v)
+		}
+	}
+	for _, name := range headerOrder {
+		write(name)
+	}
+	// 追加のヘッダーは名前順にする
+	var extra []string
+	for name := range header {
+		if !isComposedHeader(name) {
+			extra = append(extra, name)
+		}
+	}
+	slices.Sort(extra)
+	for _, name := range extra {
+		write(name)
+	}
+	for _, name := range contentHeaders {
+		write(name)
+	}
+	w.WriteString("\r\n")
+}
+
+// formatAddressList メールアドレスを検証し、ヘッダーの形式(表示名はRFC 2047でエンコード)に変換する
+func formatAddressList(addresses []string) (string, error) {
+	formatted := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		a, err := mail.ParseAddress(address)
+		if err != nil {
+			return "", err
+		}
+		formatted = append(formatted, a.String())
+	}
+	return strings.Join(formatted, ", "), nil
+}
@@ -0,0 +1,287 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+)
+
+// newTestComposer 日時と境界文字列を固定したComposerを生成する
+func newTestComposer() *Composer {
+	n := 0
+	return &Composer{
+		now: func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) },
+		boundary: func() string {
+			n++
+			return fmt.Sprintf("boundary%d", n)
+		},
+	}
+}
+
+func TestComposeTextOnly(t *testing.T) {
+	raw, err := newTestComposer().Compose(&Email{
+		From:    "noreply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "Hello",
+		Text:    "line1\nline2",
+	})
+	if err != nil {
+		t.Fatalf("Compose() returned an error: %v", err)
+	}
+
+	want := "From: <noreply@example.com>\r\n" +
+		"To: <user@example.com>\r\n" +
+		"Subject: Hello\r\n" +
+		"Date: Fri, 02 Jan 2026 03:04:05 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"line1\r\nline2"
+	if string(raw) != want {
+		t.Errorf("Compose() =\n%s\nwant\n%s", raw, want)
+	}
+}
+
+func TestComposeAdditionalHeaders(t *testing.T) {
+	raw, err := newTestComposer().Compose(&Email{
+		From:    "noreply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "Hello",
+		Text:    "body",
+		Headers: map[string]string{
+			"list-unsubscribe-post": "List-Unsubscribe=One-Click",
+			"List-Unsubscribe":      "<https://example.com/unsubscribe?token=abc>",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compose() returned an error: %v", err)
+	}
+
+	// 追加のヘッダーはMIME-Versionの後に名前順で、本文のヘッダーより前に書き込むこと
+	want := "MIME-Version: 1.0\r\n" +
+		"List-Unsubscribe: <https://example.com/unsubscribe?token=abc>\r\n" +
+		"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n"
+	if !strings.Contains(string(raw), want) {
+		t.Errorf("Compose() =\n%s\nwant to contain\n%s", raw, want)
+	}
+}
+
+func TestComposeJapaneseHeaders(t *testing.T) {
+	raw, err := newTestComposer().Compose(&Email{
+		From:     "noreply@example.com",
+		FromName: "配信チーム",
+		To:       []string{"山田 <yamada@example.com>"},
+		ReplyTo:  []string{"support@example.com"},
+		Subject:  "登録ありがとうございました",
+		Text:     "山田様",
+	})
+	if err != nil {
+		t.Fatalf("Compose() returned an error: %v", err)
+	}
+
+	// ヘッダーは全てASCIIで、日本語はRFC 2047でエンコードすること
+	header, _, _ := strings.Cut(string(raw), "\r\n\r\n")
+	for _, r := range header {
+		if r > 127 {
+			t.Fatalf("header contains a non-ASCII character %q:\n%s", r, header)
+		}
+	}
+	if !strings.Contains(header, "Subject: =?UTF-8?b?") {
+		t.Errorf("Subject is not B-encoded:\n%s", header)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("ReadMessage() returned an error: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "登録ありがとうございました" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	from, err := msg.Header.AddressList("From")
+	if err != nil || from[0].Name != "配信チーム" || from[0].Address != "noreply@example.com" {
+		t.Errorf("From = %v, %v", from, err)
+	}
+	to, err := msg.Header.AddressList("To")
+	if err != nil || to[0].Name != "山田" {
+		t.Errorf("To = %v, %v", to, err)
+	}
+	if got := msg.Header.Get("Reply-To"); got != "<support@example.com>" {
+		t.Errorf("Reply-To = %q", got)
+	}
+}
+
+// readParts マルチパートの各パートのContent-Typeとデコードした内容を返す
+func readParts(t *testing.T, contentType string, body io.Reader) ([]string, []string, []*multipart.Part) {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		t.Fatalf("Content-Type = %q, want multipart", contentType)
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	var types, contents []string
+	var parts []*multipart.Part
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart() returned an error: %v", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("ReadAll() returned an error: %v", err)
+		}
+		types = append(types, part.Header.Get("Content-Type"))
+		contents = append(contents, string(data))
+		parts = append(parts, part)
+	}
+	return types, contents, parts
+}
+
+func TestComposeAlternativeWithAttachments(t *testing.T) {
+	raw, err := newTestComposer().Compose(&Email{
+		From:    "noreply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "お知らせ",
+		Text:    "テキスト",
+		HTML:    "<p>HTML</p>",
+		Attachments: []Attachment{
+			{Filename: "案内.pdf", ContentType: "application/pdf", Data: []byte(strings.Repeat("x", 100))},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Compose() returned an error: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("ReadMessage() returned an error: %v", err)
+	}
+	if got := msg.Header.Get("Content-Type"); got != "multipart/mixed; boundary=boundary1" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	// multipart/mixedは本文(multipart/alternative)と添付ファイルの順であること
+	types, contents, parts := readParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	if len(types) != 2 || types[0] != "multipart/alternative; boundary=boundary2" || types[1] != "application/pdf" {
+		t.Fatalf("mixed parts = %v", types)
+	}
+
+	// 添付ファイルはbase64で76文字ごとに改行し、日本語のファイル名はRFC 2231でエンコードすること
+	if got := parts[1].Header.Get("Content-Disposition"); got != "attachment; filename*=utf-8''%E6%A1%88%E5%86%85.pdf" {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if parts[1].FileName() != "案内.pdf" {
+		t.Errorf("FileName() = %q", parts[1].FileName())
+	}
+	for _, line := range strings.Split(contents[1], "\r\n") {
+		if len(line) > base64LineLength {
+			t.Errorf("base64 line length = %d, want <= %d", len(line), base64LineLength)
+		}
+	}
+
+	// multipart/alternativeはテキストパート・HTMLパートの順で、quoted-printableをデコードすると元の本文になること
+	types, contents, _ = readParts(t, types[0], strings.NewReader(contents[0]))
+	if len(types) != 2 || types[0] != "text/plain; charset=UTF-8" || types[1] != "text/html; charset=UTF-8" {
+		t.Fatalf("alternative parts = %v", types)
+	}
+	if contents[0] != "テキスト" || contents[1] != "<p>HTML</p>" {
+		t.Errorf("alternative contents = %q", contents)
+	}
+}
+
+func TestComposeInvalid(t *testing.T) {
+	tests := map[string]*Email{
+		"missing to":          {From: "noreply@example.com", Subject: "s", Text: "t"},
+		"missing body":        {From: "noreply@example.com", To: []string{"user@example.com"}, Subject: "s"},
+		"newline in subject":  {From: "noreply@example.com", To: []string{"user@example.com"}, Subject: "s\r\nBcc: x@example.com", Text: "t"},
+		"invalid destination": {From: "noreply@example.com", To: []string{"not an address"}, Subject: "s", Text: "t"},
+		"overriding header":   {From: "noreply@example.com", To: []string{"user@example.com"}, Subject: "s", Text: "t", Headers: map[string]string{"subject": "x"}},
+		"newline in header":   {From: "noreply@example.com", To: []string{"user@example.com"}, Subject: "s", Text: "t", Headers: map[string]string{"X-Test": "a\r\nBcc: x@example.com"}},
+	}
+	for name, e := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newTestComposer().Compose(e); err == nil {
+				t.Error("Compose() returned no error")
+			}
+		})
+	}
+}
+
+// fakeSES SendRawEmailの入力を記録するSESクライアント
+type fakeSES struct {
+	input *ses.SendRawEmailInput
+}
+
+func (f *fakeSES) SendRawEmail(_ context.Context, params *ses.SendRawEmailInput, _ ...func(*ses.Options)) (*ses.SendRawEmailOutput, error) {
+	f.input = params
+	id := "message-1"
+	return &ses.SendRawEmailOutput{MessageId: &id}, nil
+}
+
+func TestSenderSend(t *testing.T) {
+	client := &fakeSES{}
+	sender := NewSender(client)
+	sender.composer = newTestComposer()
+
+	messageID, err := sender.Send(context.Background(), &Email{
+		From:    "noreply@example.com",
+		To:      []string{"user@example.com"},
+		Subject: "Hello",
+		Text:    "body",
+	}, map[string]string{"category": "news"})
+	if err != nil {
+		t.Fatalf("Send() returned an error: %v", err)
+	}
+	if messageID != "message-1" {
+		t.Errorf("messageID = %q, want %q", messageID, "message-1")
+	}
+	if !strings.HasPrefix(string(client.input.RawMessage.Data), "From: <noreply@example.com>\r\n") {
+		t.Errorf("RawMessage = %q", client.input.RawMessage.Data)
+	}
+	if len(client.input.Tags) != 1 || *client.input.Tags[0].Name != "category" || *client.input.Tags[0].Value != "news" {
+		t.Errorf("Tags = %v", client.input.Tags)
+	}
+}
+
+// fakeS3 指定したキーのオブジェクトだけが存在するS3クライアント
+type fakeS3 struct {
+	keys map[string]bool
+}
+
+func (f *fakeS3) HeadObject(_ context.Context, params *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if !f.keys[aws.ToString(params.Key)] {
+		return nil, &s3types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{}, nil
+}
+
+func TestCheckAttachments(t *testing.T) {
+	client := &fakeS3{keys: map[string]bool{"attachments/guide.pdf": true}}
+	ctx := context.Background()
+
+	if err := CheckAttachments(ctx, client, "contents", []string{"attachments/guide.pdf"}); err != nil {
+		t.Errorf("CheckAttachments() returned an error: %v", err)
+	}
+	// 存在しないキーはメール本文ファイルの誤りとして扱うこと
+	err := CheckAttachments(ctx, client, "contents", []string{"attachments/guide.pdf", "attachments/typo.pdf"})
+	if !errors.Is(err, ErrInvalidTemplate) || !strings.Contains(err.Error(), "attachments/typo.pdf") {
+		t.Errorf("CheckAttachments() error = %v, want %v for attachments/typo.pdf", err, ErrInvalidTemplate)
+	}
+}
@@ -0,0 +1,196 @@
+package mail
+
+import (
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// メール本文ファイルの形式
+//
+// 1行目が"---"の場合はヘッダーブロック形式とし、次の"---"の行までを"項目名: 値"のヘッダー、それ以降を本文とする。
+// 本文は"--- text"・"--- html"の行でテキストパートとHTMLパートに分け、区切りの行がない場合は全体をテキストパートとする。
+// 1行目が"---"でない場合は従来の形式(1行目が件名、2行目が空行、3行目以降がテキストの本文)とする
+//
+//	---
+//	Subject: {{.UserName}}様へのお知らせ
+//	From-Name: 配信チーム
+//	Reply-To: support@example.com
+//	Tags: category=news, season=spring
+//	Attachments: attachments/guide.pdf
+//	List: news
+//	---
+//	--- text
+//	{{.UserName}}様
+//	--- html
+//	<p>{{.UserName}}様</p>
+const (
+	// headerDelimiter ヘッダーブロックの開始・終了の行
+	headerDelimiter = "---"
+	// textPartDelimiter テキストパートの開始の行
+	textPartDelimiter = "--- text"
+	// htmlPartDelimiter HTMLパートの開始の行
+	htmlPartDelimiter = "--- html"
+)
+
+// ヘッダーの項目名(net/textprotoの正規化後)
+const (
+	headerSubject  = "Subject"
+	headerFromName = "From-Name"
+	headerReplyTo  = "Reply-To"
+	headerTags     = "Tags"
+	// headerAttachments 添付ファイル(メール本文ファイルと同じバケットのキー)
+	headerAttachments = "Attachments"
+	// headerList 配信リスト。配信停止はこの単位で行う
+	headerList = "List"
+)
+
+// DefaultList Listヘッダーがない場合の配信リスト
+const DefaultList = "default"
+
+// tagPattern SESのメッセージタグの名前・値に使用できる文字
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,256}$`)
+
+// source 差し込み前のメール本文ファイルの内容
+type source struct {
+	Subject  string
+	FromName string
+	ReplyTo  []string
+	Tags     map[string]string
+	// Attachments 添付ファイルのS3のキー
+	Attachments []string
+	List        string
+	Text        string
+	HTML        string
+}
+
+// parseSource メール本文ファイルを解析する
+func parseSource(data string) (*source, error) {
+	// Windowsで作成したファイルも同じように扱う
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	if lines[0] != headerDelimiter {
+		return parseLegacySource(lines)
+	}
+
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == headerDelimiter {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("ヘッダーブロックの終わり(%s)がありません", headerDelimiter)
+	}
+
+	src := &source{List: DefaultList}
+	if err := src.parseHeader(lines[1:end]); err != nil {
+		return nil, err
+	}
+	src.parseParts(lines[end+1:])
+
+	if src.Subject == "" {
+		return nil, fmt.Errorf("%sは必須です", headerSubject)
+	}
+	if strings.TrimSpace(src.Text) == "" && strings.TrimSpace(src.HTML) == "" {
+		return nil, fmt.Errorf("本文(テキストパートまたはHTMLパート)は必須です")
+	}
+	return src, nil
+}
+
+// parseLegacySource 従来の形式(1行目が件名、2行目が空行、3行目以降が本文)を解析する
+func parseLegacySource(lines []string) (*source, error) {
+	if len(lines) < 3 {
+		return nil, fmt.Errorf("メールデータの形式が不正です")
+	}
+	return &source{Subject: lines[0], List: DefaultList, Text: strings.Join(lines[2:], "\n")}, nil
+}
+
+// parseHeader ヘッダーブロックの各行を解析する。項目名は大文字・小文字を区別しない
+func (src *source) parseHeader(lines []string) error {
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return fmt.Errorf("ヘッダーの形式が不正です: %q", line)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if seen[name] {
+			return fmt.Errorf("ヘッダーが重複しています: %s", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case headerSubject:
+			src.Subject = value
+		case headerFromName:
+			src.FromName = value
+		case headerReplyTo:
+			addresses, err := mail.ParseAddressList(value)
+			if err != nil {
+				return fmt.Errorf("%sが不正です: %w", headerReplyTo, err)
+			}
+			for _, a := range addresses {
+				src.ReplyTo = append(src.ReplyTo, a.String())
+			}
+		case headerTags:
+			tags, err := parseTags(value)
+			if err != nil {
+				return err
+			}
+			src.Tags = tags
+		case headerAttachments:
+			for _, key := range strings.Split(value, ",") {
+				if key = strings.TrimSpace(key); key != "" {
+					src.Attachments = append(src.Attachments, key)
+				}
+			}
+		case headerList:
+			if !tagPattern.MatchString(value) {
+				return fmt.Errorf("%sが不正です(英数字・_・-・.のみ): %q", headerList, value)
+			}
+			src.List = value
+		default:
+			return fmt.Errorf("未対応のヘッダーです: %s", name)
+		}
+	}
+	return nil
+}
+
+// parseParts 本文を区切りの行でテキストパートとHTMLパートに分ける
+func (src *source) parseParts(lines []string) {
+	parts := map[string][]string{}
+	current := textPartDelimiter
+	for _, line := range lines {
+		if line == textPartDelimiter || line == htmlPartDelimiter {
+			current = line
+			continue
+		}
+		parts[current] = append(parts[current], line)
+	}
+	src.Text = strings.Join(parts[textPartDelimiter], "\n")
+	src.HTML = strings.Join(parts[htmlPartDelimiter], "\n")
+}
+
+// parseTags "名前=値"をカンマ区切りで並べたタグを解析する
+func parseTags(value string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, v, found := strings.Cut(pair, "=")
+		name, v = strings.TrimSpace(name), strings.TrimSpace(v)
+		if !found || !tagPattern.MatchString(name) || !tagPattern.MatchString(v) {
+			return nil, fmt.Errorf("%sが不正です(英数字・_・-・.のみの名前=値): %q", headerTags, pair)
+		}
+		tags[name] = v
+	}
+	return tags, nil
+}
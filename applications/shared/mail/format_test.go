@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTemplateStructuredFormat(t *testing.T) {
+	data := "---\n" +
+		"Subject: {{.UserName}}様へのお知らせ\n" +
+		"from-name: 配信チーム\n" +
+		"Reply-To: サポート <support@example.com>, info@example.com\n" +
+		"Tags: category=news, season=spring\n" +
+		"Attachments: files/guide.pdf, files/map.png\n" +
+		"List: news\n" +
+		"---\n" +
+		"--- text\n" +
+		"{{.UserName}}様\n" +
+		"--- html\n" +
+		"<p>{{.UserName}}様</p>"
+	tmpl, err := ParseTemplate(data)
+	if err != nil {
+		t.Fatalf("ParseTemplate() returned an error: %v", err)
+	}
+
+	msg, err := tmpl.Render(TemplateData{UserName: "<山田>"})
+	if err != nil {
+		t.Fatalf("Render() returned an error: %v", err)
+	}
+	want := &Message{
+		Subject:  "<山田>様へのお知らせ",
+		FromName: "配信チーム",
+		ReplyTo:  []string{"=?utf-8?q?=E3=82=B5=E3=83=9D=E3=83=BC=E3=83=88?= <support@example.com>", "<info@example.com>"},
+		Text:     "<山田>様",
+		// HTMLパートは差し込んだ値をエスケープすること
+		HTML:        "<p>&lt;山田&gt;様</p>",
+		Tags:        map[string]string{"category": "news", "season": "spring"},
+		Attachments: []string{"files/guide.pdf", "files/map.png"},
+		List:        "news",
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("Render() = %+v, want %+v", msg, want)
+	}
+}
+
+func TestTemplateStructuredFormatTextOnly(t *testing.T) {
+	// 区切りの行がない場合は本文全体をテキストパートとすること
+	tmpl, err := ParseTemplate("---\r\nSubject: 件名\r\n---\r\n本文1\r\n本文2")
+	if err != nil {
+		t.Fatalf("ParseTemplate() returned an error: %v", err)
+	}
+	msg, err := tmpl.Render(TemplateData{})
+	if err != nil {
+		t.Fatalf("Render() returned an error: %v", err)
+	}
+	// Listヘッダーがない場合は既定の配信リストとすること
+	if msg.Subject != "件名" || msg.Text != "本文1\n本文2" || msg.HTML != "" || msg.List != DefaultList {
+		t.Errorf("Render() = %+v", msg)
+	}
+}
+
+func TestParseTemplateInvalidStructuredFormat(t *testing.T) {
+	tests := map[string]string{
+		"unterminated header": "---\nSubject: 件名\n本文",
+		"missing subject":     "---\nFrom-Name: 配信チーム\n---\n本文",
+		"missing body":        "---\nSubject: 件名\n---\n--- html\n",
+		"unknown header":      "---\nSubject: 件名\nCc: a@example.com\n---\n本文",
+		"duplicate header":    "---\nSubject: 件名\nsubject: 件名2\n---\n本文",
+		"invalid reply-to":    "---\nSubject: 件名\nReply-To: not an address\n---\n本文",
+		"invalid tag":         "---\nSubject: 件名\nTags: カテゴリ=news\n---\n本文",
+		"invalid list":        "---\nSubject: 件名\nList: お知らせ\n---\n本文",
+		"malformed header":    "---\nSubject 件名\n---\n本文",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseTemplate(data); !errors.Is(err, ErrInvalidTemplate) {
+				t.Errorf("ParseTemplate() error = %v, want %v", err, ErrInvalidTemplate)
+			}
+		})
+	}
+}
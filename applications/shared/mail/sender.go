@@ -0,0 +1,110 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+// SESAPI Senderが使用するSESクライアントのメソッド
+type SESAPI interface {
+	SendRawEmail(ctx context.Context, params *ses.SendRawEmailInput, optFns ...func(*ses.Options)) (*ses.SendRawEmailOutput, error)
+}
+
+// Sender MIME形式に組み立てたメールをSESのSendRawEmailで送信する
+type Sender struct {
+	client   SESAPI
+	composer *Composer
+}
+
+// NewSender Senderを生成する
+func NewSender(client SESAPI) *Sender {
+	return &Sender{client: client, composer: NewComposer()}
+}
+
+// Send メールを送信し、SESのメッセージIDを返す。tagsはSESのメッセージタグ
+func (s *Sender) Send(ctx context.Context, e *Email, tags map[string]string) (string, error) {
+	raw, err := s.composer.Compose(e)
+	if err != nil {
+		return "", fmt.Errorf("メールの組み立てエラー: %w", err)
+	}
+
+	input := &ses.SendRawEmailInput{
+		RawMessage: &sestypes.RawMessage{Data: raw},
+	}
+	for name, value := range tags {
+		input.Tags = append(input.Tags, sestypes.MessageTag{Name: aws.String(name), Value: aws.String(value)})
+	}
+
+	result, err := s.client.SendRawEmail(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(result.MessageId), nil
+}
+
+// S3API LoadAttachmentが使用するS3クライアントのメソッド
+type S3API interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// LoadAttachment S3のオブジェクトを添付ファイルとして取得する。
+// ファイル名はキーの最後の要素、MIMEタイプはオブジェクトのContent-Type(ない場合は拡張子から判定)
+func LoadAttachment(ctx context.Context, client S3API, bucketName, key string) (Attachment, error) {
+	result, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return Attachment{}, fmt.Errorf("添付ファイルの取得エラー: key=%s: %w", key, err)
+	}
+	defer func() {
+		if err := result.Body.Close(); err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("添付ファイルの読み込みエラー: key=%s: %w", key, err)
+	}
+	contentType := aws.ToString(result.ContentType)
+	if contentType == "" || contentType == "binary/octet-stream" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	return Attachment{Filename: path.Base(key), ContentType: contentType, Data: data}, nil
+}
+
+// HeadObjectAPI CheckAttachmentsが使用するS3クライアントのメソッド
+type HeadObjectAPI interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// CheckAttachments 添付ファイルのS3のオブジェクトが存在するかを確認する。
+// 存在しないキーがある場合は、宛先ごとの送信で失敗し続けないようErrInvalidTemplateを返す
+func CheckAttachments(ctx context.Context, client HeadObjectAPI, bucketName string, keys []string) error {
+	for _, key := range keys {
+		_, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(key),
+		})
+		var notFound *s3types.NotFound
+		if errors.As(err, &notFound) {
+			return fmt.Errorf("%w: 添付ファイルが存在しません: key=%s", ErrInvalidTemplate, key)
+		}
+		if err != nil {
+			return fmt.Errorf("添付ファイルの確認エラー: key=%s: %w", key, err)
+		}
+	}
+	return nil
+}
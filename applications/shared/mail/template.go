@@ -0,0 +1,122 @@
+// Package mail はメール配信システムのメール本文ファイルの解析・テンプレートと、宛先ごとの差し込みを提供する
+package mail
+
+import (
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+// ErrInvalidTemplate メール本文ファイルのテンプレートが不正
+var ErrInvalidTemplate = errors.New("invalid mail template")
+
+// TemplateData テンプレートに差し込む宛先ごとの値。テンプレートでは{{.UserName}}のように参照する
+type TemplateData struct {
+	// UserName 宛先のユーザー名
+	UserName string
+	// Email 宛先のメールアドレス
+	Email string
+	// UnsubscribeURL 配信停止のリンク
+	UnsubscribeURL string
+}
+
+// sampleData テンプレートの検証に使用する差し込み値
+var sampleData = TemplateData{
+	UserName:       "sample",
+	Email:          "sample@example.com",
+	UnsubscribeURL: "https://example.com/unsubscribe",
+}
+
+// Message 宛先ごとの値を差し込んだメール
+type Message struct {
+	Subject string
+	// FromName 送信者の表示名。空の場合はメールアドレスのみ
+	FromName string
+	// ReplyTo 返信先のメールアドレス。空の場合は送信者
+	ReplyTo []string
+	// Text テキストパート。空の場合はHTMLパートのみ
+	Text string
+	// HTML HTMLパート。空の場合はテキストパートのみ
+	HTML string
+	// Tags SESのメッセージタグ
+	Tags map[string]string
+	// Attachments 添付ファイルのS3のキー(メール本文ファイルと同じバケット)
+	Attachments []string
+	// List 配信リスト
+	List string
+}
+
+// Template メール本文ファイルのテンプレート
+type Template struct {
+	src     *source
+	subject *template.Template
+	text    *template.Template
+	// html 差し込んだ値をエスケープするためhtml/templateを使用する
+	html *htmltemplate.Template
+}
+
+// ParseTemplate メール本文ファイルをテンプレートとして解析し、
+// 検証用の値で差し込みを試して、存在しない項目の参照などの誤りがないかを検証する
+func ParseTemplate(data string) (*Template, error) {
+	src, err := parseSource(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTemplate, err)
+	}
+
+	t := &Template{src: src}
+	if t.subject, err = template.New("subject").Option("missingkey=error").Parse(src.Subject); err != nil {
+		return nil, fmt.Errorf("%w: 件名: %w", ErrInvalidTemplate, err)
+	}
+	if t.text, err = template.New("text").Option("missingkey=error").Parse(src.Text); err != nil {
+		return nil, fmt.Errorf("%w: 本文: %w", ErrInvalidTemplate, err)
+	}
+	if t.html, err = htmltemplate.New("html").Option("missingkey=error").Parse(src.HTML); err != nil {
+		return nil, fmt.Errorf("%w: HTMLパート: %w", ErrInvalidTemplate, err)
+	}
+
+	if _, err := t.Render(sampleData); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTemplate, err)
+	}
+	return t, nil
+}
+
+// Render 宛先ごとの値を差し込んだメールを返す
+func (t *Template) Render(data TemplateData) (*Message, error) {
+	var subject, text, html strings.Builder
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("件名の差し込みエラー: %w", err)
+	}
+	// 件名はメールヘッダーになるため、差し込んだ値による改行を許可しない
+	if strings.ContainsAny(subject.String(), "\r\n") {
+		return nil, fmt.Errorf("件名に改行が含まれています")
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("本文の差し込みエラー: %w", err)
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("HTMLパートの差し込みエラー: %w", err)
+	}
+
+	return &Message{
+		Subject:     subject.String(),
+		FromName:    t.src.FromName,
+		ReplyTo:     t.src.ReplyTo,
+		Text:        text.String(),
+		HTML:        html.String(),
+		Tags:        t.src.Tags,
+		Attachments: t.src.Attachments,
+		List:        t.src.List,
+	}, nil
+}
+
+// List メール本文ファイルの配信リストを返す
+func (t *Template) List() string {
+	return t.src.List
+}
+
+// Attachments メール本文ファイルの添付ファイルのS3のキーを返す
+func (t *Template) Attachments() []string {
+	return t.src.Attachments
+}
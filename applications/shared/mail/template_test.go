@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTemplateRender(t *testing.T) {
+	tmpl, err := ParseTemplate("{{.UserName}}様へのお知らせ\n\n{{.UserName}}様({{.Email}})\n\n配信停止: {{.UnsubscribeURL}}")
+	if err != nil {
+		t.Fatalf("ParseTemplate() returned an error: %v", err)
+	}
+
+	msg, err := tmpl.Render(TemplateData{UserName: "山田", Email: "yamada@example.com", UnsubscribeURL: "https://example.com/u?t=1"})
+	if err != nil {
+		t.Fatalf("Render() returned an error: %v", err)
+	}
+	if want := "山田様へのお知らせ"; msg.Subject != want {
+		t.Errorf("Subject = %q, want %q", msg.Subject, want)
+	}
+	if want := "山田様(yamada@example.com)\n\n配信停止: https://example.com/u?t=1"; msg.Text != want {
+		t.Errorf("Text = %q, want %q", msg.Text, want)
+	}
+}
+
+func TestParseTemplatePlainText(t *testing.T) {
+	// 差し込みのない従来のメール本文ファイルはそのまま送信すること
+	tmpl, err := ParseTemplate("メール配信のテスト\n\nこのメールはテストです。\nテスト配信をしております。")
+	if err != nil {
+		t.Fatalf("ParseTemplate() returned an error: %v", err)
+	}
+	msg, err := tmpl.Render(TemplateData{})
+	if err != nil {
+		t.Fatalf("Render() returned an error: %v", err)
+	}
+	if msg.Subject != "メール配信のテスト" || msg.Text != "このメールはテストです。\nテスト配信をしております。" || msg.HTML != "" {
+		t.Errorf("Render() = %+v", msg)
+	}
+}
+
+func TestParseTemplateInvalid(t *testing.T) {
+	tests := map[string]string{
+		"too few lines":  "件名だけ",
+		"syntax error":   "件名\n\n{{.UserName}",
+		"unknown field":  "件名\n\n{{.Name}}様",
+		"subject syntax": "{{if .UserName}}件名\n\n本文",
+		"html syntax":    "---\nSubject: 件名\n---\n--- html\n<p>{{.UserName</p>",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseTemplate(data); !errors.Is(err, ErrInvalidTemplate) {
+				t.Errorf("ParseTemplate() error = %v, want %v", err, ErrInvalidTemplate)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrGatewayTimeout 決済ゲートウェイの応答がタイムアウトした
+	ErrGatewayTimeout = errors.New("payment gateway timeout")
+	// ErrChargeNotFound 返金対象の課金が決済ゲートウェイに存在しない
+	ErrChargeNotFound = errors.New("charge not found")
+)
+
+// ChargeRequest 課金リクエスト
+type ChargeRequest struct {
+	// IdempotencyKey ゲートウェイ側で二重課金を防ぐためのキー
+	IdempotencyKey     string
+	Amount             int64
+	Currency           string
+	PaymentMethodToken string
+}
+
+// ChargeResult 課金結果
+type ChargeResult struct {
+	TransactionID string
+}
+
+// RefundRequest 返金(取消)リクエスト
+type RefundRequest struct {
+	// IdempotencyKey 課金時に指定した冪等キー。課金結果が不明な場合もこのキーで取り消せる
+	IdempotencyKey string
+	TransactionID  string
+	Amount         int64
+	Currency       string
+}
+
+// RefundResult 返金結果
+type RefundResult struct {
+	RefundID string
+}
+
+// DeclinedError 決済ゲートウェイで課金が拒否されたことを表すエラー
+type DeclinedError struct {
+	Reason string
+}
+
+func (e *DeclinedError) Error() string {
+	return "declined: " + e.Reason
+}
+
+// PaymentGateway 決済ゲートウェイ
+type PaymentGateway interface {
+	// Charge 課金する。拒否された場合は*DeclinedErrorを返す
+	Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error)
+	// Refund 課金を返金(取消)する。返金済みの場合は同じ結果を返し、課金が存在しない場合はErrChargeNotFoundを返す
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+}
+
+// FakeMode FakeGatewayの動作モード
+type FakeMode string
+
+const (
+	// FakeModeSucceed 常に課金に成功する
+	FakeModeSucceed FakeMode = "succeed"
+	// FakeModeDecline 常に課金を拒否する
+	FakeModeDecline FakeMode = "decline"
+	// FakeModeTimeout 常にタイムアウトする
+	FakeModeTimeout FakeMode = "timeout"
+)
+
+// ParseFakeMode 文字列からFakeModeを取得する。空文字の場合はFakeModeSucceed
+func ParseFakeMode(s string) (FakeMode, error) {
+	switch FakeMode(s) {
+	case "", FakeModeSucceed:
+		return FakeModeSucceed, nil
+	case FakeModeDecline, FakeModeTimeout:
+		return FakeMode(s), nil
+	}
+	return "", fmt.Errorf("unknown fake gateway mode: %q", s)
+}
+
+// FakeGateway オフラインでSagaを動作させるためのインメモリ決済ゲートウェイ
+type FakeGateway struct {
+	mode FakeMode
+	// Latency 応答までの遅延。FakeModeTimeoutの場合はこの時間待ってからタイムアウトする
+	Latency time.Duration
+
+	mu      sync.Mutex
+	charges map[string]*ChargeResult
+	refunds map[string]*RefundResult
+}
+
+// NewFakeGateway FakeGatewayを生成する
+func NewFakeGateway(mode FakeMode) *FakeGateway {
+	return &FakeGateway{
+		mode:    mode,
+		charges: make(map[string]*ChargeResult),
+		refunds: make(map[string]*RefundResult),
+	}
+}
+
+// SetMode 動作モードを変更する
+func (g *FakeGateway) SetMode(mode FakeMode) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.mode = mode
+}
+
+// Charge 動作モードに従って課金をシミュレートする
+func (g *FakeGateway) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	if err := g.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch g.mode {
+	case FakeModeDecline:
+		return nil, &DeclinedError{Reason: "insufficient_funds"}
+	case FakeModeTimeout:
+		return nil, ErrGatewayTimeout
+	}
+
+	// 同じ冪等キーの場合は同じ取引を返す
+	if result, ok := g.charges[req.IdempotencyKey]; ok {
+		return result, nil
+	}
+	result := &ChargeResult{TransactionID: "txn-" + req.IdempotencyKey}
+	g.charges[req.IdempotencyKey] = result
+	return result, nil
+}
+
+// Refund 課金を返金する。同じ冪等キーの返金は一度だけ行われる
+func (g *FakeGateway) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	if err := g.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if result, ok := g.refunds[req.IdempotencyKey]; ok {
+		return result, nil
+	}
+	// 別インスタンスで課金された場合(Lambda間など)は取引IDがあれば課金済みとみなす
+	if _, ok := g.charges[req.IdempotencyKey]; !ok && req.TransactionID == "" {
+		return nil, ErrChargeNotFound
+	}
+	result := &RefundResult{RefundID: "rfd-" + req.IdempotencyKey}
+	g.refunds[req.IdempotencyKey] = result
+	return result, nil
+}
+
+// Refunded 指定した冪等キーの課金が返金済みかどうかを返す
+func (g *FakeGateway) Refunded(idempotencyKey string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.refunds[idempotencyKey]
+	return ok
+}
+
+// Charged 指定した冪等キーで課金済みかどうかを返す
+func (g *FakeGateway) Charged(idempotencyKey string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.charges[idempotencyKey]
+	return ok
+}
+
+// wait Latencyだけ待機する
+func (g *FakeGateway) wait(ctx context.Context) error {
+	if g.Latency <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(g.Latency)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrGatewayTimeout, ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
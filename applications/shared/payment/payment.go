@@ -0,0 +1,371 @@
+// Package payment はSagaの決済ステップ(process-payment)と補償処理(cancel-payment)で使用する決済処理を提供する
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Status 決済ステータス
+type Status string
+
+const (
+	// StatusPending 決済ゲートウェイ呼び出し前
+	StatusPending Status = "PENDING"
+	// StatusCompleted 決済完了
+	StatusCompleted Status = "COMPLETED"
+	// StatusDeclined 決済ゲートウェイで拒否された
+	StatusDeclined Status = "DECLINED"
+	// StatusFailed タイムアウト等で決済結果が確定しなかった
+	StatusFailed Status = "FAILED"
+	// StatusCancelled 補償処理により返金(取消)された
+	StatusCancelled Status = "CANCELLED"
+)
+
+var (
+	// ErrInvalidInput 入力値が不正
+	ErrInvalidInput = errors.New("invalid payment input")
+	// ErrPaymentDeclined 決済が拒否された
+	ErrPaymentDeclined = errors.New("payment declined")
+	// ErrNotFound 決済レコードが存在しない
+	ErrNotFound = errors.New("payment not found")
+	// ErrPaymentCancelled 補償処理で返金(取消)済みの決済を再度処理しようとした
+	ErrPaymentCancelled = errors.New("payment already cancelled")
+	// ErrConflict 決済レコードが他の呼び出しで決済済み・キャンセル済みに更新された
+	ErrConflict = errors.New("payment was finalized concurrently")
+)
+
+// ProcessPaymentInput process-paymentの入力(Step Functionsの実行入力)
+type ProcessPaymentInput struct {
+	OrderID            string `json:"order_id"`
+	UserID             string `json:"user_id"`
+	Amount             int64  `json:"amount"`
+	Currency           string `json:"currency"`
+	PaymentMethodToken string `json:"payment_method_token"`
+}
+
+// Validate 入力値を検証する
+func (in ProcessPaymentInput) Validate() error {
+	switch {
+	case in.OrderID == "":
+		return fmt.Errorf("%w: order_id is required", ErrInvalidInput)
+	case in.UserID == "":
+		return fmt.Errorf("%w: user_id is required", ErrInvalidInput)
+	case in.Amount <= 0:
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidInput)
+	case in.Currency == "":
+		return fmt.Errorf("%w: currency is required", ErrInvalidInput)
+	case in.PaymentMethodToken == "":
+		return fmt.Errorf("%w: payment_method_token is required", ErrInvalidInput)
+	}
+	return nil
+}
+
+// CancelPaymentInput cancel-paymentの入力。
+// process-paymentが決済レコードを返す前に失敗した場合、Paymentは空になる
+type CancelPaymentInput struct {
+	OrderID string   `json:"order_id"`
+	Payment *Payment `json:"payment,omitempty"`
+}
+
+// CancelPaymentOutput cancel-paymentの出力
+type CancelPaymentOutput struct {
+	OrderID   string `json:"order_id"`
+	PaymentID string `json:"payment_id"`
+	Status    Status `json:"status,omitempty"`
+	// Refunded 今回の呼び出しで返金を行ったかどうか
+	Refunded bool `json:"refunded"`
+}
+
+// Payment 決済レコード。process-paymentの出力として後続ステップへ渡される
+type Payment struct {
+	PaymentID     string    `json:"payment_id"`
+	OrderID       string    `json:"order_id"`
+	UserID        string    `json:"user_id"`
+	Amount        int64     `json:"amount"`
+	Currency      string    `json:"currency"`
+	Status        Status    `json:"status"`
+	TransactionID string    `json:"transaction_id,omitempty"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	RefundID      string    `json:"refund_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// PaymentIDFromOrderID 注文IDから決済IDを生成する。
+// 決定的に生成することで、リトライ時や補償処理時に同じレコードを参照できる
+func PaymentIDFromOrderID(orderID string) string {
+	return "pay-" + orderID
+}
+
+// Service 決済処理
+type Service struct {
+	gateway PaymentGateway
+	store   Store
+	now     func() time.Time
+}
+
+// NewService 決済処理を生成する
+func NewService(gateway PaymentGateway, store Store) *Service {
+	return &Service{
+		gateway: gateway,
+		store:   store,
+		now:     time.Now,
+	}
+}
+
+// Process 決済ゲートウェイで課金し、結果を決済レコードとして保存する
+func (s *Service) Process(ctx context.Context, in ProcessPaymentInput) (*Payment, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+
+	paymentID := PaymentIDFromOrderID(in.OrderID)
+
+	// 既に決済済み・キャンセル済みの場合は再課金せずに既存のレコードを返す
+	existing, err := s.store.Get(ctx, paymentID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("決済レコード取得エラー: %w", err)
+	}
+	if existing != nil && existing.Status.final() {
+		return finalResult(existing)
+	}
+
+	now := s.now()
+	p := &Payment{
+		PaymentID: paymentID,
+		OrderID:   in.OrderID,
+		UserID:    in.UserID,
+		Amount:    in.Amount,
+		Currency:  in.Currency,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if existing != nil {
+		p.CreatedAt = existing.CreatedAt
+	}
+
+	// ゲートウェイ呼び出し前にPENDINGで保存しておき、補償処理から参照できるようにする
+	if err := s.store.SaveUnlessFinal(ctx, p); err != nil {
+		return s.resolveConflict(ctx, paymentID, nil, err)
+	}
+
+	// 決済ゲートウェイで課金
+	result, chargeErr := s.gateway.Charge(ctx, ChargeRequest{
+		IdempotencyKey:     paymentID,
+		Amount:             in.Amount,
+		Currency:           in.Currency,
+		PaymentMethodToken: in.PaymentMethodToken,
+	})
+
+	p.UpdatedAt = s.now()
+	var declined *DeclinedError
+	switch {
+	case chargeErr == nil:
+		p.Status = StatusCompleted
+		p.TransactionID = result.TransactionID
+	case errors.As(chargeErr, &declined):
+		p.Status = StatusDeclined
+		p.FailureReason = declined.Reason
+	default:
+		p.Status = StatusFailed
+		p.FailureReason = chargeErr.Error()
+	}
+
+	// ゲートウェイ呼び出しがタイムアウトしていても結果は保存したいため、キャンセルされないコンテキストを使用。
+	// 課金中に補償処理でキャンセル済みになった場合は上書きしない
+	if err := s.store.SaveUnlessFinal(context.WithoutCancel(ctx), p); err != nil {
+		var charged *ChargeResult
+		if chargeErr == nil {
+			charged = result
+		}
+		return s.resolveConflict(context.WithoutCancel(ctx), paymentID, charged, err)
+	}
+
+	if declined != nil {
+		return p, fmt.Errorf("%w: %s", ErrPaymentDeclined, declined.Reason)
+	}
+	if chargeErr != nil {
+		return p, fmt.Errorf("決済ゲートウェイエラー: %w", chargeErr)
+	}
+
+	log.Printf("決済完了: payment_id=%s, transaction_id=%s", p.PaymentID, p.TransactionID)
+	return p, nil
+}
+
+// final 決済済み・キャンセル済みのように、process-paymentのリトライで上書きしてはいけないステータスかどうか
+func (st Status) final() bool {
+	return st == StatusCompleted || st == StatusCancelled
+}
+
+// finalResult 決済済み・キャンセル済みの決済レコードに対するprocess-paymentの結果を返す。
+// キャンセル済みの場合は再課金せずにErrPaymentCancelledを返す
+func finalResult(p *Payment) (*Payment, error) {
+	if p.Status == StatusCancelled {
+		log.Printf("キャンセル済みのため再課金しません: payment_id=%s", p.PaymentID)
+		return p, fmt.Errorf("%w: payment_id=%s", ErrPaymentCancelled, p.PaymentID)
+	}
+	log.Printf("決済済みのためスキップ: payment_id=%s", p.PaymentID)
+	return p, nil
+}
+
+// resolveConflict 決済レコードの保存時に他の呼び出しで決済済み・キャンセル済みになっていた場合、最新のレコードで結果を返す。
+// chargedは今回の呼び出しで成功した課金で、課金中にキャンセル済みになっていた場合は返金する
+func (s *Service) resolveConflict(ctx context.Context, paymentID string, charged *ChargeResult, err error) (*Payment, error) {
+	if !errors.Is(err, ErrConflict) {
+		return nil, fmt.Errorf("決済レコード保存エラー: %w", err)
+	}
+	latest, err := s.store.Get(ctx, paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("決済レコード取得エラー: %w", err)
+	}
+	if latest.Status == StatusCancelled && charged != nil {
+		// 補償処理が課金の記録前に実行された場合は課金が返金されていないため、ここで返金する
+		if err := s.refundLateCharge(ctx, latest, charged); err != nil {
+			return nil, err
+		}
+	}
+	return finalResult(latest)
+}
+
+// refundLateCharge キャンセル済みの決済に対して成功した課金を返金し、返金IDを決済レコードに保存する
+func (s *Service) refundLateCharge(ctx context.Context, p *Payment, charged *ChargeResult) error {
+	result, err := s.gateway.Refund(ctx, RefundRequest{
+		IdempotencyKey: p.PaymentID,
+		TransactionID:  charged.TransactionID,
+		Amount:         p.Amount,
+		Currency:       p.Currency,
+	})
+	if err != nil {
+		return fmt.Errorf("決済ゲートウェイエラー: %w", err)
+	}
+	p.TransactionID = charged.TransactionID
+	p.RefundID = result.RefundID
+	p.UpdatedAt = s.now()
+	if err := s.store.Save(ctx, p); err != nil {
+		return fmt.Errorf("決済レコード保存エラー: %w", err)
+	}
+	log.Printf("キャンセル済みの決済に対する課金を返金: payment_id=%s, refund_id=%s", p.PaymentID, p.RefundID)
+	return nil
+}
+
+// maxCancelAttempts 決済レコードが他の呼び出しで更新された場合に返金・キャンセルをやり直す回数
+const maxCancelAttempts = 3
+
+// Cancel 決済を返金(取消)し、決済レコードをキャンセル済みにする。
+// 何度呼び出しても結果は同じになり、決済レコードが存在しない場合は後から届いたprocess-paymentで課金されないよう
+// キャンセル済みの決済レコードを作成する
+func (s *Service) Cancel(ctx context.Context, in CancelPaymentInput) (*CancelPaymentOutput, error) {
+	var paymentID string
+	switch {
+	case in.Payment != nil && in.Payment.PaymentID != "":
+		paymentID = in.Payment.PaymentID
+	case in.OrderID != "":
+		paymentID = PaymentIDFromOrderID(in.OrderID)
+	default:
+		return nil, fmt.Errorf("%w: order_id or payment.payment_id is required", ErrInvalidInput)
+	}
+
+	out := &CancelPaymentOutput{OrderID: in.OrderID, PaymentID: paymentID}
+	for attempt := 1; ; attempt++ {
+		err := s.cancel(ctx, in, out)
+		if !errors.Is(err, ErrConflict) {
+			return out, err
+		}
+		// 返金中にprocess-paymentが決済レコードを更新した場合は、最新の状態で返金し直す
+		if attempt == maxCancelAttempts {
+			return nil, fmt.Errorf("決済レコード保存エラー: %w", err)
+		}
+		log.Printf("決済レコードが更新されたため返金をやり直します: payment_id=%s, attempt=%d", paymentID, attempt)
+	}
+}
+
+// cancel 決済レコードの最新状態に従って返金し、キャンセル済みにする。
+// 読み取った後に他の呼び出しで決済レコードが更新された場合はErrConflictを返す
+func (s *Service) cancel(ctx context.Context, in CancelPaymentInput, out *CancelPaymentOutput) error {
+	paymentID := out.PaymentID
+
+	// 入力の決済レコードは古い可能性があるため、ストアの最新状態を参照する
+	p, err := s.store.Get(ctx, paymentID)
+	if errors.Is(err, ErrNotFound) {
+		return s.createTombstone(ctx, in, out)
+	}
+	if err != nil {
+		return fmt.Errorf("決済レコード取得エラー: %w", err)
+	}
+	prev := *p
+	out.OrderID = p.OrderID
+	out.Status = p.Status
+
+	switch p.Status {
+	case StatusCancelled:
+		log.Printf("返金済みのためスキップ: payment_id=%s", paymentID)
+		return nil
+	case StatusDeclined:
+		// 課金されていないため返金不要。補償後のprocess-paymentで再課金されないようキャンセル済みにする
+		log.Printf("決済が拒否されているため返金不要: payment_id=%s", paymentID)
+	default:
+		// 決済ゲートウェイで返金。PENDING・FAILEDの場合は課金有無が不明なため冪等キーで取り消す
+		result, err := s.gateway.Refund(ctx, RefundRequest{
+			IdempotencyKey: paymentID,
+			TransactionID:  p.TransactionID,
+			Amount:         p.Amount,
+			Currency:       p.Currency,
+		})
+		switch {
+		case err == nil:
+			p.RefundID = result.RefundID
+			out.Refunded = true
+		case errors.Is(err, ErrChargeNotFound):
+			log.Printf("課金が存在しないため返金不要: payment_id=%s", paymentID)
+		default:
+			return fmt.Errorf("決済ゲートウェイエラー: %w", err)
+		}
+	}
+
+	// 返金中に課金されて決済済みになった場合に上書きしないよう、読み取った状態から変わっていない場合のみ保存する
+	p.Status = StatusCancelled
+	p.UpdatedAt = s.now()
+	if err := s.store.SaveIfUnchanged(context.WithoutCancel(ctx), p, &prev); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return err
+		}
+		return fmt.Errorf("決済レコード保存エラー: %w", err)
+	}
+	out.Status = p.Status
+
+	log.Printf("決済キャンセル完了: payment_id=%s, refund_id=%s", p.PaymentID, p.RefundID)
+	return nil
+}
+
+// createTombstone 決済レコードが存在しない場合に、キャンセル済みの決済レコードを作成する。
+// 実行中・リトライ待ちのprocess-paymentはこのレコードにより課金しない
+func (s *Service) createTombstone(ctx context.Context, in CancelPaymentInput, out *CancelPaymentOutput) error {
+	now := s.now()
+	p := &Payment{
+		PaymentID: out.PaymentID,
+		OrderID:   in.OrderID,
+		Status:    StatusCancelled,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if in.Payment != nil {
+		p.UserID = in.Payment.UserID
+		p.Amount = in.Payment.Amount
+		p.Currency = in.Payment.Currency
+	}
+	if err := s.store.Create(context.WithoutCancel(ctx), p); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return err
+		}
+		return fmt.Errorf("決済レコード保存エラー: %w", err)
+	}
+	out.Status = p.Status
+
+	log.Printf("決済レコードが存在しないため返金せずにキャンセル済みにしました: payment_id=%s", out.PaymentID)
+	return nil
+}
@@ -0,0 +1,362 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestInput() ProcessPaymentInput {
+	return ProcessPaymentInput{
+		OrderID:            "order-1",
+		UserID:             "user-1",
+		Amount:             1000,
+		Currency:           "JPY",
+		PaymentMethodToken: "tok_visa",
+	}
+}
+
+func TestServiceProcess(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       FakeMode
+		wantErr    error
+		wantStatus Status
+	}{
+		{name: "succeed", mode: FakeModeSucceed, wantStatus: StatusCompleted},
+		{name: "decline", mode: FakeModeDecline, wantErr: ErrPaymentDeclined, wantStatus: StatusDeclined},
+		{name: "timeout", mode: FakeModeTimeout, wantErr: ErrGatewayTimeout, wantStatus: StatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			service := NewService(NewFakeGateway(tt.mode), store)
+
+			_, err := service.Process(context.Background(), newTestInput())
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Process() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// 結果に関わらず決済レコードが保存されていること
+			saved, err := store.Get(context.Background(), PaymentIDFromOrderID("order-1"))
+			if err != nil {
+				t.Fatalf("Get() returned an error: %v", err)
+			}
+			if saved.Status != tt.wantStatus {
+				t.Errorf("saved status = %q, want %q", saved.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServiceProcessIsRetrySafe(t *testing.T) {
+	store := NewMemoryStore()
+	gateway := NewFakeGateway(FakeModeSucceed)
+	service := NewService(gateway, store)
+
+	first, err := service.Process(context.Background(), newTestInput())
+	if err != nil {
+		t.Fatalf("Process() returned an error: %v", err)
+	}
+
+	// 決済済みの場合はゲートウェイを呼び出さずに同じ結果を返す
+	gateway.SetMode(FakeModeDecline)
+	second, err := service.Process(context.Background(), newTestInput())
+	if err != nil {
+		t.Fatalf("Process() returned an error on retry: %v", err)
+	}
+	if second.TransactionID != first.TransactionID {
+		t.Errorf("TransactionID = %q, want %q", second.TransactionID, first.TransactionID)
+	}
+}
+
+func TestServiceProcessAfterCancel(t *testing.T) {
+	store := NewMemoryStore()
+	gateway := NewFakeGateway(FakeModeSucceed)
+	service := NewService(gateway, store)
+
+	p, err := service.Process(context.Background(), newTestInput())
+	if err != nil {
+		t.Fatalf("Process() returned an error: %v", err)
+	}
+	if _, err := service.Cancel(context.Background(), CancelPaymentInput{OrderID: p.OrderID, Payment: p}); err != nil {
+		t.Fatalf("Cancel() returned an error: %v", err)
+	}
+
+	// 補償処理の後に届いたリトライでは再課金せず、キャンセル済みのレコードを上書きしない
+	gateway.SetMode(FakeModeTimeout)
+	retried, err := service.Process(context.Background(), newTestInput())
+	if !errors.Is(err, ErrPaymentCancelled) {
+		t.Errorf("Process() error = %v, want %v", err, ErrPaymentCancelled)
+	}
+	if retried == nil || retried.Status != StatusCancelled {
+		t.Errorf("Process() = %+v, want the cancelled payment", retried)
+	}
+	saved, err := store.Get(context.Background(), p.PaymentID)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if saved.Status != StatusCancelled || saved.RefundID == "" {
+		t.Errorf("saved = %+v, want cancelled with a refund", saved)
+	}
+}
+
+// cancellingGateway 課金の直後に補償処理を実行するゲートウェイ(課金中にcancel-paymentが実行されたケース)
+type cancellingGateway struct {
+	*FakeGateway
+	cancel func()
+}
+
+func (g *cancellingGateway) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	result, err := g.FakeGateway.Charge(ctx, req)
+	g.cancel()
+	return result, err
+}
+
+func TestServiceProcessCancelledDuringCharge(t *testing.T) {
+	store := NewMemoryStore()
+	gateway := &cancellingGateway{FakeGateway: NewFakeGateway(FakeModeSucceed)}
+	service := NewService(gateway, store)
+	gateway.cancel = func() {
+		if _, err := service.Cancel(context.Background(), CancelPaymentInput{OrderID: "order-1"}); err != nil {
+			t.Fatalf("Cancel() returned an error: %v", err)
+		}
+	}
+
+	// 課金結果でキャンセル済みのレコードを上書きしない
+	if _, err := service.Process(context.Background(), newTestInput()); !errors.Is(err, ErrPaymentCancelled) {
+		t.Errorf("Process() error = %v, want %v", err, ErrPaymentCancelled)
+	}
+	saved, err := store.Get(context.Background(), PaymentIDFromOrderID("order-1"))
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if saved.Status != StatusCancelled {
+		t.Errorf("saved status = %q, want %q", saved.Status, StatusCancelled)
+	}
+}
+
+// cancelBeforeChargeGateway 課金がゲートウェイに記録される前に補償処理を実行するゲートウェイ
+type cancelBeforeChargeGateway struct {
+	*FakeGateway
+	cancel func()
+}
+
+func (g *cancelBeforeChargeGateway) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	g.cancel()
+	return g.FakeGateway.Charge(ctx, req)
+}
+
+func TestServiceProcessCancelledBeforeChargeRecorded(t *testing.T) {
+	store := NewMemoryStore()
+	fake := NewFakeGateway(FakeModeSucceed)
+	gateway := &cancelBeforeChargeGateway{FakeGateway: fake}
+	service := NewService(gateway, store)
+	gateway.cancel = func() {
+		out, err := service.Cancel(context.Background(), CancelPaymentInput{OrderID: "order-1"})
+		if err != nil {
+			t.Fatalf("Cancel() returned an error: %v", err)
+		}
+		if out.Refunded {
+			t.Errorf("Cancel() = %+v, want cancelled without refund", out)
+		}
+	}
+
+	// 補償処理の後に成功した課金は返金される
+	if _, err := service.Process(context.Background(), newTestInput()); !errors.Is(err, ErrPaymentCancelled) {
+		t.Errorf("Process() error = %v, want %v", err, ErrPaymentCancelled)
+	}
+	paymentID := PaymentIDFromOrderID("order-1")
+	if !fake.Charged(paymentID) || !fake.Refunded(paymentID) {
+		t.Errorf("charged = %v, refunded = %v, want both", fake.Charged(paymentID), fake.Refunded(paymentID))
+	}
+	saved, err := store.Get(context.Background(), paymentID)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if saved.Status != StatusCancelled || saved.RefundID == "" {
+		t.Errorf("saved = %+v, want cancelled with a refund", saved)
+	}
+}
+
+func TestServiceProcessInvalidInput(t *testing.T) {
+	service := NewService(NewFakeGateway(FakeModeSucceed), NewMemoryStore())
+
+	in := newTestInput()
+	in.Amount = 0
+	if _, err := service.Process(context.Background(), in); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Process() error = %v, want %v", err, ErrInvalidInput)
+	}
+}
+
+func TestServiceCancel(t *testing.T) {
+	store := NewMemoryStore()
+	gateway := NewFakeGateway(FakeModeSucceed)
+	service := NewService(gateway, store)
+
+	p, err := service.Process(context.Background(), newTestInput())
+	if err != nil {
+		t.Fatalf("Process() returned an error: %v", err)
+	}
+
+	out, err := service.Cancel(context.Background(), CancelPaymentInput{OrderID: p.OrderID, Payment: p})
+	if err != nil {
+		t.Fatalf("Cancel() returned an error: %v", err)
+	}
+	if !out.Refunded || out.Status != StatusCancelled {
+		t.Errorf("Cancel() = %+v, want refunded and cancelled", out)
+	}
+	if !gateway.Refunded(p.PaymentID) {
+		t.Errorf("gateway has no refund for %q", p.PaymentID)
+	}
+
+	// 2回目の呼び出しは何もせずに成功する
+	out, err = service.Cancel(context.Background(), CancelPaymentInput{OrderID: p.OrderID, Payment: p})
+	if err != nil {
+		t.Fatalf("Cancel() returned an error on retry: %v", err)
+	}
+	if out.Refunded || out.Status != StatusCancelled {
+		t.Errorf("Cancel() on retry = %+v, want no-op", out)
+	}
+}
+
+func TestServiceCancelWithoutPaymentRecord(t *testing.T) {
+	service := NewService(NewFakeGateway(FakeModeSucceed), NewMemoryStore())
+
+	// process-paymentが決済レコードを作成する前に失敗したケース
+	out, err := service.Cancel(context.Background(), CancelPaymentInput{OrderID: "order-1"})
+	if err != nil {
+		t.Fatalf("Cancel() returned an error: %v", err)
+	}
+	if out.Refunded || out.Status != StatusCancelled {
+		t.Errorf("Cancel() = %+v, want cancelled without refund", out)
+	}
+}
+
+func TestServiceProcessAfterCancelWithoutPaymentRecord(t *testing.T) {
+	gateway := NewFakeGateway(FakeModeSucceed)
+	service := NewService(gateway, NewMemoryStore())
+
+	// 補償処理の後に実行中・リトライ待ちだったprocess-paymentが実行されたケース
+	if _, err := service.Cancel(context.Background(), CancelPaymentInput{OrderID: "order-1"}); err != nil {
+		t.Fatalf("Cancel() returned an error: %v", err)
+	}
+	if _, err := service.Process(context.Background(), newTestInput()); !errors.Is(err, ErrPaymentCancelled) {
+		t.Errorf("Process() error = %v, want %v", err, ErrPaymentCancelled)
+	}
+	if gateway.Charged(PaymentIDFromOrderID("order-1")) {
+		t.Error("gateway charged a cancelled payment")
+	}
+}
+
+// chargingDuringRefundGateway 返金の直後に課金を完了させるゲートウェイ(返金中にprocess-paymentが課金したケース)
+type chargingDuringRefundGateway struct {
+	*FakeGateway
+	charge func()
+}
+
+func (g *chargingDuringRefundGateway) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	result, err := g.FakeGateway.Refund(ctx, req)
+	if g.charge != nil {
+		g.charge()
+		g.charge = nil
+	}
+	return result, err
+}
+
+func TestServiceCancelChargedDuringRefund(t *testing.T) {
+	store := NewMemoryStore()
+	fake := NewFakeGateway(FakeModeSucceed)
+	gateway := &chargingDuringRefundGateway{FakeGateway: fake}
+	service := NewService(gateway, store)
+	ctx := context.Background()
+
+	in := newTestInput()
+	paymentID := PaymentIDFromOrderID(in.OrderID)
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	pending := &Payment{PaymentID: paymentID, OrderID: in.OrderID, UserID: in.UserID, Amount: in.Amount, Currency: in.Currency,
+		Status: StatusPending, CreatedAt: created, UpdatedAt: created}
+	if err := store.Save(ctx, pending); err != nil {
+		t.Fatalf("Save() returned an error: %v", err)
+	}
+	// 課金前の返金はErrChargeNotFoundになり、その後にprocess-paymentが課金して決済済みで保存する
+	gateway.charge = func() {
+		result, err := fake.Charge(ctx, ChargeRequest{IdempotencyKey: paymentID, Amount: in.Amount, Currency: in.Currency, PaymentMethodToken: in.PaymentMethodToken})
+		if err != nil {
+			t.Fatalf("Charge() returned an error: %v", err)
+		}
+		completed := *pending
+		completed.Status = StatusCompleted
+		completed.TransactionID = result.TransactionID
+		completed.UpdatedAt = created.Add(time.Second)
+		if err := store.SaveUnlessFinal(ctx, &completed); err != nil {
+			t.Fatalf("SaveUnlessFinal() returned an error: %v", err)
+		}
+	}
+
+	out, err := service.Cancel(ctx, CancelPaymentInput{OrderID: in.OrderID})
+	if err != nil {
+		t.Fatalf("Cancel() returned an error: %v", err)
+	}
+	if !out.Refunded || out.Status != StatusCancelled {
+		t.Errorf("Cancel() = %+v, want refunded and cancelled", out)
+	}
+	if !fake.Refunded(paymentID) {
+		t.Errorf("gateway has no refund for %q", paymentID)
+	}
+	saved, err := store.Get(ctx, paymentID)
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if saved.Status != StatusCancelled || saved.RefundID == "" {
+		t.Errorf("saved = %+v, want cancelled with a refund", saved)
+	}
+}
+
+func TestServiceCancelAfterTimeout(t *testing.T) {
+	store := NewMemoryStore()
+	service := NewService(NewFakeGateway(FakeModeTimeout), store)
+
+	if _, err := service.Process(context.Background(), newTestInput()); err == nil {
+		t.Fatal("Process() returned no error")
+	}
+
+	// 課金されていない場合も決済レコードはキャンセル済みになる
+	out, err := service.Cancel(context.Background(), CancelPaymentInput{OrderID: "order-1"})
+	if err != nil {
+		t.Fatalf("Cancel() returned an error: %v", err)
+	}
+	if out.Refunded || out.Status != StatusCancelled {
+		t.Errorf("Cancel() = %+v, want cancelled without refund", out)
+	}
+}
+
+func TestServiceCancelAfterDecline(t *testing.T) {
+	store := NewMemoryStore()
+	gateway := NewFakeGateway(FakeModeDecline)
+	service := NewService(gateway, store)
+
+	if _, err := service.Process(context.Background(), newTestInput()); !errors.Is(err, ErrPaymentDeclined) {
+		t.Fatalf("Process() error = %v, want %v", err, ErrPaymentDeclined)
+	}
+
+	// 拒否された決済は返金せずにキャンセル済みになる
+	out, err := service.Cancel(context.Background(), CancelPaymentInput{OrderID: "order-1"})
+	if err != nil {
+		t.Fatalf("Cancel() returned an error: %v", err)
+	}
+	if out.Refunded || out.Status != StatusCancelled {
+		t.Errorf("Cancel() = %+v, want cancelled without refund", out)
+	}
+
+	// 補償処理の後に届いたリトライでは課金しない
+	gateway.SetMode(FakeModeSucceed)
+	if _, err := service.Process(context.Background(), newTestInput()); !errors.Is(err, ErrPaymentCancelled) {
+		t.Errorf("Process() error = %v, want %v", err, ErrPaymentCancelled)
+	}
+	if gateway.Charged(PaymentIDFromOrderID("order-1")) {
+		t.Error("gateway charged a cancelled payment")
+	}
+}
@@ -0,0 +1,239 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/internal/ddbattr"
+)
+
+// Store 決済レコードの永続化
+type Store interface {
+	// Get 決済レコードを取得する。存在しない場合はErrNotFoundを返す
+	Get(ctx context.Context, paymentID string) (*Payment, error)
+	// Save 決済レコードを保存する
+	Save(ctx context.Context, p *Payment) error
+	// SaveUnlessFinal 保存済みの決済レコードが決済済み・キャンセル済みでない場合のみ保存する。
+	// 決済済み・キャンセル済みの場合はErrConflictを返す
+	SaveUnlessFinal(ctx context.Context, p *Payment) error
+	// Create 決済レコードが存在しない場合のみ保存する。存在する場合はErrConflictを返す
+	Create(ctx context.Context, p *Payment) error
+	// SaveIfUnchanged 保存済みの決済レコードのステータスと更新日時がprevと同じ場合のみ保存する。
+	// 他の呼び出しで更新された場合はErrConflictを返す
+	SaveIfUnchanged(ctx context.Context, p *Payment, prev *Payment) error
+}
+
+// MemoryStore テスト・ローカル実行用のインメモリストア
+type MemoryStore struct {
+	mu       sync.Mutex
+	payments map[string]Payment
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{payments: make(map[string]Payment)}
+}
+
+// Get 決済レコードを取得する
+func (s *MemoryStore) Get(_ context.Context, paymentID string) (*Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.payments[paymentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+// Save 決済レコードを保存する
+func (s *MemoryStore) Save(_ context.Context, p *Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payments[p.PaymentID] = *p
+	return nil
+}
+
+// SaveUnlessFinal 保存済みの決済レコードが決済済み・キャンセル済みでない場合のみ保存する
+func (s *MemoryStore) SaveUnlessFinal(_ context.Context, p *Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.payments[p.PaymentID]; ok && existing.Status.final() {
+		return ErrConflict
+	}
+	s.payments[p.PaymentID] = *p
+	return nil
+}
+
+// Create 決済レコードが存在しない場合のみ保存する
+func (s *MemoryStore) Create(_ context.Context, p *Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.payments[p.PaymentID]; ok {
+		return ErrConflict
+	}
+	s.payments[p.PaymentID] = *p
+	return nil
+}
+
+// SaveIfUnchanged 保存済みの決済レコードのステータスと更新日時がprevと同じ場合のみ保存する
+func (s *MemoryStore) SaveIfUnchanged(_ context.Context, p *Payment, prev *Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.payments[p.PaymentID]
+	if !ok || existing.Status != prev.Status || !existing.UpdatedAt.Equal(prev.UpdatedAt) {
+		return ErrConflict
+	}
+	s.payments[p.PaymentID] = *p
+	return nil
+}
+
+// DynamoDBAPI DynamoDBStoreが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// DynamoDBStore DynamoDBのpaymentsテーブルを使用するストア
+type DynamoDBStore struct {
+	client    DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBStore DynamoDBStoreを生成する
+func NewDynamoDBStore(client DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName}
+}
+
+// Get 決済レコードを取得する
+func (s *DynamoDBStore) Get(ctx context.Context, paymentID string) (*Payment, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"payment_id": ddbattr.S(paymentID),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	return unmarshalPayment(result.Item)
+}
+
+// Save 決済レコードを保存する
+func (s *DynamoDBStore) Save(ctx context.Context, p *Payment) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      marshalPayment(p),
+	})
+	return err
+}
+
+// SaveUnlessFinal 決済レコードを条件付きで保存する
+func (s *DynamoDBStore) SaveUnlessFinal(ctx context.Context, p *Payment) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                marshalPayment(p),
+		ConditionExpression: aws.String("attribute_not_exists(payment_id) OR NOT (#status IN (:completed, :cancelled))"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":completed": ddbattr.S(string(StatusCompleted)),
+			":cancelled": ddbattr.S(string(StatusCancelled)),
+		},
+	})
+	return conflict(err)
+}
+
+// Create 決済レコードが存在しない場合のみ保存する
+func (s *DynamoDBStore) Create(ctx context.Context, p *Payment) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                marshalPayment(p),
+		ConditionExpression: aws.String("attribute_not_exists(payment_id)"),
+	})
+	return conflict(err)
+}
+
+// SaveIfUnchanged ステータスと更新日時を条件に決済レコードを保存する
+func (s *DynamoDBStore) SaveIfUnchanged(ctx context.Context, p *Payment, prev *Payment) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                marshalPayment(p),
+		ConditionExpression: aws.String("#status = :status AND updated_at = :updated_at"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":     ddbattr.S(string(prev.Status)),
+			":updated_at": ddbattr.Time(prev.UpdatedAt),
+		},
+	})
+	return conflict(err)
+}
+
+// conflict 条件付き書き込みの条件を満たさなかった場合にErrConflictに変換する
+func conflict(err error) error {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrConflict
+	}
+	return err
+}
+
+// marshalPayment 決済レコードをDynamoDBアイテムに変換する
+func marshalPayment(p *Payment) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"payment_id": ddbattr.S(p.PaymentID),
+		"order_id":   ddbattr.S(p.OrderID),
+		"user_id":    ddbattr.S(p.UserID),
+		"amount":     ddbattr.N(p.Amount),
+		"currency":   ddbattr.S(p.Currency),
+		"status":     ddbattr.S(string(p.Status)),
+		"created_at": ddbattr.Time(p.CreatedAt),
+		"updated_at": ddbattr.Time(p.UpdatedAt),
+	}
+	if p.TransactionID != "" {
+		item["transaction_id"] = ddbattr.S(p.TransactionID)
+	}
+	if p.FailureReason != "" {
+		item["failure_reason"] = ddbattr.S(p.FailureReason)
+	}
+	if p.RefundID != "" {
+		item["refund_id"] = ddbattr.S(p.RefundID)
+	}
+	return item
+}
+
+// unmarshalPayment DynamoDBアイテムを決済レコードに変換する
+func unmarshalPayment(item map[string]types.AttributeValue) (*Payment, error) {
+	p := &Payment{
+		PaymentID:     ddbattr.String(item, "payment_id"),
+		OrderID:       ddbattr.String(item, "order_id"),
+		UserID:        ddbattr.String(item, "user_id"),
+		Currency:      ddbattr.String(item, "currency"),
+		Status:        Status(ddbattr.String(item, "status")),
+		TransactionID: ddbattr.String(item, "transaction_id"),
+		FailureReason: ddbattr.String(item, "failure_reason"),
+		RefundID:      ddbattr.String(item, "refund_id"),
+	}
+
+	var err error
+	if p.Amount, err = ddbattr.Int64(item, "amount"); err != nil {
+		return nil, err
+	}
+	if p.CreatedAt, err = ddbattr.ParseTime(item, "created_at"); err != nil {
+		return nil, err
+	}
+	if p.UpdatedAt, err = ddbattr.ParseTime(item, "updated_at"); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
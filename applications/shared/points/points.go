@@ -0,0 +1,194 @@
+// Package points はSagaのポイント付与ステップ(award-points)で使用するポイント台帳を提供する
+package points
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+)
+
+// EntryType 台帳エントリの種別
+type EntryType string
+
+const (
+	// EntryTypeEarn 購入によるポイント獲得
+	EntryTypeEarn EntryType = "EARN"
+	// EntryTypeReversal 補償処理によるポイント取消
+	EntryTypeReversal EntryType = "REVERSAL"
+)
+
+// DefaultRate 購入金額に対するポイント付与率の既定値(1%)
+const DefaultRate = 0.01
+
+var (
+	// ErrInvalidInput 入力値が不正
+	ErrInvalidInput = errors.New("invalid points input")
+	// ErrNotFound 台帳エントリが存在しない
+	ErrNotFound = errors.New("ledger entry not found")
+	// ErrAlreadyExists 台帳エントリが既に存在する
+	ErrAlreadyExists = errors.New("ledger entry already exists")
+	// ErrConflict 条件としたエントリが台帳に存在するため追記できない
+	ErrConflict = errors.New("conflicting ledger entry exists")
+	// ErrOrderReversed 補償処理でポイントを取り消した注文にポイントを付与しようとした
+	ErrOrderReversed = errors.New("points already reversed for order")
+)
+
+// AwardPointsInput award-pointsの入力。Paymentはprocess-paymentの出力
+type AwardPointsInput struct {
+	OrderID string           `json:"order_id"`
+	UserID  string           `json:"user_id"`
+	Amount  int64            `json:"amount"`
+	Payment *payment.Payment `json:"payment,omitempty"`
+}
+
+// ReversePointsInput reverse-pointsの入力
+type ReversePointsInput struct {
+	OrderID string `json:"order_id"`
+	UserID  string `json:"user_id"`
+}
+
+// ReversePointsOutput reverse-pointsの出力
+type ReversePointsOutput struct {
+	OrderID string `json:"order_id"`
+	UserID  string `json:"user_id"`
+	// Reversed 今回の呼び出しでポイントを取り消したかどうか
+	Reversed bool   `json:"reversed"`
+	Entry    *Entry `json:"entry,omitempty"`
+}
+
+// Entry ポイント台帳のエントリ。追記のみで更新・削除はしない
+type Entry struct {
+	UserID    string    `json:"user_id"`
+	EntryID   string    `json:"entry_id"`
+	Type      EntryType `json:"type"`
+	OrderID   string    `json:"order_id"`
+	Points    int64     `json:"points"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EntryID 注文IDと種別から台帳エントリIDを生成する。同じ注文に同じ種別のエントリは1件のみ
+func EntryID(entryType EntryType, orderID string) string {
+	return string(entryType) + "#" + orderID
+}
+
+// Service ポイント台帳の操作
+type Service struct {
+	store Store
+	rate  float64
+	now   func() time.Time
+}
+
+// NewService ポイント台帳の操作を生成する。rateは購入金額に対するポイント付与率
+func NewService(store Store, rate float64) *Service {
+	return &Service{store: store, rate: rate, now: time.Now}
+}
+
+// Award 購入金額からポイントを計算して付与する。同じ注文への付与は一度だけ行われる。
+// 補償処理で取り消し済みの注文の場合は付与せずにErrOrderReversedを返す
+func (s *Service) Award(ctx context.Context, in AwardPointsInput) (*Entry, error) {
+	amount := in.Amount
+	if amount == 0 && in.Payment != nil {
+		amount = in.Payment.Amount
+	}
+	switch {
+	case in.OrderID == "":
+		return nil, fmt.Errorf("%w: order_id is required", ErrInvalidInput)
+	case in.UserID == "":
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidInput)
+	case amount <= 0:
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidInput)
+	}
+
+	entry := &Entry{
+		UserID:    in.UserID,
+		EntryID:   EntryID(EntryTypeEarn, in.OrderID),
+		Type:      EntryTypeEarn,
+		OrderID:   in.OrderID,
+		Points:    int64(math.Floor(float64(amount) * s.rate)),
+		CreatedAt: s.now(),
+	}
+
+	// 台帳への追記と残高の更新を1つのトランザクションで行う。
+	// 補償処理の後に届いたリトライで付与しないよう、取消エントリが存在しないことを条件とする
+	err := s.store.Append(ctx, entry, EntryID(EntryTypeReversal, in.OrderID))
+	if errors.Is(err, ErrAlreadyExists) {
+		log.Printf("ポイント付与済みのためスキップ: order_id=%s", in.OrderID)
+		return s.store.GetEntry(ctx, in.UserID, entry.EntryID)
+	}
+	if errors.Is(err, ErrConflict) {
+		log.Printf("ポイント取消済みのため付与しません: order_id=%s", in.OrderID)
+		return nil, fmt.Errorf("%w: order_id=%s", ErrOrderReversed, in.OrderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ポイント付与エラー: %w", err)
+	}
+
+	log.Printf("ポイント付与完了: order_id=%s, points=%d", in.OrderID, entry.Points)
+	return entry, nil
+}
+
+// Reverse 補償処理として注文で付与したポイントを取り消す。
+// 何度呼び出しても結果は同じになる。ポイントが付与されていない場合も0ポイントの取消エントリを追記し、後から届いた付与を防ぐ
+func (s *Service) Reverse(ctx context.Context, in ReversePointsInput) (*ReversePointsOutput, error) {
+	switch {
+	case in.OrderID == "":
+		return nil, fmt.Errorf("%w: order_id is required", ErrInvalidInput)
+	case in.UserID == "":
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidInput)
+	}
+
+	out := &ReversePointsOutput{OrderID: in.OrderID, UserID: in.UserID}
+
+	entry := &Entry{
+		UserID:    in.UserID,
+		EntryID:   EntryID(EntryTypeReversal, in.OrderID),
+		Type:      EntryTypeReversal,
+		OrderID:   in.OrderID,
+		CreatedAt: s.now(),
+	}
+
+	earnID := EntryID(EntryTypeEarn, in.OrderID)
+	earn, err := s.store.GetEntry(ctx, in.UserID, earnID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// 付与前に取消エントリを追記する。その間に付与された場合は競合するため取り消し直す
+		log.Printf("ポイントが付与されていないため0ポイントで取消: order_id=%s", in.OrderID)
+		err = s.store.Append(ctx, entry, earnID)
+		if errors.Is(err, ErrConflict) {
+			log.Printf("取消中にポイントが付与されたため取り消し直します: order_id=%s", in.OrderID)
+			return s.Reverse(ctx, in)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("台帳エントリ取得エラー: %w", err)
+	default:
+		entry.Points = -earn.Points
+		err = s.store.Append(ctx, entry, "")
+	}
+
+	if errors.Is(err, ErrAlreadyExists) {
+		log.Printf("ポイント取消済みのためスキップ: order_id=%s", in.OrderID)
+		out.Entry, err = s.store.GetEntry(ctx, in.UserID, entry.EntryID)
+		if err != nil {
+			return nil, fmt.Errorf("台帳エントリ取得エラー: %w", err)
+		}
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ポイント取消エラー: %w", err)
+	}
+	out.Reversed = entry.Points != 0
+	out.Entry = entry
+
+	log.Printf("ポイント取消完了: order_id=%s, points=%d", in.OrderID, entry.Points)
+	return out, nil
+}
+
+// Balance ユーザーのポイント残高を取得する
+func (s *Service) Balance(ctx context.Context, userID string) (int64, error) {
+	return s.store.GetBalance(ctx, userID)
+}
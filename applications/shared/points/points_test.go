@@ -0,0 +1,84 @@
+package points
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestServiceAwardIsIdempotent(t *testing.T) {
+	service := NewService(NewMemoryStore(), DefaultRate)
+	in := AwardPointsInput{OrderID: "order-1", UserID: "user-1", Amount: 1550}
+
+	for i := 0; i < 2; i++ {
+		entry, err := service.Award(context.Background(), in)
+		if err != nil {
+			t.Fatalf("Award() #%d returned an error: %v", i, err)
+		}
+		if entry.Points != 15 {
+			t.Errorf("Award() #%d Points = %d, want %d", i, entry.Points, 15)
+		}
+	}
+
+	balance, err := service.Balance(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("Balance() returned an error: %v", err)
+	}
+	if balance != 15 {
+		t.Errorf("Balance() = %d, want %d", balance, 15)
+	}
+}
+
+func TestServiceReverse(t *testing.T) {
+	service := NewService(NewMemoryStore(), 0.1)
+	ctx := context.Background()
+
+	if _, err := service.Award(ctx, AwardPointsInput{OrderID: "order-1", UserID: "user-1", Amount: 1000}); err != nil {
+		t.Fatalf("Award() returned an error: %v", err)
+	}
+	if _, err := service.Award(ctx, AwardPointsInput{OrderID: "order-2", UserID: "user-1", Amount: 500}); err != nil {
+		t.Fatalf("Award() returned an error: %v", err)
+	}
+
+	for i, want := range []bool{true, false} {
+		out, err := service.Reverse(ctx, ReversePointsInput{OrderID: "order-1", UserID: "user-1"})
+		if err != nil {
+			t.Fatalf("Reverse() #%d returned an error: %v", i, err)
+		}
+		if out.Reversed != want || out.Entry.Points != -100 {
+			t.Errorf("Reverse() #%d = %+v, want reversed=%t points=-100", i, out, want)
+		}
+	}
+
+	balance, err := service.Balance(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("Balance() returned an error: %v", err)
+	}
+	if balance != 50 {
+		t.Errorf("Balance() = %d, want %d", balance, 50)
+	}
+}
+
+func TestServiceReverseWithoutAward(t *testing.T) {
+	service := NewService(NewMemoryStore(), DefaultRate)
+
+	out, err := service.Reverse(context.Background(), ReversePointsInput{OrderID: "order-1", UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("Reverse() returned an error: %v", err)
+	}
+	if out.Reversed {
+		t.Errorf("Reversed = true, want false")
+	}
+
+	// 補償処理の後に届いた付与は行わない
+	if _, err := service.Award(context.Background(), AwardPointsInput{OrderID: "order-1", UserID: "user-1", Amount: 1000}); !errors.Is(err, ErrOrderReversed) {
+		t.Errorf("Award() error = %v, want %v", err, ErrOrderReversed)
+	}
+	balance, err := service.Balance(context.Background(), "user-1")
+	if err != nil {
+		t.Fatalf("Balance() returned an error: %v", err)
+	}
+	if balance != 0 {
+		t.Errorf("Balance() = %d, want %d", balance, 0)
+	}
+}
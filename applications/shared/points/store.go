@@ -0,0 +1,200 @@
+package points
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/internal/ddbattr"
+)
+
+// Store ポイント台帳と残高の永続化
+type Store interface {
+	// Append 台帳にエントリを追記し、残高に加算する。エントリが既に存在する場合はErrAlreadyExistsを返す。
+	// unlessEntryIDが空でなく、同じユーザーにそのエントリが存在する場合は追記せずにErrConflictを返す
+	Append(ctx context.Context, entry *Entry, unlessEntryID string) error
+	// GetEntry 台帳エントリを取得する。存在しない場合はErrNotFoundを返す
+	GetEntry(ctx context.Context, userID, entryID string) (*Entry, error)
+	// GetBalance ポイント残高を取得する。台帳エントリがない場合は0
+	GetBalance(ctx context.Context, userID string) (int64, error)
+}
+
+// MemoryStore テスト・ローカル実行用のインメモリストア
+type MemoryStore struct {
+	mu       sync.Mutex
+	entries  map[string]map[string]Entry
+	balances map[string]int64
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:  make(map[string]map[string]Entry),
+		balances: make(map[string]int64),
+	}
+}
+
+// Append 台帳にエントリを追記し、残高に加算する
+func (s *MemoryStore) Append(_ context.Context, entry *Entry, unlessEntryID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[entry.UserID][entry.EntryID]; ok {
+		return ErrAlreadyExists
+	}
+	if _, ok := s.entries[entry.UserID][unlessEntryID]; ok && unlessEntryID != "" {
+		return ErrConflict
+	}
+	if s.entries[entry.UserID] == nil {
+		s.entries[entry.UserID] = make(map[string]Entry)
+	}
+	s.entries[entry.UserID][entry.EntryID] = *entry
+	s.balances[entry.UserID] += entry.Points
+	return nil
+}
+
+// GetEntry 台帳エントリを取得する
+func (s *MemoryStore) GetEntry(_ context.Context, userID, entryID string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[userID][entryID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &entry, nil
+}
+
+// GetBalance ポイント残高を取得する
+func (s *MemoryStore) GetBalance(_ context.Context, userID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.balances[userID], nil
+}
+
+// DynamoDBAPI DynamoDBStoreが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// DynamoDBStore DynamoDBのポイント台帳テーブルと残高テーブルを使用するストア
+type DynamoDBStore struct {
+	client       DynamoDBAPI
+	ledgerTable  string
+	balanceTable string
+}
+
+// NewDynamoDBStore DynamoDBStoreを生成する
+func NewDynamoDBStore(client DynamoDBAPI, ledgerTable, balanceTable string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, ledgerTable: ledgerTable, balanceTable: balanceTable}
+}
+
+// Append 台帳エントリの条件付き書き込みと残高の加算をトランザクションで行う
+func (s *DynamoDBStore) Append(ctx context.Context, entry *Entry, unlessEntryID string) error {
+	items := []types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				TableName: aws.String(s.ledgerTable),
+				Item: map[string]types.AttributeValue{
+					"user_id":    ddbattr.S(entry.UserID),
+					"entry_id":   ddbattr.S(entry.EntryID),
+					"type":       ddbattr.S(string(entry.Type)),
+					"order_id":   ddbattr.S(entry.OrderID),
+					"points":     ddbattr.N(entry.Points),
+					"created_at": ddbattr.Time(entry.CreatedAt),
+				},
+				ConditionExpression: aws.String("attribute_not_exists(entry_id)"),
+			},
+		},
+		{
+			Update: &types.Update{
+				TableName: aws.String(s.balanceTable),
+				Key: map[string]types.AttributeValue{
+					"user_id": ddbattr.S(entry.UserID),
+				},
+				UpdateExpression: aws.String("ADD balance :points SET updated_at = :updated_at"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":points":     ddbattr.N(entry.Points),
+					":updated_at": ddbattr.Time(entry.CreatedAt),
+				},
+			},
+		},
+	}
+	if unlessEntryID != "" {
+		items = append(items, types.TransactWriteItem{
+			ConditionCheck: &types.ConditionCheck{
+				TableName: aws.String(s.ledgerTable),
+				Key: map[string]types.AttributeValue{
+					"user_id":  ddbattr.S(entry.UserID),
+					"entry_id": ddbattr.S(unlessEntryID),
+				},
+				ConditionExpression: aws.String("attribute_not_exists(entry_id)"),
+			},
+		})
+	}
+	_, err := s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	// 台帳エントリの条件チェックで失敗した場合は追記済み、unlessEntryIDの条件チェックで失敗した場合は競合
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		reasons := canceled.CancellationReasons
+		if len(reasons) > 0 && aws.ToString(reasons[0].Code) == "ConditionalCheckFailed" {
+			return ErrAlreadyExists
+		}
+		if len(reasons) > 2 && aws.ToString(reasons[2].Code) == "ConditionalCheckFailed" {
+			return ErrConflict
+		}
+	}
+	return err
+}
+
+// GetEntry 台帳エントリを取得する
+func (s *DynamoDBStore) GetEntry(ctx context.Context, userID, entryID string) (*Entry, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.ledgerTable),
+		Key: map[string]types.AttributeValue{
+			"user_id":  ddbattr.S(userID),
+			"entry_id": ddbattr.S(entryID),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+
+	entry := &Entry{
+		UserID:  ddbattr.String(result.Item, "user_id"),
+		EntryID: ddbattr.String(result.Item, "entry_id"),
+		Type:    EntryType(ddbattr.String(result.Item, "type")),
+		OrderID: ddbattr.String(result.Item, "order_id"),
+	}
+	if entry.Points, err = ddbattr.Int64(result.Item, "points"); err != nil {
+		return nil, err
+	}
+	if entry.CreatedAt, err = ddbattr.ParseTime(result.Item, "created_at"); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// GetBalance ポイント残高を取得する
+func (s *DynamoDBStore) GetBalance(ctx context.Context, userID string) (int64, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.balanceTable),
+		Key: map[string]types.AttributeValue{
+			"user_id": ddbattr.S(userID),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ddbattr.Int64(result.Item, "balance")
+}
@@ -0,0 +1,188 @@
+// Package purchase はSagaの購入履歴作成(create-purchase-history)と補償処理(delete-purchase-history)で使用する購入履歴を提供する
+package purchase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+)
+
+// Status 購入履歴のステータス
+type Status string
+
+const (
+	// StatusRecorded 購入履歴作成済み
+	StatusRecorded Status = "RECORDED"
+	// StatusDeleted 補償処理により削除済み(論理削除)
+	StatusDeleted Status = "DELETED"
+)
+
+var (
+	// ErrInvalidInput 入力値が不正
+	ErrInvalidInput = errors.New("invalid purchase input")
+	// ErrNotFound 購入履歴が存在しない
+	ErrNotFound = errors.New("purchase not found")
+	// ErrAlreadyExists 購入履歴が既に存在する
+	ErrAlreadyExists = errors.New("purchase already exists")
+)
+
+// LineItem 購入明細
+type LineItem struct {
+	SKU       string `json:"sku"`
+	Name      string `json:"name,omitempty"`
+	Quantity  int64  `json:"quantity"`
+	UnitPrice int64  `json:"unit_price"`
+}
+
+// CreatePurchaseHistoryInput create-purchase-historyの入力。Paymentはprocess-paymentの出力
+type CreatePurchaseHistoryInput struct {
+	OrderID  string           `json:"order_id"`
+	UserID   string           `json:"user_id"`
+	Items    []LineItem       `json:"items"`
+	Amount   int64            `json:"amount"`
+	Currency string           `json:"currency"`
+	Payment  *payment.Payment `json:"payment"`
+}
+
+// Validate 入力値を検証する
+func (in CreatePurchaseHistoryInput) Validate() error {
+	switch {
+	case in.OrderID == "":
+		return fmt.Errorf("%w: order_id is required", ErrInvalidInput)
+	case in.UserID == "":
+		return fmt.Errorf("%w: user_id is required", ErrInvalidInput)
+	case len(in.Items) == 0:
+		return fmt.Errorf("%w: items is required", ErrInvalidInput)
+	case in.Payment == nil || in.Payment.PaymentID == "":
+		return fmt.Errorf("%w: payment.payment_id is required", ErrInvalidInput)
+	}
+	for _, item := range in.Items {
+		if item.SKU == "" || item.Quantity <= 0 {
+			return fmt.Errorf("%w: invalid line item %+v", ErrInvalidInput, item)
+		}
+	}
+	return nil
+}
+
+// DeletePurchaseHistoryInput delete-purchase-historyの入力
+type DeletePurchaseHistoryInput struct {
+	OrderID string `json:"order_id"`
+}
+
+// DeletePurchaseHistoryOutput delete-purchase-historyの出力
+type DeletePurchaseHistoryOutput struct {
+	OrderID string `json:"order_id"`
+	// Deleted 今回の呼び出しで削除したかどうか
+	Deleted bool `json:"deleted"`
+}
+
+// ListPurchaseHistoryInput ユーザーの購入履歴一覧取得の入力
+type ListPurchaseHistoryInput struct {
+	UserID string `json:"user_id"`
+	Limit  int32  `json:"limit,omitempty"`
+}
+
+// Purchase 購入履歴
+type Purchase struct {
+	OrderID   string     `json:"order_id"`
+	UserID    string     `json:"user_id"`
+	Items     []LineItem `json:"items"`
+	Amount    int64      `json:"amount"`
+	Currency  string     `json:"currency"`
+	PaymentID string     `json:"payment_id"`
+	Status    Status     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Service 購入履歴の操作
+type Service struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewService 購入履歴の操作を生成する
+func NewService(store Store) *Service {
+	return &Service{store: store, now: time.Now}
+}
+
+// Create 購入履歴を作成する。リトライで同じ注文の購入履歴が重複しないよう条件付きで書き込む
+func (s *Service) Create(ctx context.Context, in CreatePurchaseHistoryInput) (*Purchase, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+
+	// 金額が指定されていない場合は明細から求める
+	amount := in.Amount
+	if amount == 0 {
+		for _, item := range in.Items {
+			amount += item.UnitPrice * item.Quantity
+		}
+	}
+	currency := in.Currency
+	if currency == "" {
+		currency = in.Payment.Currency
+	}
+
+	now := s.now()
+	p := &Purchase{
+		OrderID:   in.OrderID,
+		UserID:    in.UserID,
+		Items:     in.Items,
+		Amount:    amount,
+		Currency:  currency,
+		PaymentID: in.Payment.PaymentID,
+		Status:    StatusRecorded,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := s.store.Create(ctx, p)
+	if errors.Is(err, ErrAlreadyExists) {
+		// リトライ時は既存の購入履歴を返す
+		log.Printf("購入履歴作成済みのためスキップ: order_id=%s", in.OrderID)
+		return s.store.Get(ctx, in.OrderID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("購入履歴作成エラー: %w", err)
+	}
+
+	log.Printf("購入履歴作成完了: order_id=%s", p.OrderID)
+	return p, nil
+}
+
+// Delete 補償処理として購入履歴を論理削除する。
+// 何度呼び出しても結果は同じになり、購入履歴が存在しない場合は何もしない
+func (s *Service) Delete(ctx context.Context, in DeletePurchaseHistoryInput) (*DeletePurchaseHistoryOutput, error) {
+	if in.OrderID == "" {
+		return nil, fmt.Errorf("%w: order_id is required", ErrInvalidInput)
+	}
+
+	out := &DeletePurchaseHistoryOutput{OrderID: in.OrderID}
+
+	deleted, err := s.store.MarkDeleted(ctx, in.OrderID, s.now())
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("購入履歴が存在しないため削除不要: order_id=%s", in.OrderID)
+		return out, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("購入履歴削除エラー: %w", err)
+	}
+	out.Deleted = deleted
+
+	log.Printf("購入履歴削除完了: order_id=%s, deleted=%t", in.OrderID, deleted)
+	return out, nil
+}
+
+// ListByUser ユーザーの購入履歴を新しい順に取得する。補償処理で削除された購入履歴も含む
+func (s *Service) ListByUser(ctx context.Context, in ListPurchaseHistoryInput) ([]Purchase, error) {
+	if in.UserID == "" {
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidInput)
+	}
+	return s.store.ListByUser(ctx, in.UserID, in.Limit)
+}
@@ -0,0 +1,110 @@
+package purchase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+)
+
+func newTestInput(orderID string) CreatePurchaseHistoryInput {
+	return CreatePurchaseHistoryInput{
+		OrderID: orderID,
+		UserID:  "user-1",
+		Items: []LineItem{
+			{SKU: "sku-1", Quantity: 2, UnitPrice: 300},
+			{SKU: "sku-2", Quantity: 1, UnitPrice: 400},
+		},
+		Currency: "JPY",
+		Payment:  &payment.Payment{PaymentID: payment.PaymentIDFromOrderID(orderID)},
+	}
+}
+
+func TestServiceCreateIsRetrySafe(t *testing.T) {
+	service := NewService(NewMemoryStore())
+
+	first, err := service.Create(context.Background(), newTestInput("order-1"))
+	if err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+	if first.Amount != 1000 {
+		t.Errorf("Amount = %d, want %d", first.Amount, 1000)
+	}
+
+	second, err := service.Create(context.Background(), newTestInput("order-1"))
+	if err != nil {
+		t.Fatalf("Create() returned an error on retry: %v", err)
+	}
+	if !second.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", second.CreatedAt, first.CreatedAt)
+	}
+}
+
+func TestServiceCreateInvalidInput(t *testing.T) {
+	service := NewService(NewMemoryStore())
+
+	in := newTestInput("order-1")
+	in.Payment = nil
+	if _, err := service.Create(context.Background(), in); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Create() error = %v, want %v", err, ErrInvalidInput)
+	}
+}
+
+func TestServiceDelete(t *testing.T) {
+	store := NewMemoryStore()
+	service := NewService(store)
+
+	// 購入履歴が存在しない場合は何もしない
+	out, err := service.Delete(context.Background(), DeletePurchaseHistoryInput{OrderID: "order-1"})
+	if err != nil {
+		t.Fatalf("Delete() returned an error: %v", err)
+	}
+	if out.Deleted {
+		t.Errorf("Deleted = true, want false")
+	}
+
+	if _, err := service.Create(context.Background(), newTestInput("order-1")); err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+
+	for i, want := range []bool{true, false} {
+		out, err := service.Delete(context.Background(), DeletePurchaseHistoryInput{OrderID: "order-1"})
+		if err != nil {
+			t.Fatalf("Delete() #%d returned an error: %v", i, err)
+		}
+		if out.Deleted != want {
+			t.Errorf("Delete() #%d Deleted = %t, want %t", i, out.Deleted, want)
+		}
+	}
+
+	p, err := store.Get(context.Background(), "order-1")
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if p.Status != StatusDeleted || p.DeletedAt == nil {
+		t.Errorf("purchase = %+v, want tombstoned", p)
+	}
+}
+
+func TestServiceListByUser(t *testing.T) {
+	service := NewService(NewMemoryStore())
+
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, orderID := range []string{"order-1", "order-2", "order-3"} {
+		createdAt := base.Add(time.Duration(i) * time.Hour)
+		service.now = func() time.Time { return createdAt }
+		if _, err := service.Create(context.Background(), newTestInput(orderID)); err != nil {
+			t.Fatalf("Create() returned an error: %v", err)
+		}
+	}
+
+	purchases, err := service.ListByUser(context.Background(), ListPurchaseHistoryInput{UserID: "user-1", Limit: 2})
+	if err != nil {
+		t.Fatalf("ListByUser() returned an error: %v", err)
+	}
+	if len(purchases) != 2 || purchases[0].OrderID != "order-3" || purchases[1].OrderID != "order-2" {
+		t.Errorf("ListByUser() = %+v, want order-3, order-2", purchases)
+	}
+}
@@ -0,0 +1,310 @@
+package purchase
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/internal/ddbattr"
+)
+
+// defaultListLimit 購入履歴一覧の件数上限の既定値
+const defaultListLimit = 20
+
+// userIndexName ユーザーIDと作成日時で購入履歴を検索するためのGSI
+const userIndexName = "user_id-created_at-index"
+
+// Store 購入履歴の永続化
+type Store interface {
+	// Create 購入履歴を作成する。既に存在する場合はErrAlreadyExistsを返す
+	Create(ctx context.Context, p *Purchase) error
+	// Get 購入履歴を取得する。存在しない場合はErrNotFoundを返す
+	Get(ctx context.Context, orderID string) (*Purchase, error)
+	// MarkDeleted 購入履歴を論理削除する。今回削除した場合はtrue、削除済みの場合はfalseを返す。
+	// 存在しない場合はErrNotFoundを返す
+	MarkDeleted(ctx context.Context, orderID string, at time.Time) (bool, error)
+	// ListByUser ユーザーの購入履歴を新しい順に取得する。limitが0以下の場合は既定の件数
+	ListByUser(ctx context.Context, userID string, limit int32) ([]Purchase, error)
+}
+
+// MemoryStore テスト・ローカル実行用のインメモリストア
+type MemoryStore struct {
+	mu        sync.Mutex
+	purchases map[string]Purchase
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{purchases: make(map[string]Purchase)}
+}
+
+// Create 購入履歴を作成する
+func (s *MemoryStore) Create(_ context.Context, p *Purchase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.purchases[p.OrderID]; ok {
+		return ErrAlreadyExists
+	}
+	s.purchases[p.OrderID] = *p
+	return nil
+}
+
+// Get 購入履歴を取得する
+func (s *MemoryStore) Get(_ context.Context, orderID string) (*Purchase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.purchases[orderID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+// MarkDeleted 購入履歴を論理削除する
+func (s *MemoryStore) MarkDeleted(_ context.Context, orderID string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.purchases[orderID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if p.Status == StatusDeleted {
+		return false, nil
+	}
+	p.Status = StatusDeleted
+	p.UpdatedAt = at
+	p.DeletedAt = &at
+	s.purchases[orderID] = p
+	return true, nil
+}
+
+// ListByUser ユーザーの購入履歴を新しい順に取得する
+func (s *MemoryStore) ListByUser(_ context.Context, userID string, limit int32) ([]Purchase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purchases []Purchase
+	for _, p := range s.purchases {
+		if p.UserID == userID {
+			purchases = append(purchases, p)
+		}
+	}
+	sort.Slice(purchases, func(i, j int) bool {
+		return purchases[i].CreatedAt.After(purchases[j].CreatedAt)
+	})
+
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if len(purchases) > int(limit) {
+		purchases = purchases[:limit]
+	}
+	return purchases, nil
+}
+
+// DynamoDBAPI DynamoDBStoreが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// DynamoDBStore DynamoDBのpurchase-historyテーブルを使用するストア
+type DynamoDBStore struct {
+	client    DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBStore DynamoDBStoreを生成する
+func NewDynamoDBStore(client DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName}
+}
+
+// Create 注文IDが存在しない場合のみ購入履歴を書き込む
+func (s *DynamoDBStore) Create(ctx context.Context, p *Purchase) error {
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                marshalPurchase(p),
+		ConditionExpression: aws.String("attribute_not_exists(order_id)"),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// Get 購入履歴を取得する
+func (s *DynamoDBStore) Get(ctx context.Context, orderID string) (*Purchase, error) {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"order_id": ddbattr.S(orderID),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, ErrNotFound
+	}
+	return unmarshalPurchase(result.Item)
+}
+
+// MarkDeleted 削除済みでない場合のみステータスをDELETEDに更新する
+func (s *DynamoDBStore) MarkDeleted(ctx context.Context, orderID string, at time.Time) (bool, error) {
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"order_id": ddbattr.S(orderID),
+		},
+		UpdateExpression:    aws.String("SET #status = :deleted, deleted_at = :at, updated_at = :at"),
+		ConditionExpression: aws.String("attribute_exists(order_id) AND #status <> :deleted"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deleted": ddbattr.S(string(StatusDeleted)),
+			":at":      ddbattr.Time(at),
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	// 条件を満たさない場合、アイテムの有無で未作成か削除済みかを判定する
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		if len(ccf.Item) == 0 {
+			return false, ErrNotFound
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ListByUser GSIを使用してユーザーの購入履歴を新しい順に取得する
+func (s *DynamoDBStore) ListByUser(ctx context.Context, userID string, limit int32) ([]Purchase, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(userIndexName),
+		KeyConditionExpression: aws.String("user_id = :user_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user_id": ddbattr.S(userID),
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(limit),
+	})
+
+	var purchases []Purchase
+	for paginator.HasMorePages() && len(purchases) < int(limit) {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			p, err := unmarshalPurchase(item)
+			if err != nil {
+				return nil, err
+			}
+			purchases = append(purchases, *p)
+		}
+	}
+
+	if len(purchases) > int(limit) {
+		purchases = purchases[:limit]
+	}
+	return purchases, nil
+}
+
+// marshalPurchase 購入履歴をDynamoDBアイテムに変換する
+func marshalPurchase(p *Purchase) map[string]types.AttributeValue {
+	items := make([]types.AttributeValue, 0, len(p.Items))
+	for _, item := range p.Items {
+		items = append(items, &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"sku":        ddbattr.S(item.SKU),
+			"name":       ddbattr.S(item.Name),
+			"quantity":   ddbattr.N(item.Quantity),
+			"unit_price": ddbattr.N(item.UnitPrice),
+		}})
+	}
+
+	av := map[string]types.AttributeValue{
+		"order_id":   ddbattr.S(p.OrderID),
+		"user_id":    ddbattr.S(p.UserID),
+		"items":      &types.AttributeValueMemberL{Value: items},
+		"amount":     ddbattr.N(p.Amount),
+		"currency":   ddbattr.S(p.Currency),
+		"payment_id": ddbattr.S(p.PaymentID),
+		"status":     ddbattr.S(string(p.Status)),
+		"created_at": ddbattr.Time(p.CreatedAt),
+		"updated_at": ddbattr.Time(p.UpdatedAt),
+	}
+	if p.DeletedAt != nil {
+		av["deleted_at"] = ddbattr.Time(*p.DeletedAt)
+	}
+	return av
+}
+
+// unmarshalPurchase DynamoDBアイテムを購入履歴に変換する
+func unmarshalPurchase(av map[string]types.AttributeValue) (*Purchase, error) {
+	p := &Purchase{
+		OrderID:   ddbattr.String(av, "order_id"),
+		UserID:    ddbattr.String(av, "user_id"),
+		Currency:  ddbattr.String(av, "currency"),
+		PaymentID: ddbattr.String(av, "payment_id"),
+		Status:    Status(ddbattr.String(av, "status")),
+	}
+
+	if list, ok := av["items"].(*types.AttributeValueMemberL); ok {
+		for _, v := range list.Value {
+			m, ok := v.(*types.AttributeValueMemberM)
+			if !ok {
+				continue
+			}
+			item := LineItem{
+				SKU:  ddbattr.String(m.Value, "sku"),
+				Name: ddbattr.String(m.Value, "name"),
+			}
+			var err error
+			if item.Quantity, err = ddbattr.Int64(m.Value, "quantity"); err != nil {
+				return nil, err
+			}
+			if item.UnitPrice, err = ddbattr.Int64(m.Value, "unit_price"); err != nil {
+				return nil, err
+			}
+			p.Items = append(p.Items, item)
+		}
+	}
+
+	var err error
+	if p.Amount, err = ddbattr.Int64(av, "amount"); err != nil {
+		return nil, err
+	}
+	if p.CreatedAt, err = ddbattr.ParseTime(av, "created_at"); err != nil {
+		return nil, err
+	}
+	if p.UpdatedAt, err = ddbattr.ParseTime(av, "updated_at"); err != nil {
+		return nil, err
+	}
+	deletedAt, err := ddbattr.ParseTime(av, "deleted_at")
+	if err != nil {
+		return nil, err
+	}
+	if !deletedAt.IsZero() {
+		p.DeletedAt = &deletedAt
+	}
+	return p, nil
+}
@@ -0,0 +1,107 @@
+package choreography
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+)
+
+// delivery 購読しているサービスへのイベントの配信
+type delivery struct {
+	participant  *Participant
+	env          event.Envelope
+	receiveCount int
+}
+
+// MemoryBus テスト・ローカル実行用にSNSトピックとサービスごとのSQSキューを模したイベントバス。
+// 処理に失敗したイベントは最大受信回数まで再配信し、超えた場合はデッドレターとして保持する
+type MemoryBus struct {
+	mu              sync.Mutex
+	participants    []*Participant
+	queue           []delivery
+	published       []event.Envelope
+	deadLetters     []event.Envelope
+	maxReceiveCount int
+}
+
+// NewMemoryBus MemoryBusを生成する。maxReceiveCountはSQSのリドライブポリシーの最大受信回数に相当する
+func NewMemoryBus(maxReceiveCount int) *MemoryBus {
+	return &MemoryBus{maxReceiveCount: max(maxReceiveCount, 1)}
+}
+
+// Subscribe サービスを購読させる
+func (b *MemoryBus) Subscribe(p *Participant) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.participants = append(b.participants, p)
+}
+
+// Publish イベントを発行し、イベント種別を購読しているサービスのキューに追加する
+func (b *MemoryBus) Publish(_ context.Context, env event.Envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, env)
+	for _, p := range b.participants {
+		if slices.Contains(p.EventTypes(), env.Type) {
+			b.queue = append(b.queue, delivery{participant: p, env: env})
+		}
+	}
+	return nil
+}
+
+// Run キューが空になるまでイベントを配信する
+func (b *MemoryBus) Run(ctx context.Context) error {
+	for {
+		d, ok := b.next()
+		if !ok {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		d.receiveCount++
+		if err := d.participant.Handle(ctx, d.env, d.receiveCount); err != nil {
+			b.retry(d)
+		}
+	}
+}
+
+// next キューの先頭の配信を取り出す
+func (b *MemoryBus) next() (delivery, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.queue) == 0 {
+		return delivery{}, false
+	}
+	d := b.queue[0]
+	b.queue = b.queue[1:]
+	return d, true
+}
+
+// retry 失敗した配信をキューに戻す。最大受信回数に達した場合はデッドレターとする
+func (b *MemoryBus) retry(d delivery) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if d.receiveCount >= b.maxReceiveCount {
+		b.deadLetters = append(b.deadLetters, d.env)
+		return
+	}
+	b.queue = append(b.queue, d)
+}
+
+// Published 発行されたイベントを発行順に返す
+func (b *MemoryBus) Published() []event.Envelope {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.published)
+}
+
+// DeadLetters 最大受信回数まで処理に失敗したイベントを返す
+func (b *MemoryBus) DeadLetters() []event.Envelope {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.deadLetters)
+}
@@ -0,0 +1,95 @@
+// Package choreography はSNS・SQSのイベントで各サービスが連携するコレオグラフィ型のSagaを提供する。
+// 各ステップの処理はオーケストレーション型と同じハンドラー(saga/steps)を使用する
+package choreography
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+)
+
+// イベント種別
+const (
+	// EventOrderPlaced 注文受付。Sagaの開始イベント
+	EventOrderPlaced = "OrderPlaced"
+	// EventInventoryReserved 在庫引当完了
+	EventInventoryReserved = "InventoryReserved"
+	// EventInventoryReservationFailed 在庫引当失敗。Sagaの終了イベント
+	EventInventoryReservationFailed = "InventoryReservationFailed"
+	// EventPaymentProcessed 決済完了
+	EventPaymentProcessed = "PaymentProcessed"
+	// EventPaymentFailed 決済失敗
+	EventPaymentFailed = "PaymentFailed"
+	// EventPurchaseRecorded 購入履歴作成完了
+	EventPurchaseRecorded = "PurchaseRecorded"
+	// EventPurchaseRecordFailed 購入履歴作成失敗
+	EventPurchaseRecordFailed = "PurchaseRecordFailed"
+	// EventPointsAwarded ポイント付与完了
+	EventPointsAwarded = "PointsAwarded"
+	// EventPointsAwardFailed ポイント付与失敗
+	EventPointsAwardFailed = "PointsAwardFailed"
+	// EventInventoryConfirmed 在庫引当確定。Sagaの終了イベント
+	EventInventoryConfirmed = "InventoryConfirmed"
+	// EventInventoryConfirmFailed 在庫引当確定失敗(有効期限切れ)
+	EventInventoryConfirmFailed = "InventoryConfirmFailed"
+	// EventPointsReversed 補償処理によるポイント取消完了
+	EventPointsReversed = "PointsReversed"
+	// EventPurchaseHistoryDeleted 補償処理による購入履歴削除完了
+	EventPurchaseHistoryDeleted = "PurchaseHistoryDeleted"
+	// EventPaymentCancelled 補償処理による決済取消完了
+	EventPaymentCancelled = "PaymentCancelled"
+	// EventInventoryReleased 補償処理による在庫引当解放完了。Sagaの終了イベント
+	EventInventoryReleased = "InventoryReleased"
+)
+
+// DataVersion イベントデータ(Order)のスキーマのバージョン
+const DataVersion = 1
+
+// 各サービスのイベントの発行元
+const (
+	SourceInventoryService       = "inventory-service"
+	SourcePaymentService         = "payment-service"
+	SourcePurchaseHistoryService = "purchase-history-service"
+	SourcePointsService          = "points-service"
+)
+
+// Order イベントデータ。注文内容と各ステップの結果を後続のサービスへ引き継ぐ
+type Order struct {
+	OrderID            string                 `json:"order_id"`
+	UserID             string                 `json:"user_id"`
+	Items              []purchase.LineItem    `json:"items"`
+	Amount             int64                  `json:"amount"`
+	Currency           string                 `json:"currency"`
+	PaymentMethodToken string                 `json:"payment_method_token"`
+	Reservation        *inventory.Reservation `json:"reservation,omitempty"`
+	Payment            *payment.Payment       `json:"payment,omitempty"`
+	Purchase           *purchase.Purchase     `json:"purchase,omitempty"`
+	Points             *points.Entry          `json:"points,omitempty"`
+	// Failure 失敗したステップとエラー。失敗イベント・補償処理のイベントに設定する
+	Failure *Failure `json:"failure,omitempty"`
+}
+
+// Failure 失敗したステップとエラー(ステートマシンのCatchで状態に格納するエラー情報に相当)
+type Failure struct {
+	Step  string `json:"step"`
+	Error string `json:"error"`
+	Cause string `json:"cause"`
+}
+
+// Start 注文受付イベントを発行してSagaを開始する。sagaIDは一連のイベントの相関IDとなる
+func Start(ctx context.Context, publisher event.Publisher, sagaID string, order Order) error {
+	env, err := event.New(EventOrderPlaced, DataVersion, "order-service", order)
+	if err != nil {
+		return err
+	}
+	env.CorrelationID = sagaID
+	if err := publisher.Publish(ctx, env); err != nil {
+		return fmt.Errorf("Sagaの開始エラー: %w", err)
+	}
+	return nil
+}
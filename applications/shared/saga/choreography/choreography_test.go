@@ -0,0 +1,285 @@
+package choreography
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+)
+
+// failingLedger ポイント付与の追記が常に失敗するポイントストア
+type failingLedger struct {
+	points.Store
+}
+
+func (l failingLedger) Append(ctx context.Context, entry *points.Entry, unlessEntryID string) error {
+	if entry.Type == points.EntryTypeEarn {
+		return errors.New("ledger unavailable")
+	}
+	return l.Store.Append(ctx, entry, unlessEntryID)
+}
+
+// failingPurchases 購入履歴の作成が常に失敗するストア
+type failingPurchases struct {
+	*purchase.MemoryStore
+}
+
+func (failingPurchases) Create(context.Context, *purchase.Purchase) error {
+	return errors.New("purchase history unavailable")
+}
+
+type testEnv struct {
+	bus       *MemoryBus
+	inventory *inventory.Service
+	payments  *payment.MemoryStore
+	purchases purchase.Store
+	points    *points.Service
+	sagaStore *saga.MemoryStore
+}
+
+// testConfig テストごとに変更する設定
+type testConfig struct {
+	mode           payment.FakeMode
+	ledger         points.Store
+	stock          int64
+	reservationTTL time.Duration
+	failPurchase   bool
+}
+
+func newTestEnv(cfg testConfig) *testEnv {
+	if cfg.mode == "" {
+		cfg.mode = payment.FakeModeSucceed
+	}
+	if cfg.ledger == nil {
+		cfg.ledger = points.NewMemoryStore()
+	}
+	inventoryStore := inventory.NewMemoryStore()
+	inventoryStore.SetStock("sku-1", cfg.stock)
+
+	var purchases purchase.Store = purchase.NewMemoryStore()
+	if cfg.failPurchase {
+		purchases = failingPurchases{MemoryStore: purchase.NewMemoryStore()}
+	}
+
+	env := &testEnv{
+		bus:       NewMemoryBus(5),
+		inventory: inventory.NewService(inventoryStore, cfg.reservationTTL),
+		payments:  payment.NewMemoryStore(),
+		purchases: purchases,
+		points:    points.NewService(cfg.ledger, points.DefaultRate),
+		sagaStore: saga.NewMemoryStore(),
+	}
+	h := &steps.Handlers{
+		Inventory:   env.inventory,
+		Payment:     payment.NewService(payment.NewFakeGateway(cfg.mode), env.payments),
+		Purchase:    purchase.NewService(env.purchases),
+		Points:      env.points,
+		Recorder:    saga.NewRecorder(env.sagaStore),
+		Idempotency: idempotency.NewGuard(idempotency.NewMemoryStore(), idempotency.DefaultTTL),
+	}
+	env.bus.Subscribe(NewInventoryParticipant(h, env.bus))
+	env.bus.Subscribe(NewPaymentParticipant(h, env.bus))
+	env.bus.Subscribe(NewPurchaseHistoryParticipant(h, env.bus))
+	env.bus.Subscribe(NewPointsParticipant(h, env.bus))
+	return env
+}
+
+func newTestOrder() Order {
+	return Order{
+		OrderID:            "order-1",
+		UserID:             "user-1",
+		Items:              []purchase.LineItem{{SKU: "sku-1", Quantity: 2, UnitPrice: 500}},
+		Amount:             1000,
+		Currency:           "JPY",
+		PaymentMethodToken: "tok_visa",
+	}
+}
+
+func (env *testEnv) run(t *testing.T, order Order) []event.Envelope {
+	t.Helper()
+	ctx := context.Background()
+	if err := Start(ctx, env.bus, "saga-1", order); err != nil {
+		t.Fatalf("Start() returned an error: %v", err)
+	}
+	if err := env.bus.Run(ctx); err != nil {
+		t.Fatalf("Run() returned an error: %v", err)
+	}
+	if dead := env.bus.DeadLetters(); len(dead) > 0 {
+		t.Errorf("DeadLetters() = %+v, want none", dead)
+	}
+
+	published := env.bus.Published()
+	for _, e := range published {
+		if e.CorrelationID != "saga-1" {
+			t.Errorf("%s CorrelationID = %q, want %q", e.Type, e.CorrelationID, "saga-1")
+		}
+	}
+	return published
+}
+
+func eventTypes(events []event.Envelope) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestSagaSucceeds(t *testing.T) {
+	env := newTestEnv(testConfig{stock: 5})
+	published := env.run(t, newTestOrder())
+
+	want := []string{
+		EventOrderPlaced, EventInventoryReserved, EventPaymentProcessed,
+		EventPurchaseRecorded, EventPointsAwarded, EventInventoryConfirmed,
+	}
+	if got := eventTypes(published); !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+
+	var order Order
+	if err := published[len(published)-1].DecodeData(&order); err != nil {
+		t.Fatalf("DecodeData() returned an error: %v", err)
+	}
+	if order.Payment == nil || order.Purchase == nil || order.Points == nil || order.Points.Points != 10 {
+		t.Errorf("InventoryConfirmed data = %+v, want payment, purchase and 10 points", order)
+	}
+	if order.Reservation == nil || order.Reservation.Status != inventory.StatusConfirmed {
+		t.Errorf("Reservation = %+v, want %s", order.Reservation, inventory.StatusConfirmed)
+	}
+	if available, _ := env.inventory.Available(context.Background(), "sku-1"); available != 3 {
+		t.Errorf("available = %d, want %d", available, 3)
+	}
+}
+
+func TestSagaCompensates(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         testConfig
+		want        []string
+		wantFailure Failure
+	}{
+		{
+			name:        "out of stock",
+			cfg:         testConfig{stock: 1},
+			want:        []string{EventOrderPlaced, EventInventoryReservationFailed},
+			wantFailure: Failure{Step: saga.StepReserveInventory, Error: "BusinessRejectionError"},
+		},
+		{
+			name:        "payment declined",
+			cfg:         testConfig{mode: payment.FakeModeDecline, stock: 5},
+			want:        []string{EventOrderPlaced, EventInventoryReserved, EventPaymentFailed, EventInventoryReleased},
+			wantFailure: Failure{Step: saga.StepProcessPayment, Error: "BusinessRejectionError"},
+		},
+		{
+			name: "purchase history unavailable",
+			cfg:  testConfig{stock: 5, failPurchase: true},
+			want: []string{
+				EventOrderPlaced, EventInventoryReserved, EventPaymentProcessed,
+				EventPurchaseRecordFailed, EventPaymentCancelled, EventInventoryReleased,
+			},
+			wantFailure: Failure{Step: saga.StepCreatePurchaseHistory, Error: "TransientError"},
+		},
+		{
+			name: "points ledger unavailable",
+			cfg:  testConfig{stock: 5, ledger: failingLedger{Store: points.NewMemoryStore()}},
+			want: []string{
+				EventOrderPlaced, EventInventoryReserved, EventPaymentProcessed, EventPurchaseRecorded,
+				EventPointsAwardFailed, EventPurchaseHistoryDeleted, EventPaymentCancelled, EventInventoryReleased,
+			},
+			wantFailure: Failure{Step: saga.StepAwardPoints, Error: "TransientError"},
+		},
+		{
+			name: "reservation expired",
+			cfg:  testConfig{stock: 5, reservationTTL: time.Nanosecond},
+			want: []string{
+				EventOrderPlaced, EventInventoryReserved, EventPaymentProcessed, EventPurchaseRecorded,
+				EventPointsAwarded, EventInventoryConfirmFailed, EventPointsReversed,
+				EventPurchaseHistoryDeleted, EventPaymentCancelled, EventInventoryReleased,
+			},
+			wantFailure: Failure{Step: saga.StepConfirmInventory, Error: "BusinessRejectionError"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(tt.cfg)
+			published := env.run(t, newTestOrder())
+
+			if got := eventTypes(published); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+
+			// 補償処理のイベントまで失敗したステップが引き継がれること
+			var last Order
+			if err := published[len(published)-1].DecodeData(&last); err != nil {
+				t.Fatalf("DecodeData() returned an error: %v", err)
+			}
+			if last.Failure == nil || last.Failure.Step != tt.wantFailure.Step || last.Failure.Error != tt.wantFailure.Error {
+				t.Errorf("Failure = %+v, want step=%s error=%s", last.Failure, tt.wantFailure.Step, tt.wantFailure.Error)
+			}
+
+			// 補償処理後は在庫・決済・購入履歴・ポイントが元に戻っていること
+			ctx := context.Background()
+			if available, _ := env.inventory.Available(ctx, "sku-1"); available != tt.cfg.stock {
+				t.Errorf("available = %d, want %d", available, tt.cfg.stock)
+			}
+			p, err := env.payments.Get(ctx, payment.PaymentIDFromOrderID("order-1"))
+			if err == nil && p.Status == payment.StatusCompleted {
+				t.Errorf("payment status = %s, want not %s", p.Status, payment.StatusCompleted)
+			}
+			h, err := env.purchases.Get(ctx, "order-1")
+			if err == nil && h.Status != purchase.StatusDeleted {
+				t.Errorf("purchase status = %s, want %s", h.Status, purchase.StatusDeleted)
+			}
+			if balance, _ := env.points.Balance(ctx, "user-1"); balance != 0 {
+				t.Errorf("points balance = %d, want 0", balance)
+			}
+		})
+	}
+}
+
+func TestSagaRetriesTransientFailureBeforeFailing(t *testing.T) {
+	env := newTestEnv(testConfig{stock: 5, ledger: failingLedger{Store: points.NewMemoryStore()}})
+	env.run(t, newTestOrder())
+
+	timeline, err := saga.GetTimeline(context.Background(), env.sagaStore, "order-1")
+	if err != nil {
+		t.Fatalf("GetTimeline() returned an error: %v", err)
+	}
+	var attempts int
+	for _, step := range timeline.Steps {
+		if step.Step == saga.StepAwardPoints {
+			attempts++
+		}
+	}
+	if attempts != DefaultMaxAttempts {
+		t.Errorf("award-points attempts = %d, want %d", attempts, DefaultMaxAttempts)
+	}
+}
+
+func TestParticipantIgnoresUnknownEvent(t *testing.T) {
+	publisher := &event.MemoryPublisher{}
+	p := NewPointsParticipant(&steps.Handlers{}, publisher)
+
+	env, err := event.New(EventPaymentProcessed, DataVersion, "test", newTestOrder())
+	if err != nil {
+		t.Fatalf("New() returned an error: %v", err)
+	}
+	if err := p.Handle(context.Background(), env, 1); err != nil {
+		t.Errorf("Handle() returned an error: %v", err)
+	}
+	if len(publisher.Events()) != 0 {
+		t.Errorf("published %d events, want 0", len(publisher.Events()))
+	}
+}
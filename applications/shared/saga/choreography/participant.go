@@ -0,0 +1,301 @@
+package choreography
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/event"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga/steps"
+)
+
+// DefaultMaxAttempts 一時的な障害で失敗したステップを失敗イベントとするまでの受信回数の既定値
+// (ステートマシンのRetryのMaxAttemptsに相当)
+const DefaultMaxAttempts = 3
+
+// action イベントを受けて実行するステップ。結果はorderに設定する
+type action func(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error
+
+// reaction イベント種別ごとの処理
+type reaction struct {
+	step   string
+	action action
+	// succeeded ステップが成功した場合に発行するイベント
+	succeeded string
+	// failed ステップが失敗した場合に発行するイベント。空の場合(補償処理)は成功するまで再配信する
+	failed string
+	// compensate 失敗イベントの発行前に実行する自サービスの補償処理
+	compensate action
+}
+
+// Participant イベントを受けてステップを実行し、次のイベントまたは補償処理のイベントを発行するサービス
+type Participant struct {
+	source    string
+	handlers  *steps.Handlers
+	publisher event.Publisher
+	reactions map[string]reaction
+	// MaxAttempts 一時的な障害で失敗したステップを失敗イベントとするまでの受信回数
+	MaxAttempts int
+}
+
+func newParticipant(source string, h *steps.Handlers, publisher event.Publisher, reactions map[string]reaction) *Participant {
+	return &Participant{
+		source:      source,
+		handlers:    h,
+		publisher:   publisher,
+		reactions:   reactions,
+		MaxAttempts: DefaultMaxAttempts,
+	}
+}
+
+// NewInventoryParticipant 在庫サービスを生成する。
+// 注文受付で在庫を引き当て、ポイント付与完了で引当を確定し、決済失敗・補償処理による決済取消で引当を解放する
+func NewInventoryParticipant(h *steps.Handlers, publisher event.Publisher) *Participant {
+	release := reaction{step: saga.StepReleaseInventory, action: releaseInventory, succeeded: EventInventoryReleased}
+	return newParticipant(SourceInventoryService, h, publisher, map[string]reaction{
+		EventOrderPlaced: {
+			step:       saga.StepReserveInventory,
+			action:     reserveInventory,
+			succeeded:  EventInventoryReserved,
+			failed:     EventInventoryReservationFailed,
+			compensate: releaseInventory,
+		},
+		EventPointsAwarded: {
+			step:       saga.StepConfirmInventory,
+			action:     confirmInventory,
+			succeeded:  EventInventoryConfirmed,
+			failed:     EventInventoryConfirmFailed,
+			compensate: releaseInventory,
+		},
+		EventPaymentFailed:    release,
+		EventPaymentCancelled: release,
+	})
+}
+
+// NewPaymentParticipant 決済サービスを生成する。
+// 在庫引当完了で決済し、購入履歴の作成失敗・補償処理による購入履歴削除で決済を取り消す
+func NewPaymentParticipant(h *steps.Handlers, publisher event.Publisher) *Participant {
+	cancel := reaction{step: saga.StepCancelPayment, action: cancelPayment, succeeded: EventPaymentCancelled}
+	return newParticipant(SourcePaymentService, h, publisher, map[string]reaction{
+		EventInventoryReserved: {
+			step:       saga.StepProcessPayment,
+			action:     processPayment,
+			succeeded:  EventPaymentProcessed,
+			failed:     EventPaymentFailed,
+			compensate: cancelPayment,
+		},
+		EventPurchaseRecordFailed:   cancel,
+		EventPurchaseHistoryDeleted: cancel,
+	})
+}
+
+// NewPurchaseHistoryParticipant 購入履歴サービスを生成する。
+// 決済完了で購入履歴を作成し、ポイント付与失敗・補償処理によるポイント取消で購入履歴を削除する
+func NewPurchaseHistoryParticipant(h *steps.Handlers, publisher event.Publisher) *Participant {
+	deletion := reaction{step: saga.StepDeletePurchaseHistory, action: deletePurchaseHistory, succeeded: EventPurchaseHistoryDeleted}
+	return newParticipant(SourcePurchaseHistoryService, h, publisher, map[string]reaction{
+		EventPaymentProcessed: {
+			step:       saga.StepCreatePurchaseHistory,
+			action:     createPurchaseHistory,
+			succeeded:  EventPurchaseRecorded,
+			failed:     EventPurchaseRecordFailed,
+			compensate: deletePurchaseHistory,
+		},
+		EventPointsAwardFailed: deletion,
+		EventPointsReversed:    deletion,
+	})
+}
+
+// NewPointsParticipant ポイントサービスを生成する。
+// 購入履歴作成完了でポイントを付与し、在庫引当の確定失敗でポイントを取り消す
+func NewPointsParticipant(h *steps.Handlers, publisher event.Publisher) *Participant {
+	return newParticipant(SourcePointsService, h, publisher, map[string]reaction{
+		EventPurchaseRecorded: {
+			step:       saga.StepAwardPoints,
+			action:     awardPoints,
+			succeeded:  EventPointsAwarded,
+			failed:     EventPointsAwardFailed,
+			compensate: reversePoints,
+		},
+		EventInventoryConfirmFailed: {
+			step:      saga.StepReversePoints,
+			action:    reversePoints,
+			succeeded: EventPointsReversed,
+		},
+	})
+}
+
+// EventTypes 受信するイベント種別を返す。SQSキューのサブスクリプションフィルターポリシーに設定する
+func (p *Participant) EventTypes() []string {
+	types := make([]string, 0, len(p.reactions))
+	for eventType := range p.reactions {
+		types = append(types, eventType)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// Handle イベントを処理する。attemptはイベントの受信回数。
+// エラーを返した場合、イベントは再配信される(SQSの再試行・デッドレターキューに委ねる)
+func (p *Participant) Handle(ctx context.Context, env event.Envelope, attempt int) error {
+	r, ok := p.reactions[env.Type]
+	if !ok {
+		log.Printf("対象外のイベントのためスキップします: source=%s, type=%s, id=%s", p.source, env.Type, env.ID)
+		return nil
+	}
+
+	var order Order
+	if err := env.DecodeData(&order); err != nil {
+		return err
+	}
+	sc := saga.Context{ExecutionID: env.CorrelationID, Attempt: attempt}
+
+	err := r.action(ctx, p.handlers, &order, sc)
+	if err == nil {
+		return p.publish(ctx, env, r.succeeded, &order)
+	}
+
+	// 補償処理と、再試行で成功し得るステップは再配信する
+	if r.failed == "" || (saga.Retryable(err) && attempt < p.MaxAttempts) {
+		log.Printf("ステップが失敗したため再配信します: step=%s, order_id=%s, attempt=%d, error=%v", r.step, order.OrderID, attempt, err)
+		return err
+	}
+
+	log.Printf("ステップが失敗したため補償処理を開始します: step=%s, order_id=%s, error=%v", r.step, order.OrderID, err)
+	if r.compensate != nil {
+		if err := r.compensate(ctx, p.handlers, &order, sc); err != nil {
+			return err
+		}
+	}
+	order.Failure = &Failure{Step: r.step, Error: saga.ErrorName(err), Cause: err.Error()}
+	return p.publish(ctx, env, r.failed, &order)
+}
+
+// publish 受信したイベントと同じ相関IDで次のイベントを発行する
+func (p *Participant) publish(ctx context.Context, received event.Envelope, eventType string, order *Order) error {
+	env, err := event.New(eventType, DataVersion, p.source, order)
+	if err != nil {
+		return err
+	}
+	env.CorrelationID = received.CorrelationID
+	return p.publisher.Publish(ctx, env)
+}
+
+func reserveInventory(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	r, err := h.ReserveInventory(ctx, steps.ReserveInventoryInput{
+		ReserveInventoryInput: inventory.ReserveInventoryInput{
+			OrderID: order.OrderID,
+			UserID:  order.UserID,
+			Items:   order.Items,
+		},
+		Saga: sc,
+	})
+	if err != nil {
+		return err
+	}
+	order.Reservation = r
+	return nil
+}
+
+func confirmInventory(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	r, err := h.ConfirmInventory(ctx, steps.ConfirmInventoryInput{
+		ConfirmInventoryInput: inventory.ConfirmInventoryInput{OrderID: order.OrderID},
+		Saga:                  sc,
+	})
+	if err != nil {
+		return err
+	}
+	order.Reservation = r
+	return nil
+}
+
+func releaseInventory(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	_, err := h.ReleaseInventory(ctx, steps.ReleaseInventoryInput{
+		ReleaseInventoryInput: inventory.ReleaseInventoryInput{OrderID: order.OrderID},
+		Saga:                  sc,
+	})
+	return err
+}
+
+func processPayment(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	p, err := h.ProcessPayment(ctx, steps.ProcessPaymentInput{
+		ProcessPaymentInput: payment.ProcessPaymentInput{
+			OrderID:            order.OrderID,
+			UserID:             order.UserID,
+			Amount:             order.Amount,
+			Currency:           order.Currency,
+			PaymentMethodToken: order.PaymentMethodToken,
+		},
+		Saga: sc,
+	})
+	if err != nil {
+		return err
+	}
+	order.Payment = p
+	return nil
+}
+
+func cancelPayment(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	_, err := h.CancelPayment(ctx, steps.CancelPaymentInput{
+		CancelPaymentInput: payment.CancelPaymentInput{OrderID: order.OrderID, Payment: order.Payment},
+		Saga:               sc,
+	})
+	return err
+}
+
+func createPurchaseHistory(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	p, err := h.CreatePurchaseHistory(ctx, steps.CreatePurchaseHistoryInput{
+		CreatePurchaseHistoryInput: purchase.CreatePurchaseHistoryInput{
+			OrderID:  order.OrderID,
+			UserID:   order.UserID,
+			Items:    order.Items,
+			Amount:   order.Amount,
+			Currency: order.Currency,
+			Payment:  order.Payment,
+		},
+		Saga: sc,
+	})
+	if err != nil {
+		return err
+	}
+	order.Purchase = p
+	return nil
+}
+
+func deletePurchaseHistory(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	_, err := h.DeletePurchaseHistory(ctx, steps.DeletePurchaseHistoryInput{
+		DeletePurchaseHistoryInput: purchase.DeletePurchaseHistoryInput{OrderID: order.OrderID},
+		Saga:                       sc,
+	})
+	return err
+}
+
+func awardPoints(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	entry, err := h.AwardPoints(ctx, steps.AwardPointsInput{
+		AwardPointsInput: points.AwardPointsInput{
+			OrderID: order.OrderID,
+			UserID:  order.UserID,
+			Amount:  order.Amount,
+			Payment: order.Payment,
+		},
+		Saga: sc,
+	})
+	if err != nil {
+		return err
+	}
+	order.Points = entry
+	return nil
+}
+
+func reversePoints(ctx context.Context, h *steps.Handlers, order *Order, sc saga.Context) error {
+	_, err := h.ReversePoints(ctx, steps.ReversePointsInput{
+		ReversePointsInput: points.ReversePointsInput{OrderID: order.OrderID, UserID: order.UserID},
+		Saga:               sc,
+	})
+	return err
+}
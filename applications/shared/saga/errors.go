@@ -0,0 +1,49 @@
+package saga
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Lambdaのランタイムはエラーの型名をerrorTypeとして返すため、
+// ステートマシンのRetry・CatchのErrorEqualsで以下の型名を指定してリトライと補償処理を振り分ける
+
+// TransientError 一時的な障害(タイムアウト・スロットリング等)。リトライ対象
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string { return e.Err.Error() }
+func (e *TransientError) Unwrap() error { return e.Err }
+
+// BusinessRejectionError 業務上の拒否(残高不足による決済拒否等)。リトライせずに補償処理を行う
+type BusinessRejectionError struct {
+	Err error
+}
+
+func (e *BusinessRejectionError) Error() string { return e.Err.Error() }
+func (e *BusinessRejectionError) Unwrap() error { return e.Err }
+
+// PermanentError 恒久的な障害(入力不正・権限不足等)。リトライせずに補償処理を行う
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Retryable リトライ対象のエラーかどうかを返す。分類されていないエラーはLambdaの実行基盤の障害とみなしリトライする
+func Retryable(err error) bool {
+	var rejection *BusinessRejectionError
+	var permanent *PermanentError
+	return !errors.As(err, &rejection) && !errors.As(err, &permanent)
+}
+
+// ErrorName Lambdaのランタイムがステートマシンに返すエラー名を返す
+func ErrorName(err error) string {
+	t := reflect.TypeOf(err)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
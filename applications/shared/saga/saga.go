@@ -0,0 +1,143 @@
+// Package saga はSagaの各ステップで共通して使用する実行コンテキストと実行状態の記録を提供する
+package saga
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Sagaのステップ名
+const (
+	StepReserveInventory      = "reserve-inventory"
+	StepProcessPayment        = "process-payment"
+	StepCreatePurchaseHistory = "create-purchase-history"
+	StepAwardPoints           = "award-points"
+	StepConfirmInventory      = "confirm-inventory"
+	StepReleaseInventory      = "release-inventory"
+	StepCancelPayment         = "cancel-payment"
+	StepDeletePurchaseHistory = "delete-purchase-history"
+	StepReversePoints         = "reverse-points"
+)
+
+// IsCompensation 補償処理のステップかどうかを返す
+func IsCompensation(step string) bool {
+	switch step {
+	case StepReleaseInventory, StepCancelPayment, StepDeletePurchaseHistory, StepReversePoints:
+		return true
+	}
+	return false
+}
+
+// Outcome ステップの実行結果
+type Outcome string
+
+const (
+	// OutcomeStarted 実行中(完了の記録がない場合は処理が停止している)
+	OutcomeStarted Outcome = "STARTED"
+	// OutcomeSucceeded 成功
+	OutcomeSucceeded Outcome = "SUCCEEDED"
+	// OutcomeFailed 失敗
+	OutcomeFailed Outcome = "FAILED"
+)
+
+// Context Sagaの実行コンテキスト。
+// ステートマシンは実行ID($$.Execution.Id)と試行回数($$.State.RetryCount + 1)を
+// 各ステップの入力の"saga"に設定し、ステップ側で残りの項目を埋めて実行状態テーブルに記録する
+type Context struct {
+	ExecutionID string    `json:"execution_id"`
+	OrderID     string    `json:"order_id,omitempty"`
+	Step        string    `json:"step,omitempty"`
+	Attempt     int       `json:"attempt,omitempty"`
+	StartedAt   time.Time `json:"started_at,omitzero"`
+	FinishedAt  time.Time `json:"finished_at,omitzero"`
+	Outcome     Outcome   `json:"outcome,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// ForStep 注文IDとステップ名を設定したコンテキストを返す
+func (c Context) ForStep(orderID, step string) Context {
+	c.OrderID = orderID
+	c.Step = step
+	if c.Attempt < 1 {
+		c.Attempt = 1
+	}
+	c.StartedAt = time.Time{}
+	c.FinishedAt = time.Time{}
+	c.Outcome = ""
+	c.Error = ""
+	return c
+}
+
+// Recorder ステップの実行状態を記録する
+type Recorder struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewRecorder Recorderを生成する
+func NewRecorder(store Store) *Recorder {
+	return &Recorder{store: store, now: time.Now}
+}
+
+// Run ステップの開始と終了を記録しながらfnを実行する。
+// 記録に失敗してもステップ自体は失敗させない
+func Run[T any](ctx context.Context, r *Recorder, sc Context, fn func(context.Context) (T, error)) (T, error) {
+	sc.StartedAt = r.now()
+	sc.Outcome = OutcomeStarted
+	r.put(ctx, sc)
+
+	out, err := fn(ctx)
+
+	sc.FinishedAt = r.now()
+	sc.Outcome = OutcomeSucceeded
+	if err != nil {
+		sc.Outcome = OutcomeFailed
+		sc.Error = err.Error()
+	}
+	r.put(context.WithoutCancel(ctx), sc)
+
+	return out, err
+}
+
+// put 実行状態を保存する
+func (r *Recorder) put(ctx context.Context, sc Context) {
+	if err := r.store.Put(ctx, sc); err != nil {
+		log.Printf("Saga実行状態の記録に失敗しました: order_id=%s, step=%s, outcome=%s, error=%v",
+			sc.OrderID, sc.Step, sc.Outcome, err)
+	}
+}
+
+// Timeline 注文のSaga実行履歴
+type Timeline struct {
+	OrderID string    `json:"order_id"`
+	Steps   []Context `json:"steps"`
+	// CurrentStep 最後に開始されたステップ。OutcomeがSTARTEDの場合はこのステップで停止している
+	CurrentStep *Context `json:"current_step,omitempty"`
+	// Compensated 成功した補償処理のステップ名
+	Compensated []string `json:"compensated"`
+}
+
+// GetTimelineInput get-saga-timelineの入力
+type GetTimelineInput struct {
+	OrderID string `json:"order_id"`
+}
+
+// GetTimeline 注文のSaga実行履歴を開始日時の古い順に取得する
+func GetTimeline(ctx context.Context, store Store, orderID string) (*Timeline, error) {
+	steps, err := store.ListByOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	timeline := &Timeline{OrderID: orderID, Steps: steps, Compensated: []string{}}
+	if len(steps) > 0 {
+		timeline.CurrentStep = &steps[len(steps)-1]
+	}
+	for _, step := range steps {
+		if IsCompensation(step.Step) && step.Outcome == OutcomeSucceeded {
+			timeline.Compensated = append(timeline.Compensated, step.Step)
+		}
+	}
+	return timeline, nil
+}
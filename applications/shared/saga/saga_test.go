@@ -0,0 +1,71 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunRecordsTimeline(t *testing.T) {
+	store := NewMemoryStore()
+	recorder := NewRecorder(store)
+
+	// 1秒ずつ進む時計
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	recorder.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	sc := Context{ExecutionID: "exec-1"}
+	ctx := context.Background()
+
+	if _, err := Run(ctx, recorder, sc.ForStep("order-1", StepProcessPayment), func(context.Context) (string, error) {
+		return "ok", nil
+	}); err != nil {
+		t.Fatalf("Run() returned an error: %v", err)
+	}
+	if _, err := Run(ctx, recorder, sc.ForStep("order-1", StepCreatePurchaseHistory), func(context.Context) (string, error) {
+		return "", errors.New("boom")
+	}); err == nil {
+		t.Fatal("Run() returned no error")
+	}
+	if _, err := Run(ctx, recorder, sc.ForStep("order-1", StepCancelPayment), func(context.Context) (string, error) {
+		return "ok", nil
+	}); err != nil {
+		t.Fatalf("Run() returned an error: %v", err)
+	}
+
+	timeline, err := GetTimeline(ctx, store, "order-1")
+	if err != nil {
+		t.Fatalf("GetTimeline() returned an error: %v", err)
+	}
+
+	want := []struct {
+		step    string
+		outcome Outcome
+	}{
+		{StepProcessPayment, OutcomeSucceeded},
+		{StepCreatePurchaseHistory, OutcomeFailed},
+		{StepCancelPayment, OutcomeSucceeded},
+	}
+	if len(timeline.Steps) != len(want) {
+		t.Fatalf("len(Steps) = %d, want %d", len(timeline.Steps), len(want))
+	}
+	for i, w := range want {
+		got := timeline.Steps[i]
+		if got.Step != w.step || got.Outcome != w.outcome || got.ExecutionID != "exec-1" || got.Attempt != 1 {
+			t.Errorf("Steps[%d] = %+v, want step=%s outcome=%s", i, got, w.step, w.outcome)
+		}
+		if !got.FinishedAt.After(got.StartedAt) {
+			t.Errorf("Steps[%d] FinishedAt %v is not after StartedAt %v", i, got.FinishedAt, got.StartedAt)
+		}
+	}
+	if timeline.CurrentStep.Step != StepCancelPayment {
+		t.Errorf("CurrentStep = %s, want %s", timeline.CurrentStep.Step, StepCancelPayment)
+	}
+	if len(timeline.Compensated) != 1 || timeline.Compensated[0] != StepCancelPayment {
+		t.Errorf("Compensated = %v, want [%s]", timeline.Compensated, StepCancelPayment)
+	}
+}
@@ -0,0 +1,68 @@
+package steps
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+)
+
+// classify ステップのエラーをステートマシンが振り分けられるエラー型に変換する
+func classify(err error) error {
+	if err == nil || isClassified(err) {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, payment.ErrPaymentDeclined),
+		errors.Is(err, payment.ErrPaymentCancelled),
+		errors.Is(err, points.ErrOrderReversed),
+		errors.Is(err, inventory.ErrInsufficientStock),
+		errors.Is(err, inventory.ErrReservationExpired):
+		return &saga.BusinessRejectionError{Err: err}
+	case errors.Is(err, inventory.ErrInvalidInput),
+		errors.Is(err, inventory.ErrNotFound),
+		errors.Is(err, payment.ErrInvalidInput),
+		errors.Is(err, purchase.ErrInvalidInput),
+		errors.Is(err, points.ErrInvalidInput):
+		return &saga.PermanentError{Err: err}
+	case errors.Is(err, payment.ErrGatewayTimeout),
+		errors.Is(err, idempotency.ErrInProgress),
+		errors.Is(err, context.DeadlineExceeded):
+		return &saga.TransientError{Err: err}
+	}
+
+	// AWSのAPIエラーのうち、リトライしても成功しないクライアントエラー(権限不足・リソースなし等)は恒久的な障害とする
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorFault() == smithy.FaultClient &&
+		retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) != aws.TrueTernary {
+		return &saga.PermanentError{Err: err}
+	}
+
+	// その他(ネットワーク障害・スロットリング・サーバーエラー等)は一時的な障害とする
+	return &saga.TransientError{Err: err}
+}
+
+// isClassified 既に分類済みのエラーかどうかを返す
+func isClassified(err error) bool {
+	var transient *saga.TransientError
+	var rejection *saga.BusinessRejectionError
+	var permanent *saga.PermanentError
+	return errors.As(err, &transient) || errors.As(err, &rejection) || errors.As(err, &permanent)
+}
+
+// run 実行状態を記録しながらステップを実行し、エラーを分類する
+func run[T any](ctx context.Context, r *saga.Recorder, sc saga.Context, fn func(context.Context) (T, error)) (T, error) {
+	return saga.Run(ctx, r, sc, func(ctx context.Context) (T, error) {
+		out, err := fn(ctx)
+		return out, classify(err)
+	})
+}
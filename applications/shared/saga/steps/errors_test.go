@@ -0,0 +1,81 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "payment declined", err: fmt.Errorf("%w: insufficient_funds", payment.ErrPaymentDeclined), want: "BusinessRejectionError"},
+		{name: "payment cancelled", err: fmt.Errorf("%w: payment_id=pay-1", payment.ErrPaymentCancelled), want: "BusinessRejectionError"},
+		{name: "points reversed", err: fmt.Errorf("%w: order_id=order-1", points.ErrOrderReversed), want: "BusinessRejectionError"},
+		{name: "insufficient stock", err: fmt.Errorf("%w: sku=sku-1", inventory.ErrInsufficientStock), want: "BusinessRejectionError"},
+		{name: "reservation expired", err: inventory.ErrReservationExpired, want: "BusinessRejectionError"},
+		{name: "reservation not found", err: inventory.ErrNotFound, want: "PermanentError"},
+		{name: "invalid payment input", err: fmt.Errorf("%w: amount must be positive", payment.ErrInvalidInput), want: "PermanentError"},
+		{name: "invalid purchase input", err: purchase.ErrInvalidInput, want: "PermanentError"},
+		{name: "invalid points input", err: points.ErrInvalidInput, want: "PermanentError"},
+		{name: "gateway timeout", err: fmt.Errorf("決済ゲートウェイエラー: %w", payment.ErrGatewayTimeout), want: "TransientError"},
+		{name: "idempotency in progress", err: fmt.Errorf("%w: step=process-payment", idempotency.ErrInProgress), want: "TransientError"},
+		{name: "context deadline", err: context.DeadlineExceeded, want: "TransientError"},
+		{name: "dynamodb throttling", err: &types.ProvisionedThroughputExceededException{Message: new("throttled")}, want: "TransientError"},
+		{name: "dynamodb resource not found", err: &types.ResourceNotFoundException{Message: new("no table")}, want: "PermanentError"},
+		{name: "aws server error", err: &smithy.GenericAPIError{Code: "InternalFailure", Fault: smithy.FaultServer}, want: "TransientError"},
+		{name: "unknown error", err: errors.New("connection reset"), want: "TransientError"},
+		{name: "already classified", err: &saga.PermanentError{Err: errors.New("x")}, want: "PermanentError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classify(tt.err)
+			if name := saga.ErrorName(got); name != tt.want {
+				t.Errorf("ErrorName(classify(%v)) = %q, want %q", tt.err, name, tt.want)
+			}
+			// 元のエラーを判定できること
+			if !errors.Is(got, tt.err) {
+				t.Errorf("classify(%v) does not wrap the original error", tt.err)
+			}
+		})
+	}
+}
+
+func TestHandlersClassifyErrors(t *testing.T) {
+	h := &Handlers{
+		Payment:  payment.NewService(payment.NewFakeGateway(payment.FakeModeDecline), payment.NewMemoryStore()),
+		Purchase: purchase.NewService(purchase.NewMemoryStore()),
+		Points:   points.NewService(points.NewMemoryStore(), points.DefaultRate),
+		Recorder: saga.NewRecorder(saga.NewMemoryStore()),
+	}
+	ctx := context.Background()
+
+	in := ProcessPaymentInput{ProcessPaymentInput: payment.ProcessPaymentInput{
+		OrderID: "order-1", UserID: "user-1", Amount: 1000, Currency: "JPY", PaymentMethodToken: "tok_visa",
+	}}
+	if _, err := h.ProcessPayment(ctx, in); saga.ErrorName(err) != "BusinessRejectionError" {
+		t.Errorf("ProcessPayment() error type = %q, want %q", saga.ErrorName(err), "BusinessRejectionError")
+	}
+
+	if _, err := h.CreatePurchaseHistory(ctx, CreatePurchaseHistoryInput{}); saga.ErrorName(err) != "PermanentError" {
+		t.Errorf("CreatePurchaseHistory() error type = %q, want %q", saga.ErrorName(err), "PermanentError")
+	}
+
+	if _, err := h.AwardPoints(ctx, AwardPointsInput{}); saga.ErrorName(err) != "PermanentError" {
+		t.Errorf("AwardPoints() error type = %q, want %q", saga.ErrorName(err), "PermanentError")
+	}
+}
@@ -0,0 +1,157 @@
+// Package steps はSagaの各ステップのLambdaハンドラーを提供する。
+// 各Lambda関数とローカル実行用のオーケストレーターで同じハンドラーを使用する
+package steps
+
+import (
+	"context"
+
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/idempotency"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/inventory"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/payment"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/points"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/purchase"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/saga"
+)
+
+// ReserveInventoryInput reserve-inventoryの入力
+type ReserveInventoryInput struct {
+	inventory.ReserveInventoryInput
+	Saga saga.Context `json:"saga"`
+}
+
+// ConfirmInventoryInput confirm-inventoryの入力
+type ConfirmInventoryInput struct {
+	inventory.ConfirmInventoryInput
+	Saga saga.Context `json:"saga"`
+}
+
+// ReleaseInventoryInput release-inventoryの入力
+type ReleaseInventoryInput struct {
+	inventory.ReleaseInventoryInput
+	Saga saga.Context `json:"saga"`
+}
+
+// ProcessPaymentInput process-paymentの入力
+type ProcessPaymentInput struct {
+	payment.ProcessPaymentInput
+	Saga saga.Context `json:"saga"`
+}
+
+// CancelPaymentInput cancel-paymentの入力
+type CancelPaymentInput struct {
+	payment.CancelPaymentInput
+	Saga saga.Context `json:"saga"`
+}
+
+// CreatePurchaseHistoryInput create-purchase-historyの入力
+type CreatePurchaseHistoryInput struct {
+	purchase.CreatePurchaseHistoryInput
+	Saga saga.Context `json:"saga"`
+}
+
+// DeletePurchaseHistoryInput delete-purchase-historyの入力
+type DeletePurchaseHistoryInput struct {
+	purchase.DeletePurchaseHistoryInput
+	Saga saga.Context `json:"saga"`
+}
+
+// AwardPointsInput award-pointsの入力
+type AwardPointsInput struct {
+	points.AwardPointsInput
+	Saga saga.Context `json:"saga"`
+}
+
+// ReversePointsInput reverse-pointsの入力
+type ReversePointsInput struct {
+	points.ReversePointsInput
+	Saga saga.Context `json:"saga"`
+}
+
+// Handlers Sagaの各ステップのハンドラー。Lambda関数ごとに必要なサービスのみ設定する
+type Handlers struct {
+	Inventory *inventory.Service
+	Payment   *payment.Service
+	Purchase  *purchase.Service
+	Points    *points.Service
+	Recorder  *saga.Recorder
+	// Idempotency 副作用のあるステップの重複実行の防止。冪等キーは注文ID。nilの場合は使用しない
+	Idempotency *idempotency.Guard
+}
+
+// ReserveInventory 在庫を引き当てる
+func (h *Handlers) ReserveInventory(ctx context.Context, in ReserveInventoryInput) (*inventory.Reservation, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepReserveInventory)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*inventory.Reservation, error) {
+		return h.Inventory.Reserve(ctx, in.ReserveInventoryInput)
+	})
+}
+
+// ConfirmInventory Sagaの完了時に在庫の引当を確定する
+func (h *Handlers) ConfirmInventory(ctx context.Context, in ConfirmInventoryInput) (*inventory.Reservation, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepConfirmInventory)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*inventory.Reservation, error) {
+		return h.Inventory.Confirm(ctx, in.ConfirmInventoryInput)
+	})
+}
+
+// ReleaseInventory 補償処理として在庫の引当を解放する
+func (h *Handlers) ReleaseInventory(ctx context.Context, in ReleaseInventoryInput) (*inventory.ReleaseInventoryOutput, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepReleaseInventory)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*inventory.ReleaseInventoryOutput, error) {
+		return h.Inventory.Release(ctx, in.ReleaseInventoryInput)
+	})
+}
+
+// ProcessPayment 決済を実行する
+func (h *Handlers) ProcessPayment(ctx context.Context, in ProcessPaymentInput) (*payment.Payment, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepProcessPayment)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*payment.Payment, error) {
+		return idempotency.Do(ctx, h.Idempotency, sc.Step, in.OrderID, func(ctx context.Context) (*payment.Payment, error) {
+			return h.Payment.Process(ctx, in.ProcessPaymentInput)
+		})
+	})
+}
+
+// CancelPayment 補償処理として決済を返金(取消)する
+func (h *Handlers) CancelPayment(ctx context.Context, in CancelPaymentInput) (*payment.CancelPaymentOutput, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepCancelPayment)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*payment.CancelPaymentOutput, error) {
+		return h.Payment.Cancel(ctx, in.CancelPaymentInput)
+	})
+}
+
+// CreatePurchaseHistory 購入履歴を作成する
+func (h *Handlers) CreatePurchaseHistory(ctx context.Context, in CreatePurchaseHistoryInput) (*purchase.Purchase, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepCreatePurchaseHistory)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*purchase.Purchase, error) {
+		return idempotency.Do(ctx, h.Idempotency, sc.Step, in.OrderID, func(ctx context.Context) (*purchase.Purchase, error) {
+			return h.Purchase.Create(ctx, in.CreatePurchaseHistoryInput)
+		})
+	})
+}
+
+// DeletePurchaseHistory 補償処理として購入履歴を論理削除する
+func (h *Handlers) DeletePurchaseHistory(ctx context.Context, in DeletePurchaseHistoryInput) (*purchase.DeletePurchaseHistoryOutput, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepDeletePurchaseHistory)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*purchase.DeletePurchaseHistoryOutput, error) {
+		return h.Purchase.Delete(ctx, in.DeletePurchaseHistoryInput)
+	})
+}
+
+// AwardPoints ポイントを付与する
+func (h *Handlers) AwardPoints(ctx context.Context, in AwardPointsInput) (*points.Entry, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepAwardPoints)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*points.Entry, error) {
+		return idempotency.Do(ctx, h.Idempotency, sc.Step, in.OrderID, func(ctx context.Context) (*points.Entry, error) {
+			return h.Points.Award(ctx, in.AwardPointsInput)
+		})
+	})
+}
+
+// ReversePoints 補償処理として付与したポイントを取り消す
+func (h *Handlers) ReversePoints(ctx context.Context, in ReversePointsInput) (*points.ReversePointsOutput, error) {
+	sc := in.Saga.ForStep(in.OrderID, saga.StepReversePoints)
+	return run(ctx, h.Recorder, sc, func(ctx context.Context) (*points.ReversePointsOutput, error) {
+		return h.Points.Reverse(ctx, in.ReversePointsInput)
+	})
+}
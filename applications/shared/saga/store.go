@@ -0,0 +1,150 @@
+package saga
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/k-kazuya0926/my-modern-application-sample/applications/shared/internal/ddbattr"
+)
+
+// Store Sagaの実行状態の永続化
+type Store interface {
+	// Put ステップの実行状態を保存する。同じステップ・試行・開始日時の記録は上書きする
+	Put(ctx context.Context, sc Context) error
+	// ListByOrder 注文のステップの実行状態を開始日時の古い順に取得する
+	ListByOrder(ctx context.Context, orderID string) ([]Context, error)
+}
+
+// recordKey 注文内で実行状態を一意に識別し、開始日時順に並べるためのキー
+func recordKey(sc Context) string {
+	return ddbattr.FormatTime(sc.StartedAt) + "#" + sc.Step + "#" + strconv.Itoa(sc.Attempt)
+}
+
+// MemoryStore テスト・ローカル実行用のインメモリストア
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]map[string]Context
+}
+
+// NewMemoryStore MemoryStoreを生成する
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]map[string]Context)}
+}
+
+// Put ステップの実行状態を保存する
+func (s *MemoryStore) Put(_ context.Context, sc Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records[sc.OrderID] == nil {
+		s.records[sc.OrderID] = make(map[string]Context)
+	}
+	s.records[sc.OrderID][recordKey(sc)] = sc
+	return nil
+}
+
+// ListByOrder 注文のステップの実行状態を開始日時の古い順に取得する
+func (s *MemoryStore) ListByOrder(_ context.Context, orderID string) ([]Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.records[orderID]))
+	for key := range s.records[orderID] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := make([]Context, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, s.records[orderID][key])
+	}
+	return records, nil
+}
+
+// DynamoDBAPI DynamoDBStoreが使用するDynamoDBクライアントのメソッド
+type DynamoDBAPI interface {
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// DynamoDBStore DynamoDBのsaga-stateテーブルを使用するストア
+type DynamoDBStore struct {
+	client    DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBStore DynamoDBStoreを生成する
+func NewDynamoDBStore(client DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName}
+}
+
+// Put ステップの実行状態を保存する
+func (s *DynamoDBStore) Put(ctx context.Context, sc Context) error {
+	item := map[string]types.AttributeValue{
+		"order_id":     ddbattr.S(sc.OrderID),
+		"record_key":   ddbattr.S(recordKey(sc)),
+		"execution_id": ddbattr.S(sc.ExecutionID),
+		"step":         ddbattr.S(sc.Step),
+		"attempt":      ddbattr.N(int64(sc.Attempt)),
+		"started_at":   ddbattr.Time(sc.StartedAt),
+		"outcome":      ddbattr.S(string(sc.Outcome)),
+	}
+	if !sc.FinishedAt.IsZero() {
+		item["finished_at"] = ddbattr.Time(sc.FinishedAt)
+	}
+	if sc.Error != "" {
+		item["error"] = ddbattr.S(sc.Error)
+	}
+
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	return err
+}
+
+// ListByOrder 注文のステップの実行状態を開始日時の古い順に取得する
+func (s *DynamoDBStore) ListByOrder(ctx context.Context, orderID string) ([]Context, error) {
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("order_id = :order_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":order_id": ddbattr.S(orderID),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+
+	var records []Context
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			sc := Context{
+				ExecutionID: ddbattr.String(item, "execution_id"),
+				OrderID:     ddbattr.String(item, "order_id"),
+				Step:        ddbattr.String(item, "step"),
+				Outcome:     Outcome(ddbattr.String(item, "outcome")),
+				Error:       ddbattr.String(item, "error"),
+			}
+			attempt, err := ddbattr.Int64(item, "attempt")
+			if err != nil {
+				return nil, err
+			}
+			sc.Attempt = int(attempt)
+			if sc.StartedAt, err = ddbattr.ParseTime(item, "started_at"); err != nil {
+				return nil, err
+			}
+			if sc.FinishedAt, err = ddbattr.ParseTime(item, "finished_at"); err != nil {
+				return nil, err
+			}
+			records = append(records, sc)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,46 @@
+// Package sqsbatch はSQSトリガーのLambda関数で、失敗したメッセージだけを再配信させる部分バッチレスポンスを提供する。
+// イベントソースマッピングのFunctionResponseTypesにReportBatchItemFailuresの指定が必要
+package sqsbatch
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Handler メッセージ1件の処理
+type Handler func(ctx context.Context, msg events.SQSMessage) error
+
+// Process バッチ内の各メッセージをfnで処理し、失敗したメッセージのIDをBatchItemFailuresに設定して返す。
+// 成功したメッセージはキューから削除され、失敗したメッセージだけが可視性タイムアウト後に再配信される。
+// FIFOキューでは順序を保つため、最初に失敗したメッセージ以降を処理せずに全て失敗とする
+func Process(ctx context.Context, sqsEvent events.SQSEvent, fn Handler) events.SQSEventResponse {
+	var resp events.SQSEventResponse
+	for i, record := range sqsEvent.Records {
+		err := fn(ctx, record)
+		if err == nil {
+			continue
+		}
+
+		log.Printf("メッセージの処理に失敗しました: message_id=%s, error=%v", record.MessageId, err)
+		resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		if isFIFO(record) {
+			for _, rest := range sqsEvent.Records[i+1:] {
+				resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: rest.MessageId})
+			}
+			log.Printf("FIFOキューのため後続のメッセージを処理せずに失敗とします: count=%d", len(sqsEvent.Records)-i-1)
+			break
+		}
+	}
+	if len(resp.BatchItemFailures) > 0 {
+		log.Printf("バッチ処理結果: total=%d, failed=%d", len(sqsEvent.Records), len(resp.BatchItemFailures))
+	}
+	return resp
+}
+
+// isFIFO メッセージがFIFOキューから配信されたかどうかを返す
+func isFIFO(msg events.SQSMessage) bool {
+	return strings.HasSuffix(msg.EventSourceARN, ".fifo")
+}
@@ -0,0 +1,81 @@
+package sqsbatch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func newEvent(queueArn string, ids ...string) events.SQSEvent {
+	var e events.SQSEvent
+	for _, id := range ids {
+		e.Records = append(e.Records, events.SQSMessage{MessageId: id, Body: id, EventSourceARN: queueArn})
+	}
+	return e
+}
+
+// failedIDs 部分バッチレスポンスの失敗したメッセージIDを返す
+func failedIDs(resp events.SQSEventResponse) []string {
+	var ids []string
+	for _, f := range resp.BatchItemFailures {
+		ids = append(ids, f.ItemIdentifier)
+	}
+	return ids
+}
+
+func TestProcess(t *testing.T) {
+	fail := map[string]bool{"msg-2": true, "msg-4": true}
+
+	tests := []struct {
+		name          string
+		queueArn      string
+		wantFailed    []string
+		wantProcessed []string
+	}{
+		{
+			name:          "standard queue",
+			queueArn:      "arn:aws:sqs:ap-northeast-1:123456789012:queue",
+			wantFailed:    []string{"msg-2", "msg-4"},
+			wantProcessed: []string{"msg-1", "msg-2", "msg-3", "msg-4", "msg-5"},
+		},
+		{
+			name:          "fifo queue",
+			queueArn:      "arn:aws:sqs:ap-northeast-1:123456789012:queue.fifo",
+			wantFailed:    []string{"msg-2", "msg-3", "msg-4", "msg-5"},
+			wantProcessed: []string{"msg-1", "msg-2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var processed []string
+			resp := Process(context.Background(), newEvent(tt.queueArn, "msg-1", "msg-2", "msg-3", "msg-4", "msg-5"), func(_ context.Context, msg events.SQSMessage) error {
+				processed = append(processed, msg.MessageId)
+				if fail[msg.MessageId] {
+					return errors.New("failed")
+				}
+				return nil
+			})
+
+			if got := failedIDs(resp); !reflect.DeepEqual(got, tt.wantFailed) {
+				t.Errorf("BatchItemFailures = %v, want %v", got, tt.wantFailed)
+			}
+			if !reflect.DeepEqual(processed, tt.wantProcessed) {
+				t.Errorf("processed = %v, want %v", processed, tt.wantProcessed)
+			}
+		})
+	}
+}
+
+func TestProcessAllSucceeded(t *testing.T) {
+	resp := Process(context.Background(), newEvent("arn:aws:sqs:ap-northeast-1:123456789012:queue", "msg-1", "msg-2"), func(context.Context, events.SQSMessage) error {
+		return nil
+	})
+	// 全て成功した場合は空のリストを返し、バッチ全体を削除させること
+	if len(resp.BatchItemFailures) != 0 {
+		t.Errorf("BatchItemFailures = %v, want empty", resp.BatchItemFailures)
+	}
+}
@@ -0,0 +1,82 @@
+// Package unsubscribe はメール配信の配信停止リンクに使用する、HMACで署名したトークンの発行と検証を提供する
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken トークンの形式または署名が不正
+	ErrInvalidToken = errors.New("invalid unsubscribe token")
+	// ErrExpiredToken トークンの有効期限切れ
+	ErrExpiredToken = errors.New("unsubscribe token expired")
+)
+
+// Claims トークンに含める配信停止の対象
+type Claims struct {
+	// Email 配信を停止するメールアドレス
+	Email string `json:"e"`
+	// List 配信を停止する配信リスト
+	List string `json:"l"`
+	// ExpiresAt 有効期限(UNIX時間の秒)
+	ExpiresAt int64 `json:"x"`
+}
+
+// Signer トークンの発行と検証
+type Signer struct {
+	key []byte
+	now func() time.Time
+}
+
+// NewSigner 署名の鍵を指定してSignerを生成する
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key, now: time.Now}
+}
+
+// Sign メールアドレスと配信リストの配信停止のトークンを発行する。
+// トークンは"内容.署名"(それぞれURLで使用できるbase64)の形式
+func (s *Signer) Sign(email, list string, expiresAt time.Time) (string, error) {
+	payload, err := json.Marshal(Claims{Email: email, List: list, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded)), nil
+}
+
+// Verify トークンの署名と有効期限を検証し、配信停止の対象を返す
+func (s *Signer) Verify(token string) (*Claims, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, s.mac(encoded)) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Email == "" || claims.List == "" {
+		return nil, ErrInvalidToken
+	}
+	if !s.now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+// mac トークンの内容のHMAC-SHA256を計算する
+func (s *Signer) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
@@ -0,0 +1,71 @@
+package unsubscribe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSigner(key string, now time.Time) *Signer {
+	s := NewSigner([]byte(key))
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newTestSigner("secret", now)
+
+	token, err := s.Sign("user+tag@example.com", "news", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Sign() returned an error: %v", err)
+	}
+	// URLのクエリにそのまま使用できること
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token %q is not URL-safe", token)
+	}
+
+	claims, err := s.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() returned an error: %v", err)
+	}
+	if claims.Email != "user+tag@example.com" || claims.List != "news" || claims.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Errorf("Verify() = %+v", claims)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newTestSigner("secret", now)
+	token, err := s.Sign("user@example.com", "news", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Sign() returned an error: %v", err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+	other, err := s.Sign("other@example.com", "news", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Sign() returned an error: %v", err)
+	}
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	tests := map[string]struct {
+		signer *Signer
+		token  string
+		want   error
+	}{
+		"different key":      {newTestSigner("other", now), token, ErrInvalidToken},
+		"tampered payload":   {s, otherPayload + "." + signature, ErrInvalidToken},
+		"missing signature":  {s, payload, ErrInvalidToken},
+		"malformed":          {s, "not-a-token.!!", ErrInvalidToken},
+		"expired":            {newTestSigner("secret", now.Add(time.Hour)), token, ErrExpiredToken},
+		"valid until expiry": {newTestSigner("secret", now.Add(time.Hour-time.Second)), token, nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := tt.signer.Verify(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}